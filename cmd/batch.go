@@ -0,0 +1,93 @@
+/*
+Copyright © 2025 Achno <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"strings"
+
+	"github.com/Achno/gowall/internal/image"
+	"github.com/Achno/gowall/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	recursive      bool
+	includeFilter  []string
+	excludeFilter  []string
+	followSymlinks bool
+	preserveTree   bool
+	forceBatch     bool
+	resumeBatch    bool
+)
+
+// batchInputs resolves the images of a batch run from the -b flag, a "dir/#" argument, --resume,
+// glob patterns, directories with --recursive or several paths. isBatch is false when the
+// arguments describe a single image
+func batchInputs(args []string) (files []string, opts image.BatchOptions, isBatch bool, err error) {
+	opts = batchOptions()
+	opts.Manifest = true
+	opts.Force = forceBatch
+	opts.Resume = resumeBatch
+
+	expandOpts := utils.ExpandOptions{
+		Recursive:      recursive,
+		Include:        includeFilter,
+		Exclude:        excludeFilter,
+		FollowSymlinks: followSymlinks,
+	}
+
+	var inputs []string
+	switch {
+	case len(shared.BatchFiles) > 0:
+		inputs = shared.BatchFiles
+	case len(args) == 0 && resumeBatch:
+		// the inputs come from the manifest of the interrupted run
+	case len(args) == 0:
+		return nil, opts, false, nil
+	case len(args) > 1:
+		inputs = args
+	case strings.HasSuffix(args[0], "#"):
+		inputs = []string{utils.DiscardLastCharacter(args[0])}
+	case utils.HasGlobMeta(args[0]) || recursive:
+		inputs = args
+	default:
+		return nil, opts, false, nil
+	}
+
+	if outputName != "" {
+		return nil, opts, true, utils.InvalidParameter("You cannot use the '-o' flag and Batch conversion together")
+	}
+	if len(inputs) == 0 {
+		return nil, opts, true, nil
+	}
+
+	found, err := utils.ExpandInputs(inputs, expandOpts)
+	if err != nil {
+		return nil, opts, true, err
+	}
+
+	if preserveTree {
+		opts.RelativeOutputs = make(map[string]string, len(found))
+	}
+	for _, f := range found {
+		files = append(files, f.Path)
+		if preserveTree {
+			opts.RelativeOutputs[f.Path] = f.Rel
+		}
+	}
+
+	return files, opts, true, nil
+}
+
+// addBatchFlags registers the flags that control how batch inputs are found
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVarP(&shared.BatchFiles, "batch", "b", nil, "Usage: --batch file1.png,file2.png ...")
+	cmd.Flags().BoolVar(&recursive, "recursive", false, "Also process images in subdirectories of a directory")
+	cmd.Flags().StringSliceVar(&includeFilter, "include", nil, "Only process files matching these patterns, e.g. --include '*.png,nature/**'")
+	cmd.Flags().StringSliceVar(&excludeFilter, "exclude", nil, "Skip files matching these patterns, e.g. --exclude '*-old.*'")
+	cmd.Flags().BoolVar(&followSymlinks, "follow-symlinks", false, "Descend into symlinked directories")
+	cmd.Flags().BoolVar(&forceBatch, "force", false, "Process every image even when the manifest says its output is up to date")
+	cmd.Flags().BoolVar(&resumeBatch, "resume", false, "Finish the interrupted batch run, processing only the images it didn't complete")
+	cmd.Flags().BoolVar(&preserveTree, "preserve-tree", false, "Mirror the input directory tree in the output folder instead of flattening it")
+}
@@ -23,11 +23,11 @@ var bgCmd = &cobra.Command{
 	Use:   "bg [PATH]",
 	Short: "Removes the background of the image",
 	Long:  `Removes the background of an image. You can modify the options to achieve better results `,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		switch {
 
 		case len(args) > 0:
-			fmt.Println("Removing background...")
+			fmt.Fprintln(image.Messages(), "Removing background...")
 			processor := &image.BackgroundProcessor{}
 			processor.SetOptions(
 				image.WithConvergence(convergence),
@@ -38,15 +38,11 @@ var bgCmd = &cobra.Command{
 
 			expandFile := utils.ExpandHomeDirectory(args)
 
-			path, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme)
-			utils.HandleError(err, "Error Processing Image")
-
-			err = image.OpenImage(path)
-			utils.HandleError(err, "Error opening image")
+			return processImage(cmd.Context(), expandFile[0], processor, "")
 
 		default:
-			fmt.Println("Error: requires at least 1 arg(s), only received 0")
 			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 arg(s), only received 0")
 		}
 	},
 }
@@ -57,5 +53,7 @@ func init() {
 	bgCmd.Flags().IntVarP(&numRoutines, "routines", "r", 4, "")
 	bgCmd.Flags().Float64VarP(&convergence, "conv", "c", 0.001, "")
 	bgCmd.Flags().Float64VarP(&sampleRate, "sRate", "s", 0.5, "")
+	bgCmd.Flags().StringVarP(&formatFlag, "format", "f", "", "Usage: --format [Extension]")
+	bgCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension). Use '-' to write to stdout, which needs --format")
 
 }
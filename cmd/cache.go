@@ -0,0 +1,161 @@
+/*
+Copyright © 2025 Achno <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Achno/gowall/internal/image"
+	"github.com/spf13/cobra"
+)
+
+var warmThemes []string
+
+// cacheCmd groups the commands that manage the CLUT cache
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the cache of generated CLUTs",
+	Long:  `The default convert backend generates a CLUT per theme, level and mapper and caches it in $XDG_CACHE_HOME/gowall/cluts (~/.cache/gowall/cluts). These commands list, prune, clear and pregenerate the cached CLUTs`,
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the cached CLUTs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := image.ListCLUTCache()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("The CLUT cache is empty")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "THEME\tHASH\tLEVEL\tMAPPER\tSIZE\tLAST USED")
+		var total int64
+		for _, entry := range entries {
+			total += entry.Size
+			if entry.Theme == "" {
+				fmt.Fprintf(w, "%s\t-\t-\t-\t%s\t%s\n", filepath.Base(entry.Path), formatSize(entry.Size), entry.LastUsed.Format("2006-01-02 15:04"))
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", entry.Theme, entry.Hash, entry.Level, entry.Mapper, formatSize(entry.Size), entry.LastUsed.Format("2006-01-02 15:04"))
+		}
+		w.Flush()
+
+		fmt.Printf("\n%d CLUTs, %s\n", len(entries), formatSize(total))
+		return nil
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove the CLUTs of themes that were edited or removed",
+	Long:  `Removes the cached CLUTs whose colors don't match any loaded theme anymore, and the CLUTs of older gowall versions`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removed, err := image.PruneCLUTCache()
+		for _, entry := range removed {
+			fmt.Fprintf(image.Messages(), "Removed %s\n", entry.Path)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(image.Messages(), "Pruned %d CLUTs, %s\n", len(removed), formatSize(totalSize(removed)))
+		return nil
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Remove every cached CLUT",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removed, err := image.ClearCLUTCache()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(image.Messages(), "Removed %d CLUTs, %s\n", len(removed), formatSize(totalSize(removed)))
+		return nil
+	},
+}
+
+var cacheWarmCmd = &cobra.Command{
+	Use:   "warm",
+	Short: "Generate the CLUTs of all themes ahead of time",
+	Long:  `Generates the CLUT of every theme, or of the --theme list, with the given --level and --mapper so the first convert with them is fast. Up to --jobs CLUTs are generated at the same time`,
+	Example: `gowall cache warm
+gowall cache warm -t nord,gruvbox --level 12`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		themes := warmThemes
+		if len(themes) == 0 {
+			for _, theme := range image.ListThemes() {
+				// emacs themes are listed by name and by file path, the name is enough
+				if !strings.ContainsAny(theme, `/\`) {
+					themes = append(themes, theme)
+				}
+			}
+			sort.Strings(themes)
+		}
+
+		converter := themeConverter()
+		for _, theme := range themes {
+			if err := converter.Validate(theme); err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(image.Messages(), "Generating the CLUTs of %d themes...\n", len(themes))
+		err := image.WarmCLUTCache(cmd.Context(), themes, converter, image.WarmOptions{
+			Jobs: jobs,
+			OnDone: func(theme string, err error) {
+				if err == nil {
+					fmt.Fprintf(image.Messages(), "  %s\n", theme)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("while warming the CLUT cache: %w", err)
+		}
+		return nil
+	},
+}
+
+// formatSize formats a size in bytes with a binary unit, e.g. 1.5 MiB
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGT"[exp])
+}
+
+func totalSize(entries []image.CacheEntry) int64 {
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	return total
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd, cachePruneCmd, cacheClearCmd, cacheWarmCmd)
+
+	cacheWarmCmd.Flags().StringSliceVarP(&warmThemes, "theme", "t", nil, "Usage : --theme nord,gruvbox only warm these themes, defaults to all")
+	addCLUTFlags(cacheWarmCmd)
+
+	cacheWarmCmd.RegisterFlagCompletionFunc("theme", themeCompletion)
+}
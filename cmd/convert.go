@@ -4,10 +4,11 @@ Copyright © 2024 Achnologia <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"strconv"
-	"strings"
 
+	haldclut "github.com/Achno/gowall/internal/backends/colorthief/haldClut"
 	"github.com/Achno/gowall/internal/image"
 	"github.com/Achno/gowall/utils"
 	"github.com/spf13/cobra"
@@ -16,66 +17,65 @@ import (
 var formatFlag string
 var colorPair []string
 var outputName string
+var distanceMetric string
+var ditherMode string
+var ditherStrength float64
+var preserveLuminance float64
+var convertStrength float64
+var clutLevel int
+var clutInterpolation string
+var clutMapper string
+var lutPath string
+var mapperSigma, mapperPower float64
+var mapperNeighbours int
 
 var convertCmd = &cobra.Command{
 	Use:   "convert [image path / batch flag]",
 	Short: "Convert an img's color scheme",
 	Long:  `Convert an img's color scheme`,
 	// Args: cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		switch {
-
-		case len(shared.BatchFiles) > 0:
-			fmt.Println("Processing batch files...")
-			processor := &image.ThemeConverter{}
-			expandedFiles := utils.ExpandHomeDirectory(shared.BatchFiles)
-			err := image.ProcessBatchImgs(expandedFiles, shared.Theme, processor)
-
-			utils.HandleError(err)
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, batchOpts, isBatch, err := batchInputs(args)
+		if err != nil {
+			return err
+		}
+		if renderTemplatesFlag && (lutPath != "" || len(colorPair) > 0 || formatOnly(cmd)) {
+			return utils.InvalidParameter("--render-templates needs a theme conversion, it can't be used with --lut, --replace or only --format")
+		}
 
-		case len(args) > 0 && strings.HasSuffix(args[0], "#"):
-			fmt.Println("Processing directory...")
-			processor := &image.ThemeConverter{}
-			path := utils.DiscardLastCharacter(args[0])
-			files, err := utils.ExpandHashtag(path)
+		switch {
 
-			utils.HandleError(err, "Error ExpandingHashTag")
+		case isBatch:
+			fmt.Fprintln(image.Messages(), "Processing batch files...")
+			processor := convertProcessor()
+			err := image.ProcessBatchImgs(cmd.Context(), files, shared.Theme, processor, batchOpts)
 
-			if outputName != "" {
-				utils.HandleError(fmt.Errorf("You cannot use the '-o' flag and Batch conversion together"), "Error")
+			if err != nil {
+				return err
+			}
+			if renderTemplatesFlag {
+				return renderConvertTemplates(cmd.Context(), "")
 			}
 
-			err = image.ProcessBatchImgs(files, shared.Theme, processor)
-
-			utils.HandleError(err)
-
-		case len(args) > 0 && formatFlag != "":
-			fmt.Println("Processing single image...")
+		case len(args) > 0 && formatOnly(cmd):
+			fmt.Fprintln(image.Messages(), "Processing single image...")
 			processor := &image.NoOpImageProcessor{}
 			expandFile := utils.ExpandHomeDirectory(args)
 
-			opts := image.ProcessOptions{
-				SaveToFile: true,
-				OutputExt:  formatFlag,
-				OutputName: outputName,
-			}
-
-			path, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme, opts)
-			utils.HandleError(err, "Error Processing Image")
-
-			err = image.OpenImage(path)
-			utils.HandleError(err, "Error opening image")
+			return processImage(cmd.Context(), expandFile[0], processor, shared.Theme)
 
 		case len(args) > 0 && len(colorPair) > 0:
-			fmt.Println("Replacing color...")
+			fmt.Fprintln(image.Messages(), "Replacing color...")
 			expandFile := utils.ExpandHomeDirectory(args)
 			processor := &image.ReplaceProcessor{}
 
 			pairSlice, err := cmd.Flags().GetStringSlice("replace")
-			utils.HandleError(err)
+			if err != nil {
+				return err
+			}
 
 			if len(pairSlice) < 2 {
-				utils.HandleError(fmt.Errorf("specify both the color to be replaced and the replacement color"), "Error")
+				return utils.InvalidParameter("specify both the color to be replaced and the replacement color")
 			}
 
 			processor.FromColor = pairSlice[0]
@@ -83,43 +83,77 @@ var convertCmd = &cobra.Command{
 			processor.Threshold = 8.5
 			if len(pairSlice) > 2 {
 				processor.Threshold, err = strconv.ParseFloat(pairSlice[2], 64)
-				utils.HandleError(err, "Error: either specify the threshold or remove the comma")
-			}
-
-			opts := image.ProcessOptions{
-				OutputName: outputName,
-				SaveToFile: true,
+				if err != nil {
+					return utils.InvalidParameter("either specify the threshold or remove the comma")
+				}
 			}
 
-			path, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme, opts)
-			utils.HandleError(err, "Error Processing Image")
-
-			err = image.OpenImage(path)
-			utils.HandleError(err, "Error opening image")
+			return processImage(cmd.Context(), expandFile[0], processor, shared.Theme)
 
 		case len(args) > 0:
-			fmt.Println("Processing single image...")
-			processor := &image.ThemeConverter{}
+			fmt.Fprintln(image.Messages(), "Processing single image...")
+			processor := convertProcessor()
 			expandFile := utils.ExpandHomeDirectory(args)
 
-			opts := image.ProcessOptions{
-				OutputName: outputName,
-				SaveToFile: true,
+			if !renderTemplatesFlag {
+				return processImage(cmd.Context(), expandFile[0], processor, shared.Theme)
 			}
-			path, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme, opts)
-
-			utils.HandleError(err, "Error Processing Image")
-			err = image.OpenImage(path)
-
-			utils.HandleError(err, "Error opening image")
+			// rendered before reportResult, which previews the image
+			result, _, err := image.ProcessImgResult(cmd.Context(), expandFile[0], processor, shared.Theme, processOptions())
+			if err == nil {
+				err = renderConvertTemplates(cmd.Context(), result.Output)
+			}
+			return reportResult(result, err)
 
 		default:
-			fmt.Println("Error: requires at least 1 arg(s), only received 0")
 			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 arg(s), only received 0")
 		}
+		return nil
 	},
 }
 
+// formatOnly reports whether convert only changes the format of the image, --format without -t, --lut or --replace
+func formatOnly(cmd *cobra.Command) bool {
+	return formatFlag != "" && !cmd.Flags().Changed("theme") && lutPath == "" && len(colorPair) == 0
+}
+
+// renderConvertTemplates renders the templates with the --theme the image was converted to
+func renderConvertTemplates(ctx context.Context, wallpaper string) error {
+	theme, err := image.SelectTheme(shared.Theme)
+	if err != nil {
+		return err
+	}
+	return renderThemeTemplates(ctx, theme, wallpaper)
+}
+
+// convertProcessor returns the processor of the convert flags, a LUT when --lut is given or a ThemeConverter
+func convertProcessor() image.ImageProcessor {
+	if lutPath != "" {
+		return &image.LUTProcessor{Path: lutPath, Interpolation: clutInterpolation}
+	}
+	return themeConverter()
+}
+
+// themeConverter returns a ThemeConverter configured by the convert flags
+func themeConverter() *image.ThemeConverter {
+	return &image.ThemeConverter{
+		Metric:         distanceMetric,
+		Dither:         ditherMode,
+		DitherStrength: ditherStrength,
+		Level:          clutLevel,
+		Interpolation:  clutInterpolation,
+		Mapper:         clutMapper,
+		MapperOptions: haldclut.MapperOptions{
+			Sigma:      mapperSigma,
+			Power:      mapperPower,
+			Neighbours: mapperNeighbours,
+		},
+		PreserveLuminance: preserveLuminance,
+		Strength:          convertStrength,
+	}
+}
+
 func themeCompletion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	return image.ListThemes(), cobra.ShellCompDirectiveNoFileComp
 }
@@ -127,10 +161,41 @@ func themeCompletion(cmd *cobra.Command, args []string, toComplete string) ([]st
 func init() {
 	rootCmd.AddCommand(convertCmd)
 	convertCmd.Flags().StringVarP(&shared.Theme, "theme", "t", "catppuccin", "Usage : --theme [ThemeName]")
-	convertCmd.Flags().StringSliceVarP(&shared.BatchFiles, "batch", "b", nil, "Usage: --batch file1.png,file2.png ...")
-	convertCmd.Flags().StringVarP(&formatFlag, "format", "f", "", "Usage: --format [Extension]")
+	addBatchFlags(convertCmd)
+	convertCmd.Flags().StringVarP(&formatFlag, "format", "f", "", "Usage: --format [Extension] (required format when using --output -)")
 	convertCmd.Flags().StringSliceVarP(&colorPair, "replace", "r", nil, "Usage: --replace #FromColor,#ToColor")
-	convertCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension) Can only be used alongside with -t,-r,-f flags")
+	convertCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension) Can only be used alongside with -t,-r,-f flags. Use '-' to write to stdout, which needs --format")
+	convertCmd.Flags().StringVar(&distanceMetric, "metric", "", "Usage: --metric [rgb|cie76|cie94|ciede2000|oklab] color distance of the nn backend, defaults to ColorDistanceMetric in the config")
+	convertCmd.Flags().StringVar(&ditherMode, "dither", "", "Usage: --dither [floyd-steinberg|atkinson|sierra|bayer2|bayer4|bayer8|blue-noise] dither to the theme colors instead of banding")
+	convertCmd.Flags().Float64Var(&ditherStrength, "dither-strength", 1, "Usage: --dither-strength [0-1] how much dithering to apply")
+	convertCmd.Flags().Float64Var(&preserveLuminance, "preserve-luminance", 0, "Usage: --preserve-luminance [0-1] how much of the original lightness to keep, 1 maps only hue and chroma to the theme")
+	convertCmd.Flags().Float64Var(&convertStrength, "strength", 1, "Usage: --strength [0-1] mix of the converted image over the original")
+	convertCmd.Flags().StringVar(&clutInterpolation, "interpolation", "", "Usage: --interpolation [nearest|trilinear|tetrahedral] between CLUT colors, defaults to CLUTInterpolation in the config or nearest")
+	convertCmd.Flags().StringVar(&lutPath, "lut", "", "Usage: --lut file.cube apply a .cube 3D LUT or a HaldCLUT .png instead of a theme")
+	addCLUTFlags(convertCmd)
+	addRenderTemplatesFlag(convertCmd)
 
 	convertCmd.RegisterFlagCompletionFunc("theme", themeCompletion)
+	convertCmd.RegisterFlagCompletionFunc("metric", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return image.DistanceMetrics(), cobra.ShellCompDirectiveNoFileComp
+	})
+	convertCmd.RegisterFlagCompletionFunc("dither", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return image.DitherModes(), cobra.ShellCompDirectiveNoFileComp
+	})
+	convertCmd.RegisterFlagCompletionFunc("interpolation", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return image.Interpolations(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// addCLUTFlags registers the flags that choose how a theme's CLUT is generated
+func addCLUTFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&clutLevel, "level", 0, "Usage: --level [4-16] HaldCLUT level, higher is more accurate but slower to generate, defaults to CLUTLevel in the config or 8")
+	cmd.Flags().StringVar(&clutMapper, "mapper", "", "Usage: --mapper [rbf|shepard|oklab-rbf|knn|luminance] how the CLUT blends the theme colors, defaults to CLUTMapper in the config or rbf")
+	cmd.Flags().Float64Var(&mapperSigma, "sigma", 0, "Usage: --sigma [number] spread of the rbf (default 50), oklab-rbf and luminance (default 10) mappers, higher blends more colors")
+	cmd.Flags().Float64Var(&mapperPower, "power", 0, "Usage: --power [number] distance exponent of the shepard and knn mappers (default 2), higher stays closer to the theme colors")
+	cmd.Flags().IntVar(&mapperNeighbours, "neighbours", 0, "Usage: --neighbours [number] theme colors blended by the knn mapper (default 3)")
+
+	cmd.RegisterFlagCompletionFunc("mapper", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return haldclut.MapperNames(), cobra.ShellCompDirectiveNoFileComp
+	})
 }
@@ -20,17 +20,21 @@ var drawCmd = &cobra.Command{
 	Use:   "draw [PATH] ",
 	Short: "draw a border with a color and thickness (currently)",
 	Long:  `The draw command allows you to draw a plethora of effects. Currently only drawing a border is supported with more to come`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		switch {
 		case len(args) > 0:
-			fmt.Println("Processing single image...")
+			fmt.Fprintln(image.Messages(), "Processing single image...")
 
 			hex, err := cmd.Flags().GetString("color")
-			utils.HandleError(err, "Error")
+			if err != nil {
+				return err
+			}
 
 			clr, err := image.HexToRGBA(hex)
-			utils.HandleError(err, "Error")
+			if err != nil {
+				return fmt.Errorf("%w: %w", utils.ErrInvalidParameter, err)
+			}
 
 			processor := &image.DrawProcessor{
 				Color:           clr,
@@ -38,15 +42,11 @@ var drawCmd = &cobra.Command{
 			}
 			expandFile := utils.ExpandHomeDirectory(args)
 
-			path, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme)
-			utils.HandleError(err)
-
-			err = image.OpenImage(path)
-			utils.HandleError(err)
+			return processImage(cmd.Context(), expandFile[0], processor, "")
 
 		default:
-			fmt.Println("Error: requires at least 1 arg(s) and options, only received 0")
 			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 arg(s) and options, only received 0")
 		}
 	},
 }
@@ -55,5 +55,7 @@ func init() {
 	rootCmd.AddCommand(drawCmd)
 	drawCmd.Flags().StringVarP(&colorB, "color", "c", "#5D3FD3", "--color #5D3FD3")
 	drawCmd.Flags().IntVarP(&BorderThickness, "borderThickness", "b", 5, "-b 5")
+	drawCmd.Flags().StringVarP(&formatFlag, "format", "f", "", "Usage: --format [Extension]")
+	drawCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension). Use '-' to write to stdout, which needs --format")
 
 }
@@ -18,72 +18,58 @@ var effectsCmd = &cobra.Command{
 	Use:   "effects [effect]",
 	Short: "Apply various effects to your images",
 	Long:  `Apply various effects to your images like flip,mirror,grayscale,br(brightness),and more`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) < 2 {
-			fmt.Println("Error: requires 1 command and 1arg(s), only received 0")
 			_ = cmd.Usage()
 			showAvailableEffects()
-			return
+			return utils.InvalidParameter("requires 1 command and 1 arg(s), only received %d", len(args))
 		}
-		switch strings.ToLower(args[0]) {
+		operation = strings.ToLower(args[0])
+		switch operation {
 
 		case "flip":
-			fmt.Println("Processing image...")
+			fmt.Fprintln(image.Messages(), "Processing image...")
 			processor := &image.FlipProcessor{}
 			expandFile := utils.ExpandHomeDirectory(args)
-			path, _, err := image.ProcessImg(expandFile[1], processor, shared.Theme)
-
-			utils.HandleError(err)
-			err = image.OpenImage(path)
-			utils.HandleError(err)
+			return processImage(cmd.Context(), expandFile[1], processor, "")
 
 		case "mirror":
-			fmt.Println("Processing image...")
+			fmt.Fprintln(image.Messages(), "Processing image...")
 			processor := &image.MirrorProcessor{}
 			expandFile := utils.ExpandHomeDirectory(args)
-			path, _, err := image.ProcessImg(expandFile[1], processor, shared.Theme)
-
-			utils.HandleError(err)
-			err = image.OpenImage(path)
-			utils.HandleError(err)
+			return processImage(cmd.Context(), expandFile[1], processor, "")
 
 		case "grayscale":
-			fmt.Println("Processing image...")
+			fmt.Fprintln(image.Messages(), "Processing image...")
 			processor := &image.GrayScaleProcessor{}
 			expandFile := utils.ExpandHomeDirectory(args)
-			path, _, err := image.ProcessImg(expandFile[1], processor, shared.Theme)
-
-			utils.HandleError(err)
-			err = image.OpenImage(path)
-			utils.HandleError(err)
+			return processImage(cmd.Context(), expandFile[1], processor, "")
 
 		case "br":
-			fmt.Println("Processing image...")
+			fmt.Fprintln(image.Messages(), "Processing image...")
 			processor := &image.BrightnessProcessor{Factor: factor}
 			expandFile := utils.ExpandHomeDirectory(args)
-			path, _, err := image.ProcessImg(expandFile[1], processor, shared.Theme)
-
-			utils.HandleError(err)
-			err = image.OpenImage(path)
-			utils.HandleError(err)
+			return processImage(cmd.Context(), expandFile[1], processor, "")
 
 		default:
-			fmt.Println("Error: requires at least 1 arg(s), only received 0")
 			_ = cmd.Usage()
 			showAvailableEffects()
+			return utils.InvalidParameter("unknown effect: %s", args[0])
 		}
 	},
 }
 
 func showAvailableEffects() {
-	fmt.Println("\nAvailable Effects:")
-	fmt.Println("  flip       Flips the image horizontally")
-	fmt.Println("  mirror     Mirrors the image horizontally")
-	fmt.Println("  grayscale  Converts image to grayscale (shades of gray)")
-	fmt.Println("  br         Increases/Decreases the brightness")
+	fmt.Fprintln(image.Messages(), "\nAvailable Effects:")
+	fmt.Fprintln(image.Messages(), "  flip       Flips the image horizontally")
+	fmt.Fprintln(image.Messages(), "  mirror     Mirrors the image horizontally")
+	fmt.Fprintln(image.Messages(), "  grayscale  Converts image to grayscale (shades of gray)")
+	fmt.Fprintln(image.Messages(), "  br         Increases/Decreases the brightness")
 }
 
 func init() {
 	rootCmd.AddCommand(effectsCmd)
 	effectsCmd.Flags().Float64VarP(&factor, "factor", "f", 1.1, "1.2 increases brightness by 20%, 0.8 decreases brightness by 20%. Default 1.1")
+	effectsCmd.Flags().StringVar(&formatFlag, "format", "", "Usage: --format [Extension]")
+	effectsCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension). Use '-' to write to stdout, which needs --format")
 }
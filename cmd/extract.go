@@ -6,6 +6,8 @@ package cmd
 import (
 	"fmt"
 	"image/color"
+	"path/filepath"
+	"strings"
 
 	"github.com/Achno/gowall/config"
 	"github.com/Achno/gowall/internal/backends/colorthief"
@@ -16,25 +18,28 @@ import (
 
 var colorsNum int
 var previewFlag bool
+var renderTemplatesFlag bool
 
 // extractCmd represents the extract command
 var extractCmd = &cobra.Command{
 	Use:   "extract [FILE]",
 	Short: "Returns the color pallete of the image you specificed (like pywal)",
 	Long:  `Using the colorthief backend ( like pywal ) it returns the color pallete of the image (path) you specified`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		switch {
 		case len(args) > 0:
 			expandFile := utils.ExpandHomeDirectory(args)
 			clr, err := colorthief.GetPaletteFromFile(expandFile[0], colorsNum)
-			utils.HandleError(err)
+			if err != nil {
+				return err
+			}
 
 			for _, c := range clr {
 				rgba, ok := c.(color.RGBA)
 
 				if !ok {
-					utils.HandleError(fmt.Errorf("error in RGB casting"))
+					return fmt.Errorf("error in RGB casting")
 				}
 				fmt.Println(image.RGBtoHex(rgba))
 			}
@@ -44,11 +49,16 @@ var extractCmd = &cobra.Command{
 				utils.OpenURL(config.HexCodeVisualUrl)
 			}
 
+			if renderTemplatesFlag {
+				name := strings.TrimSuffix(filepath.Base(expandFile[0]), filepath.Ext(expandFile[0]))
+				return renderThemeTemplates(cmd.Context(), image.ThemeFromPalette(name, clr), expandFile[0])
+			}
+
 		default:
-			fmt.Println("Error: requires at least 1 arg(s), only received 0")
 			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 arg(s), only received 0")
 		}
-
+		return nil
 	},
 }
 
@@ -56,5 +66,6 @@ func init() {
 	rootCmd.AddCommand(extractCmd)
 	extractCmd.Flags().IntVarP(&colorsNum, "colors", "c", 6, "-c <number of colors to return>")
 	extractCmd.Flags().BoolVarP(&previewFlag, "preview", "p", false, "gowall extract -p (opens hex code preview site)")
+	addRenderTemplatesFlag(extractCmd)
 
 }
@@ -17,12 +17,15 @@ var gifCmd = &cobra.Command{
 	Use:   "gif",
 	Short: "Create a gif Animation out of Images",
 	Long:  `Create a gif Animation out of Images specifying the delay between frames, if the gif loops forever and other options`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		switch {
 		case cmd.Flags().Changed("batch"):
-			fmt.Println("Creating Gif...")
+			fmt.Fprintln(image.Messages(), "Creating Gif...")
 
-			options := []image.GifOption{}
+			options := []image.GifOption{
+				image.WithNameTemplate(nameTemplate),
+				image.WithCollision(collisionPolicy),
+			}
 			if cmd.Flags().Changed("delay") {
 				options = append(options, image.WithDelay(delay))
 			}
@@ -34,14 +37,20 @@ var gifCmd = &cobra.Command{
 			}
 
 			expandedFiles := utils.ExpandHomeDirectory(shared.BatchFiles)
-			err := image.CreateGif(expandedFiles, options...)
-			utils.HandleError(err)
+			result, err := image.CreateGif(expandedFiles, append(options, image.WithDryRun(dryRun))...)
+			if jsonOutput {
+				image.WriteResult(image.Stdout(), result, err)
+			}
+			if err != nil {
+				return err
+			}
 
 		default:
-			fmt.Println("Error: requires at least 1 option `-b` where you specify the filePaths, only received 0")
-			fmt.Println("Use: gowall gif -b <file,file>")
+			fmt.Fprintln(image.Messages(), "Use: gowall gif -b <file,file>")
 			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 option `-b` where you specify the filePaths, only received 0")
 		}
+		return nil
 	},
 }
 
@@ -5,7 +5,6 @@ package cmd
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/Achno/gowall/internal/image"
 	"github.com/Achno/gowall/utils"
@@ -17,47 +16,42 @@ var invertCmd = &cobra.Command{
 	Use:   "invert [image path]",
 	Short: "Inverts the color's of an image",
 	Long:  `Inverts the color's of an image , then you can convert the inverted image to your favourite color scheme`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
-		switch {
-
-		case len(shared.BatchFiles) > 0:
-			fmt.Println("Processing batch files...")
-			processor := &image.Inverter{}
-			expandedFiles := utils.ExpandHomeDirectory(shared.BatchFiles)
-			err := image.ProcessBatchImgs(expandedFiles, shared.Theme, processor)
+		files, batchOpts, isBatch, err := batchInputs(args)
+		if err != nil {
+			return err
+		}
 
-			utils.HandleError(err)
+		switch {
 
-		case len(args) > 0 && strings.HasSuffix(args[0], "#"):
-			fmt.Println("Processing directory...")
+		case isBatch:
+			fmt.Fprintln(image.Messages(), "Processing batch files...")
 			processor := &image.Inverter{}
-			path := utils.DiscardLastCharacter(args[0])
-			files, err := utils.ExpandHashtag(path)
-
-			utils.HandleError(err, "Error ExpandingHashTag")
+			err := image.ProcessBatchImgs(cmd.Context(), files, "", processor, batchOpts)
 
-			err = image.ProcessBatchImgs(files, shared.Theme, processor)
-			utils.HandleError(err)
+			if err != nil {
+				return err
+			}
 
 		case len(args) > 0:
-			fmt.Println("Processing single image...")
+			fmt.Fprintln(image.Messages(), "Processing single image...")
 			processor := &image.Inverter{}
 			expandFile := utils.ExpandHomeDirectory(args)
-			path, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme)
-
-			utils.HandleError(err)
-			err = image.OpenImage(path)
-			utils.HandleError(err)
+			return processImage(cmd.Context(), expandFile[0], processor, "")
 
 		default:
-			fmt.Println("Error: requires at least 1 arg(s), only received 0")
 			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 arg(s), only received 0")
 		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(invertCmd)
+	addBatchFlags(invertCmd)
+	invertCmd.Flags().StringVarP(&formatFlag, "format", "f", "", "Usage: --format [Extension]")
+	invertCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension). Use '-' to write to stdout, which needs --format")
 
 }
@@ -17,14 +17,16 @@ var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "Lists available themes",
 	Long:  `List all available themes. This includes the predefined and custom user provided themes in ~/.config/gowall/config.yml`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		th, _ := cmd.Flags().GetString("theme")
 
 		switch {
 		case th != "":
 			colors, err := image.GetThemeColors(th)
-			utils.HandleError(err)
+			if err != nil {
+				return err
+			}
 
 			for _, color := range colors {
 				fmt.Println(color)
@@ -41,6 +43,7 @@ var listCmd = &cobra.Command{
 				fmt.Println(theme)
 			}
 		}
+		return nil
 	},
 }
 
@@ -0,0 +1,75 @@
+/*
+Copyright © 2025 Achno <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/Achno/gowall/internal/image"
+	"github.com/Achno/gowall/utils"
+	"github.com/spf13/cobra"
+)
+
+var lutTheme string
+var lutFormat string
+var lutOutput string
+
+// lutCmd groups the commands that work with 3D LUTs
+var lutCmd = &cobra.Command{
+	Use:   "lut",
+	Short: "Export theme CLUTs as .cube or HaldCLUT LUTs",
+	Long:  `Export the CLUT gowall generates for a theme as a .cube 3D LUT or a HaldCLUT .png, to use it in darktable, ffmpeg, Resolve or any editor that reads LUTs. Apply any LUT with gowall convert --lut`,
+}
+
+// lutExportCmd writes the CLUT of a theme to a file
+var lutExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the CLUT of a theme as a .cube or HaldCLUT .png",
+	Long:  `Generates the CLUT of the theme with the --level and --mapper of convert and writes it as a .cube 3D LUT (default) or a HaldCLUT .png`,
+	Example: `gowall lut export --theme nord
+gowall lut export --theme nord --format hald -o ~/nord.png`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if lutTheme == "" {
+			return utils.InvalidParameter("--theme is required")
+		}
+
+		path := lutOutput
+		if path == "" {
+			dir, err := utils.CreateDirectory()
+			if err != nil {
+				return err
+			}
+			name := filepath.Base(lutTheme) + ".cube"
+			if lutFormat == image.LUTFormatHald {
+				name = filepath.Base(lutTheme) + "-hald.png"
+			}
+			path = filepath.Join(dir, name)
+		}
+		path = utils.ExpandHomeDirectory([]string{path})[0]
+
+		err := image.ExportThemeLUT(cmd.Context(), lutTheme, themeConverter(), lutFormat, path)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(image.Messages(), "LUT saved to %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lutCmd)
+	lutCmd.AddCommand(lutExportCmd)
+
+	lutExportCmd.Flags().StringVarP(&lutTheme, "theme", "t", "", "Usage : --theme [ThemeName]")
+	lutExportCmd.Flags().StringVarP(&lutFormat, "format", "f", image.LUTFormatCube, "Usage: --format [cube|hald]")
+	lutExportCmd.Flags().StringVarP(&lutOutput, "output", "o", "", "Usage: --output [path] defaults to <theme>.cube or <theme>-hald.png in the output folder")
+	addCLUTFlags(lutExportCmd)
+
+	lutExportCmd.RegisterFlagCompletionFunc("theme", themeCompletion)
+	lutExportCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{image.LUTFormatCube, image.LUTFormatHald}, cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2025 Achno <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Achno/gowall/internal/image"
+	"github.com/Achno/gowall/utils"
+	"github.com/spf13/cobra"
+)
+
+var pipeSteps []string
+var pipelineName string
+
+var pipeCmd = &cobra.Command{
+	Use:   "pipe [image path / batch flag]",
+	Short: "Chain multiple processors and apply them in one go",
+	Long: `Chain multiple processors (convert, draw, pixelate, br, invert...) and apply them on the image in order.
+The image is decoded and saved only once. Steps are written as name:key=value,key=value and can be
+given on the command line with -s or as a named pipeline in ~/.config/gowall/config.yml
+
+	gowall pipe img.png -s convert:theme=nord -s draw:color=#88C0D0,thickness=10 -s pixelate:scale=10
+	gowall pipe img.png -p retro`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		processor, err := buildPipeline()
+		if err != nil {
+			return err
+		}
+
+		files, batchOpts, isBatch, err := batchInputs(args)
+		if err != nil {
+			return err
+		}
+
+		switch {
+
+		case isBatch:
+			fmt.Fprintln(image.Messages(), "Processing batch files...")
+			err := image.ProcessBatchImgs(cmd.Context(), files, shared.Theme, processor, batchOpts)
+
+			if err != nil {
+				return err
+			}
+
+		case len(args) > 0:
+			fmt.Fprintln(image.Messages(), "Processing single image...")
+			expandFile := utils.ExpandHomeDirectory(args)
+
+			return processImage(cmd.Context(), expandFile[0], processor, shared.Theme)
+
+		default:
+			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 arg(s), only received 0")
+		}
+		return nil
+	},
+}
+
+// buildPipeline creates the pipeline either from a named pipeline in config.yml or from the -s steps
+func buildPipeline() (*image.PipelineProcessor, error) {
+	switch {
+	case pipelineName != "" && len(pipeSteps) > 0:
+		return nil, utils.InvalidParameter("use either --pipeline or --step, not both")
+	case pipelineName != "":
+		return image.LoadNamedPipeline(pipelineName)
+	case len(pipeSteps) > 0:
+		return image.ParsePipeline(pipeSteps)
+	default:
+		return nil, utils.InvalidParameter("no steps given, use -s <step> or -p <pipeline>. Available steps: %s", strings.Join(image.PipelineStepNames(), ", "))
+	}
+}
+
+func pipelineCompletion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return image.ListPipelines(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	rootCmd.AddCommand(pipeCmd)
+	pipeCmd.Flags().StringArrayVarP(&pipeSteps, "step", "s", nil, "Usage: --step name:key=value,key=value (repeatable, applied in order)")
+	pipeCmd.Flags().StringVarP(&pipelineName, "pipeline", "p", "", "Usage: --pipeline [name] (a pipeline defined in config.yml)")
+	pipeCmd.Flags().StringVarP(&shared.Theme, "theme", "t", "catppuccin", "Usage : --theme [ThemeName] (default theme for steps that don't set one)")
+	addBatchFlags(pipeCmd)
+	pipeCmd.Flags().StringVarP(&formatFlag, "format", "f", "", "Usage: --format [Extension]")
+	pipeCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension). Use '-' to write to stdout, which needs --format")
+
+	pipeCmd.RegisterFlagCompletionFunc("theme", themeCompletion)
+	pipeCmd.RegisterFlagCompletionFunc("pipeline", pipelineCompletion)
+}
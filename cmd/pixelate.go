@@ -19,26 +19,22 @@ var pixelateCmd = &cobra.Command{
 	Long: `It can convert an image to pixel art (blocky appearance). The scale flag [1-25] controls how much the image will get pixelated. 
 		   The lower the number the more pixel effect is prevalent. 
 		   In really large images with huge resolution you may need to set the scale really low [3-8] `,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		switch {
 
 		case len(args) > 0:
-			fmt.Println("Pixelating image...")
+			fmt.Fprintln(image.Messages(), "Pixelating image...")
 			processor := &image.PixelateProcessor{
 				Scale: ScaleFactor,
 			}
 			expandFile := utils.ExpandHomeDirectory(args)
 
-			path, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme)
-			utils.HandleError(err, "Error Processing Image")
-
-			err = image.OpenImage(path)
-			utils.HandleError(err, "Error opening image")
+			return processImage(cmd.Context(), expandFile[0], processor, "")
 
 		default:
-			fmt.Println("Error: requires at least 1 arg(s), only received 0")
 			_ = cmd.Usage()
+			return utils.InvalidParameter("requires at least 1 arg(s), only received 0")
 		}
 	},
 }
@@ -46,5 +42,7 @@ var pixelateCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(pixelateCmd)
 	pixelateCmd.Flags().Float64VarP(&ScaleFactor, "scale", "s", 15, "Usage: --scale [1-25] (The lower the number == more pixelation)")
+	pixelateCmd.Flags().StringVarP(&formatFlag, "format", "f", "", "Usage: --format [Extension]")
+	pixelateCmd.Flags().StringVarP(&outputName, "output", "o", "", "Usage: --output imageName (no extension). Use '-' to write to stdout, which needs --format")
 
 }
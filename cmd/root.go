@@ -4,8 +4,13 @@ Copyright © 2024 Achnologia <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 
 	"github.com/Achno/gowall/config"
 	"github.com/Achno/gowall/internal/api"
@@ -17,13 +22,48 @@ import (
 var shared config.Shared
 var versionFlag bool
 var wallOfTheDayFlag bool
+var progressMode string
+var progress image.ProgressReporter
+var jobs int
+var memoryBudget int64
+var nameTemplate string
+var collisionPolicy string
+var operation string // name of the running command, used by the {op} placeholder
+var jsonOutput bool
+var dryRun bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "gowall",
 	Short: "A tool to convert an img's color shceme ",
 	Long:  `Convert an Image's (ex. Wallpaper) color scheme to another ( ex. Catppuccin ) `,
-	Run: func(cmd *cobra.Command, args []string) {
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// keep stdout clean for the image data when streaming with "--output -" or for json progress
+		if flag := cmd.Flags().Lookup("output"); flag != nil && flag.Value.String() == image.StdioPath {
+			image.StreamToStdout()
+		}
+		if progressMode == "json" || jsonOutput {
+			image.StreamToStdout()
+		}
+
+		if err := image.ValidateNameTemplate(nameTemplate); err != nil {
+			return err
+		}
+		if err := image.ValidateCollisionPolicy(collisionPolicy); err != nil {
+			return err
+		}
+		operation = cmd.Name()
+
+		if jsonOutput {
+			progress = image.NewJSONResults(image.Stdout())
+			return nil
+		}
+
+		var err error
+		progress, err = image.NewProgressReporter(progressMode)
+		return err
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		switch {
 
@@ -31,47 +71,158 @@ var rootCmd = &cobra.Command{
 			fmt.Printf("gowall version: %s\n", config.Version)
 
 		case wallOfTheDayFlag:
-			fmt.Println("Fetching wallpaper of the day...")
+			fmt.Fprintln(image.Messages(), "Fetching wallpaper of the day...")
 			url, err := api.GetWallpaperOfTheDay()
-			utils.HandleError(err, "Could not fetch wallpaper of the day")
+			if err != nil {
+				return fmt.Errorf("could not fetch wallpaper of the day: %w", err)
+			}
 
 			path, err := image.SaveUrlAsImg(url)
-			utils.HandleError(err)
+			if err != nil {
+				return err
+			}
 
 			err = image.OpenImage(path)
-			utils.HandleError(err)
+			if err != nil {
+				return err
+			}
 
-			ok := utils.Confirm("Do you want to download this image?")
+			ok, err := utils.Confirm(cmd.Context(), image.Messages(), "Do you want to download this image?")
+			if err != nil {
+				return err
+			}
 
 			if !ok {
 				err = os.Remove(path)
-				utils.HandleError(err)
-				fmt.Println("::Image discarded::")
-				return
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(image.Messages(), "::Image discarded::")
+				return nil
 			}
 
-			fmt.Printf("Image saved as %s\n", path)
-			return
+			fmt.Fprintf(image.Messages(), "Image saved as %s\n", path)
+			return nil
 
 		default:
 			cmd.Help()
 
 		}
-
+		return nil
 	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// Ctrl-C cancels the context of every command, so running work stops and partial outputs are removed.
+// Commands return their errors, which are printed here and mapped to the exit codes in utils/error.go
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		_ = cmd.Usage()
+		return fmt.Errorf("%w: %w", utils.ErrInvalidParameter, err)
+	})
+
+	err := rootCmd.ExecuteContext(ctx)
 	if err != nil {
-		os.Exit(1)
+		stop()
+		utils.PrintError(err)
+		os.Exit(utils.ExitCode(err))
+	}
+}
+
+// processOptions returns the ProcessOptions for a single image from the --output, --format,
+// --name-template and --collision flags
+func processOptions() image.ProcessOptions {
+	return image.ProcessOptions{
+		SaveToFile:   true,
+		OutputExt:    formatFlag,
+		OutputName:   outputName,
+		NameTemplate: nameTemplate,
+		Collision:    collisionPolicy,
+		Op:           operation,
+		DryRun:       dryRun,
+	}
+}
+
+// processImage processes a single image with the global flags and reports the result
+func processImage(ctx context.Context, imgPath string, processor image.ImageProcessor, theme string) error {
+	result, _, err := image.ProcessImgResult(ctx, imgPath, processor, theme, processOptions())
+	return reportResult(result, err)
+}
+
+// reportResult prints the result as a JSON record with --json. Otherwise it previews the
+// output image, unless this is a dry run. The processing error is returned as is
+func reportResult(result image.ProcessResult, err error) error {
+	if jsonOutput {
+		image.WriteResult(image.Stdout(), result, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if dryRun || jsonOutput {
+		return nil
+	}
+	return image.OpenImage(result.Output)
+}
+
+// renderThemeTemplates renders the user's templates with the theme for --render-templates and runs the
+// TemplateHooks of config.yml. The rendered files are listed on stderr, so stdout stays clean for the output
+func renderThemeTemplates(ctx context.Context, theme image.Theme, wallpaper string) error {
+	if dryRun {
+		return nil
+	}
+	rendered, err := image.RenderTemplates(theme, wallpaper)
+	for _, path := range rendered {
+		fmt.Fprintf(os.Stderr, "Rendered template %s\n", path)
+	}
+	if len(rendered) == 0 {
+		return err
+	}
+	return errors.Join(err, image.RunTemplateHooks(ctx, theme, wallpaper))
+}
+
+// addRenderTemplatesFlag registers --render-templates on the commands that produce a palette
+func addRenderTemplatesFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&renderTemplatesFlag, "render-templates", false, "Usage: --render-templates render the templates of ~/.config/gowall/templates with the palette into ~/.cache/gowall and run the TemplateHooks of config.yml")
+}
+
+// batchOptions returns the BatchOptions from the --jobs, --memory, --progress, --name-template and --collision flags
+func batchOptions() image.BatchOptions {
+	return image.BatchOptions{
+		Jobs:         jobs,
+		MemoryBudget: memoryBudget * 1024 * 1024,
+		Progress:     progress,
+		NameTemplate: nameTemplate,
+		Collision:    collisionPolicy,
+		Op:           operation,
+		DryRun:       dryRun,
+	}
+}
+
+// defaultCollision returns the collision policy from config.yml, overwrite if it isn't set
+func defaultCollision() string {
+	if config.GowallConfig.CollisionPolicy != "" {
+		return config.GowallConfig.CollisionPolicy
 	}
+	return image.CollisionOverwrite
 }
 
 func init() {
+	config.Load()
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	rootCmd.Flags().BoolVarP(&versionFlag, "version", "v", false, "show gowall version")
 	rootCmd.Flags().BoolVarP(&wallOfTheDayFlag, "wall", "w", false, "fetches the wallpaper of the day!")
+	rootCmd.PersistentFlags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Number of images processed at the same time in batch mode")
+	rootCmd.PersistentFlags().Int64Var(&memoryBudget, "memory", 0, "Approximate memory budget in MiB for images decoded at the same time in batch mode (0 = no limit)")
+	rootCmd.PersistentFlags().StringVar(&nameTemplate, "name-template", config.GowallConfig.NameTemplate, "Name of the output files, placeholders: {name} {theme} {op} {date} {w} {h} {hash}. Ex: {name}-{theme}-{w}x{h}")
+	rootCmd.PersistentFlags().StringVar(&collisionPolicy, "collision", defaultCollision(), "What to do when the output file already exists: overwrite, skip, suffix or error")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print one JSON record per processed file on stdout instead of text")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Resolve inputs, themes and output paths and validate parameters without decoding or writing images")
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", "bar", "Progress of batch processing: bar, json (one JSON object per line on stdout) or none")
 }
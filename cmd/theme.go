@@ -0,0 +1,130 @@
+/*
+Copyright © 2025 Achno <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Achno/gowall/internal/image"
+	"github.com/Achno/gowall/utils"
+	"github.com/spf13/cobra"
+)
+
+var themeName string
+var themeColors int
+var themeFormat string
+var themeForce bool
+var exportFormat string
+var exportOutput string
+
+// themeCmd groups the commands that create and manage themes
+var themeCmd = &cobra.Command{
+	Use:   "theme",
+	Short: "Create and manage themes",
+}
+
+var themeFromImageCmd = &cobra.Command{
+	Use:   "from-image [image path]",
+	Short: "Generate a theme from the colors of an image",
+	Long:  `Extracts the palette of the image and saves it as a theme, ordered as background, foreground, then the accents by hue and the neutral colors by lightness. JSON and YAML themes are saved in ~/.config/gowall/themes and Emacs themes in ~/.emacs.d/themes, so convert -t <name> can use it right away`,
+	Example: `gowall theme from-image wall.jpg --name mytheme
+gowall theme from-image wall.jpg --name mytheme --colors 8 --format yaml`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch strings.ToLower(themeFormat) {
+		case "json", "yaml", "yml", "el", "emacs":
+		default:
+			return utils.InvalidParameter("unsupported theme format %q, use json, yaml or el", themeFormat)
+		}
+		if err := image.ValidateThemeName(themeName); err != nil {
+			return err
+		}
+		if image.ThemeExists(themeName) && !themeForce {
+			return utils.InvalidParameter("theme %s already exists, use --force to overwrite it", themeName)
+		}
+
+		expandFile := utils.ExpandHomeDirectory(args)
+		theme, err := image.ThemeFromImage(expandFile[0], themeName, themeColors)
+		if err != nil {
+			return err
+		}
+
+		path, err := image.SaveThemeToFile(theme, themeFormat)
+		if err != nil {
+			return fmt.Errorf("while saving theme %s: %w", themeName, err)
+		}
+
+		for _, c := range theme.Colors {
+			fmt.Println(image.RGBtoHex(c.(color.RGBA)))
+		}
+		fmt.Fprintf(image.Messages(), "Saved theme %s with %d colors to %s\n", theme.Name, len(theme.Colors), path)
+		return nil
+	},
+}
+
+var themeExportCmd = &cobra.Command{
+	Use:   "export [theme]",
+	Short: "Export a theme to the config format of a terminal, editor or desktop program",
+	Long:  `Writes the theme in the format of --to, to stdout or to the --output file. A directory as --output gets a file named after the theme. Themes with roles (background, foreground, color0-color15...) are exported as they are, the roles a theme doesn't have are filled from its colors sorted by lightness and hue`,
+	Example: `gowall theme export nord --to kitty > ~/.config/kitty/current-theme.conf
+gowall theme export mytheme --to alacritty -o ~/.config/alacritty/themes/`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		exporter, err := image.LookupThemeExporter(exportFormat)
+		if err != nil {
+			return err
+		}
+		theme, err := image.SelectTheme(utils.ExpandHomeDirectory(args)[0])
+		if err != nil {
+			return err
+		}
+
+		if exportOutput == "" {
+			return image.ExportTheme(image.Stdout(), theme, exportFormat)
+		}
+
+		path := utils.ExpandHomeDirectory([]string{exportOutput})[0]
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			name := strings.ReplaceAll(strings.ToLower(theme.Name), " ", "-")
+			path = filepath.Join(path, name+exporter.Extension)
+		}
+		err = utils.WriteFileAtomic(path, func(w io.Writer) error {
+			return image.ExportTheme(w, theme, exportFormat)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(image.Messages(), "Exported theme %s to %s\n", theme.Name, path)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(themeCmd)
+	themeCmd.AddCommand(themeFromImageCmd, themeExportCmd)
+
+	themeFromImageCmd.Flags().StringVarP(&themeName, "name", "n", "", "Usage: --name mytheme name of the theme, used by convert -t")
+	themeFromImageCmd.Flags().IntVarP(&themeColors, "colors", "c", 16, "Usage: --colors [number] colors to extract")
+	themeFromImageCmd.Flags().StringVarP(&themeFormat, "format", "f", "json", "Usage: --format [json|yaml|el] file format of the theme")
+	themeFromImageCmd.Flags().BoolVar(&themeForce, "force", false, "Usage: --force overwrite an existing theme with the same name")
+	themeFromImageCmd.MarkFlagRequired("name")
+
+	themeFromImageCmd.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"json", "yaml", "el"}, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	themeExportCmd.Flags().StringVar(&exportFormat, "to", "", "Usage: --to [kitty|alacritty|wezterm|foot|xresources|base16|gimp|css|hyprland|rofi|json|yaml|emacs] format to export to")
+	themeExportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Usage: --output file or directory to write to, defaults to stdout")
+	themeExportCmd.MarkFlagRequired("to")
+
+	themeExportCmd.ValidArgsFunction = themeCompletion
+	themeExportCmd.RegisterFlagCompletionFunc("to", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return image.ExportFormats(), cobra.ShellCompDirectiveNoFileComp
+	})
+}
@@ -26,7 +26,7 @@ func UpscaleCmd() *cobra.Command {
 
 			switch {
 			case len(args) > 0:
-				fmt.Println("Upscaling image...")
+				fmt.Fprintln(image.Messages(), "Upscaling image...")
 
 				processor := &image.UpscaleProcessor{
 					Scale:     scale,
@@ -38,19 +38,19 @@ func UpscaleCmd() *cobra.Command {
 
 				opts := image.ProcessOptions{
 					SaveToFile: false,
+					Op:         operation,
+					DryRun:     dryRun,
 				}
 
-				_, _, err := image.ProcessImg(expandFile[0], processor, shared.Theme, opts)
-				utils.HandleError(err, "Error Processing Image")
-
-				err = image.OpenImage(processor.OutputFile)
-				utils.HandleError(err, "Error opening image")
+				// the upscaler writes the output itself
+				result, _, err := image.ProcessImgResult(cmd.Context(), expandFile[0], processor, "", opts)
+				result.Output = processor.OutputFile
+				return reportResult(result, err)
 
 			default:
-				fmt.Println("Error: requires at least 1 arg(s), only received 0")
 				_ = cmd.Usage()
+				return utils.InvalidParameter("requires at least 1 arg(s), only received 0")
 			}
-			return nil
 		},
 	}
 
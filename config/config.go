@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"gopkg.in/yaml.v3"
 )
@@ -19,23 +20,48 @@ type themeWrapper struct {
 	Colors []string `yaml:"colors"`
 }
 
+type pipelineWrapper struct {
+	Name  string   `yaml:"name"`
+	Steps []string `yaml:"steps"`
+}
+
 type Options struct {
-	EnableImagePreviewing  bool           `yaml:"EnableImagePreviewing"`
-	InlineImagePreview     bool           `yaml:"InlineImagePreview"`
-	ColorCorrectionBackend string         `yaml:"ColorCorrectionBackend"`
-	OutputFolder           string         `yaml:"OutputFolder"`
-	Themes                 []themeWrapper `yaml:"themes"`
+	EnableImagePreviewing  bool              `yaml:"EnableImagePreviewing"`
+	InlineImagePreview     bool              `yaml:"InlineImagePreview"`
+	ColorCorrectionBackend string            `yaml:"ColorCorrectionBackend"`
+	ColorDistanceMetric    string            `yaml:"ColorDistanceMetric"`
+	CLUTLevel              int               `yaml:"CLUTLevel"`
+	CLUTInterpolation      string            `yaml:"CLUTInterpolation"`
+	CLUTMapper             string            `yaml:"CLUTMapper"`
+	MapperSigma            float64           `yaml:"MapperSigma"`
+	MapperPower            float64           `yaml:"MapperPower"`
+	MapperNeighbours       int               `yaml:"MapperNeighbours"`
+	OutputFolder           string            `yaml:"OutputFolder"`
+	NameTemplate           string            `yaml:"NameTemplate"`
+	CollisionPolicy        string            `yaml:"CollisionPolicy"`
+	TemplateHooks          []string          `yaml:"TemplateHooks"`
+	Themes                 []themeWrapper    `yaml:"themes"`
+	Pipelines              []pipelineWrapper `yaml:"pipelines"`
 }
 
-// global config object, used when config is needed
+// global config object, used when config is needed. Call Load before reading it
 var GowallConfig = defaultConfig()
 
-func init() {
+var loadOnce sync.Once
+
+// Load reads $HOME/.config/gowall/config.yml into GowallConfig. Only the first call does any work,
+// so it is safe to call from every place that needs the user's config
+func Load() {
+	loadOnce.Do(loadConfigFile)
+}
+
+func loadConfigFile() {
 	// look for $HOME/.config/gowall/config.yml
 	configDir, err := os.UserHomeDir()
 
 	if err != nil {
-		log.Fatalf("Error could not get Home directory")
+		log.Printf("Error could not get Home directory")
+		return
 	}
 	configPath := filepath.Join(configDir, ".config", "gowall", configFile)
 
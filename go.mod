@@ -7,7 +7,9 @@ require (
 	github.com/PuerkitoBio/goquery v1.9.2
 	github.com/spf13/cobra v1.8.1
 	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
+	golang.org/x/sys v0.19.0
 	golang.org/x/term v0.19.0
+	gopkg.in/yaml.v2 v2.4.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -16,6 +18,4 @@ require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/net v0.24.0 // indirect
-	golang.org/x/sys v0.19.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
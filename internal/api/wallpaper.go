@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/Achno/gowall/config"
+	"github.com/Achno/gowall/utils"
 	"github.com/PuerkitoBio/goquery"
 )
 
@@ -15,11 +16,11 @@ func GetWallpaperOfTheDay() (string, error) {
 	response, err := http.Get(url)
 
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %w", utils.ErrNetwork, err)
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("request failed with status code: %d %s", response.StatusCode, http.StatusText(response.StatusCode))
+		return "", fmt.Errorf("%w: request failed with status code: %d %s", utils.ErrNetwork, response.StatusCode, http.StatusText(response.StatusCode))
 	}
 
 	defer response.Body.Close()
@@ -43,7 +44,7 @@ func GetWallpaperOfTheDay() (string, error) {
 	})
 
 	// if no posts were found
-	if len(imageUrls) == 0 {
+	if len(imageUrls) < 2 {
 		return "", fmt.Errorf("there wasn't a top wallpaper today :( check later")
 	}
 
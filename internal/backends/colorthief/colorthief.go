@@ -5,9 +5,11 @@ import (
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"os"
 
 	"github.com/Achno/gowall/internal/backends/colorthief/mediancut"
+	"github.com/Achno/gowall/utils"
 )
 
 var DefaultMaxCubes = 6
@@ -67,11 +69,7 @@ func PrintColor(colors []color.Color, filename string) error {
 		}
 	}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	return png.Encode(file, paletted)
+	return utils.WriteFileAtomic(filename, func(w io.Writer) error {
+		return png.Encode(w, paletted)
+	})
 }
@@ -1,12 +1,16 @@
 package haldclut
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
+	"io"
 	"os"
 	"sync"
+
+	"github.com/Achno/gowall/utils"
 )
 
 // Interface for all the possible interpolation and mapping algorithms
@@ -14,6 +18,17 @@ type Mapperfunc interface {
 	Map(color.RGBA, []color.RGBA) color.RGBA
 }
 
+// WeightedMapper is implemented by mappers that can give some palette colors more pull than others.
+// weights has one entry per palette color, nil weighs them all the same
+type WeightedMapper interface {
+	MapWeighted(original color.RGBA, palette []color.RGBA, weights []float64) color.RGBA
+}
+
+// InterpolateOptions configures InterpolateCLUT
+type InterpolateOptions struct {
+	Weights []float64 // weight of each palette color, used when the mapper is a WeightedMapper
+}
+
 func GenerateIdentityCLUT(level int) (*image.RGBA, error) {
 	cubeSize := level * level
 	imageSize := cubeSize * level
@@ -40,13 +55,11 @@ func GenerateIdentityCLUT(level int) (*image.RGBA, error) {
 	return clut, nil
 }
 
+// SaveHaldCLUT writes the CLUT as a PNG, the file only appears once it is complete
 func SaveHaldCLUT(clut *image.RGBA, filePath string) error {
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	return png.Encode(file, clut)
+	return utils.WriteFileAtomic(filePath, func(w io.Writer) error {
+		return png.Encode(w, clut)
+	})
 }
 
 func LoadHaldCLUT(filePath string) (*image.RGBA, error) {
@@ -67,23 +80,145 @@ func LoadHaldCLUT(filePath string) (*image.RGBA, error) {
 	return clut, nil
 }
 
-func ApplyCLUT(img *image.RGBA, clut *image.RGBA, level int) *image.RGBA {
-	// cubeSize := level * level
-	newImg := image.NewRGBA(img.Bounds())
+// Interpolation selects how ApplyCLUT computes colors that fall between the lattice points of the CLUT
+type Interpolation string
 
-	for y := 0; y < img.Bounds().Dy(); y++ {
-		for x := 0; x < img.Bounds().Dx(); x++ {
-			original := img.RGBAAt(x, y)
+const (
+	// InterpolationNearest truncates to the lattice point below the color, gradients show steps
+	InterpolationNearest Interpolation = "nearest"
+	// InterpolationTrilinear blends the 8 lattice points around the color
+	InterpolationTrilinear Interpolation = "trilinear"
+	// InterpolationTetrahedral blends the 4 lattice points of the tetrahedron around the color,
+	// it is smoother on the gray axis than trilinear and cheaper
+	InterpolationTetrahedral Interpolation = "tetrahedral"
+)
 
-			clutX, clutY := correctPixel(original, level)
+// Limits of the CLUT level, a level n CLUT is an n^3 x n^3 image with n^2 lattice points per channel
+const (
+	MinLevel = 4
+	MaxLevel = 16
+)
 
-			mappedColor := clut.RGBAAt(clutX, clutY)
-			newImg.SetRGBA(x, y, mappedColor)
+type ApplyOptions struct {
+	Interpolation Interpolation // defaults to InterpolationNearest
+}
+
+// ApplyCLUT maps every pixel of the image through the CLUT, working on chunks of rows in parallel.
+// Translucent pixels are unpremultiplied before the lookup and keep their alpha
+func ApplyCLUT(img *image.RGBA, clut *image.RGBA, level int, opts ...ApplyOptions) *image.RGBA {
+	mapColor := colorMapper(clut, level, opts...)
+	bounds := img.Bounds()
+	newImg := image.NewRGBA(bounds)
+
+	parallelChunks(bounds, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				original := img.RGBAAt(x, y)
+				alpha := original.A
+				if alpha == 0 {
+					continue
+				}
+				if alpha != 0xff {
+					original = unpremultiply(original)
+				}
+
+				mappedColor := mapColor(original)
+				if alpha != 0xff {
+					mappedColor = premultiply(mappedColor, alpha)
+				}
+				mappedColor.A = alpha
+				newImg.SetRGBA(x, y, mappedColor)
+			}
 		}
-	}
+	})
+
 	return newImg
 }
 
+// ApplyCLUTNRGBA is ApplyCLUT for images with straight alpha, translucent pixels are looked up
+// with their exact color instead of one rounded by premultiplication
+func ApplyCLUTNRGBA(img *image.NRGBA, clut *image.RGBA, level int, opts ...ApplyOptions) *image.NRGBA {
+	mapColor := colorMapper(clut, level, opts...)
+	bounds := img.Bounds()
+	newImg := image.NewNRGBA(bounds)
+
+	parallelChunks(bounds, func(startY, endY int) {
+		for y := startY; y < endY; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				original := img.NRGBAAt(x, y)
+				if original.A == 0 {
+					continue
+				}
+
+				mappedColor := mapColor(color.RGBA{R: original.R, G: original.G, B: original.B, A: 0xff})
+				newImg.SetNRGBA(x, y, color.NRGBA{R: mappedColor.R, G: mappedColor.G, B: mappedColor.B, A: original.A})
+			}
+		}
+	})
+
+	return newImg
+}
+
+// colorMapper returns the lookup of an opaque color in the CLUT with the interpolation of the options
+func colorMapper(clut *image.RGBA, level int, opts ...ApplyOptions) func(color.RGBA) color.RGBA {
+	var options ApplyOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	lattice := newLatticeIndex(level)
+
+	switch options.Interpolation {
+	case InterpolationTrilinear:
+		return func(c color.RGBA) color.RGBA { return lattice.trilinear(clut, c) }
+	case InterpolationTetrahedral:
+		return func(c color.RGBA) color.RGBA { return lattice.tetrahedral(clut, c) }
+	default:
+		return func(c color.RGBA) color.RGBA {
+			clutX, clutY := correctPixel(c, level)
+			return clut.RGBAAt(clutX, clutY)
+		}
+	}
+}
+
+// parallelChunks calls fn for chunks of 128 rows of bounds, each in its own goroutine
+func parallelChunks(bounds image.Rectangle, fn func(startY, endY int)) {
+	wg := sync.WaitGroup{}
+
+	chunkSize := 128 // goroutines on chunks of 128 rows
+	for startY := bounds.Min.Y; startY < bounds.Max.Y; startY += chunkSize {
+		endY := min(startY+chunkSize, bounds.Max.Y)
+
+		wg.Add(1)
+		go func(startY, endY int) {
+			defer wg.Done()
+			fn(startY, endY)
+		}(startY, endY)
+	}
+	wg.Wait()
+}
+
+// unpremultiply returns the straight color of a translucent premultiplied color
+func unpremultiply(c color.RGBA) color.RGBA {
+	a := uint32(c.A)
+	return color.RGBA{
+		R: uint8(min(255, (uint32(c.R)*255+a/2)/a)),
+		G: uint8(min(255, (uint32(c.G)*255+a/2)/a)),
+		B: uint8(min(255, (uint32(c.B)*255+a/2)/a)),
+		A: c.A,
+	}
+}
+
+// premultiply scales a straight color by the alpha
+func premultiply(c color.RGBA, alpha uint8) color.RGBA {
+	a := uint32(alpha)
+	return color.RGBA{
+		R: uint8((uint32(c.R)*a + 127) / 255),
+		G: uint8((uint32(c.G)*a + 127) / 255),
+		B: uint8((uint32(c.B)*a + 127) / 255),
+		A: alpha,
+	}
+}
+
 func correctPixel(original color.RGBA, level int) (int, int) {
 	cubeSize := level * level
 
@@ -97,7 +232,130 @@ func correctPixel(original color.RGBA, level int) (int, int) {
 	return x, y
 }
 
-func InterpolateCLUT(identityClut *image.RGBA, palette []color.RGBA, level int, mapper Mapperfunc) *image.RGBA {
+// latticeIndex holds, for every 8 bit channel value, the lattice point below it,
+// the one above it and how far the value is between the two
+type latticeIndex struct {
+	level  int
+	lower  [256]int
+	upper  [256]int
+	weight [256]float32
+}
+
+func newLatticeIndex(level int) *latticeIndex {
+	cubeSize := level * level
+	l := &latticeIndex{level: level}
+	for v := 0; v < 256; v++ {
+		pos := float32(v) * float32(cubeSize-1) / 255
+		lower := int(pos)
+		l.lower[v] = lower
+		l.upper[v] = min(lower+1, cubeSize-1)
+		l.weight[v] = pos - float32(lower)
+	}
+	return l
+}
+
+// point returns the color of the CLUT at the lattice point r,g,b
+func (l *latticeIndex) point(clut *image.RGBA, r, g, b int) [4]float32 {
+	cubeSize := l.level * l.level
+	x := r + (g%l.level)*cubeSize
+	y := b*l.level + g/l.level
+
+	i := y*clut.Stride + x*4
+	p := clut.Pix[i : i+4 : i+4]
+	return [4]float32{float32(p[0]), float32(p[1]), float32(p[2]), float32(p[3])}
+}
+
+func (l *latticeIndex) trilinear(clut *image.RGBA, c color.RGBA) color.RGBA {
+	r0, r1, fr := l.lower[c.R], l.upper[c.R], l.weight[c.R]
+	g0, g1, fg := l.lower[c.G], l.upper[c.G], l.weight[c.G]
+	b0, b1, fb := l.lower[c.B], l.upper[c.B], l.weight[c.B]
+
+	var out [4]float32
+	for _, corner := range [8]struct {
+		r, g, b int
+		w       float32
+	}{
+		{r0, g0, b0, (1 - fr) * (1 - fg) * (1 - fb)},
+		{r1, g0, b0, fr * (1 - fg) * (1 - fb)},
+		{r0, g1, b0, (1 - fr) * fg * (1 - fb)},
+		{r1, g1, b0, fr * fg * (1 - fb)},
+		{r0, g0, b1, (1 - fr) * (1 - fg) * fb},
+		{r1, g0, b1, fr * (1 - fg) * fb},
+		{r0, g1, b1, (1 - fr) * fg * fb},
+		{r1, g1, b1, fr * fg * fb},
+	} {
+		if corner.w == 0 {
+			continue
+		}
+		p := l.point(clut, corner.r, corner.g, corner.b)
+		for i := range out {
+			out[i] += p[i] * corner.w
+		}
+	}
+	return toRGBA(out)
+}
+
+// tetrahedral splits the cube between the 8 lattice points in 6 tetrahedra along its gray diagonal
+// and blends the 4 corners of the one holding the color
+func (l *latticeIndex) tetrahedral(clut *image.RGBA, c color.RGBA) color.RGBA {
+	r0, r1, fr := l.lower[c.R], l.upper[c.R], l.weight[c.R]
+	g0, g1, fg := l.lower[c.G], l.upper[c.G], l.weight[c.G]
+	b0, b1, fb := l.lower[c.B], l.upper[c.B], l.weight[c.B]
+
+	c000 := l.point(clut, r0, g0, b0)
+	c111 := l.point(clut, r1, g1, b1)
+
+	// the two corners in between and the weights of the 4 corners
+	var ca, cb [4]float32
+	var w0, wa, wb, w1 float32
+	switch {
+	case fr >= fg && fg >= fb:
+		ca, cb = l.point(clut, r1, g0, b0), l.point(clut, r1, g1, b0)
+		w0, wa, wb, w1 = 1-fr, fr-fg, fg-fb, fb
+	case fr >= fb && fb >= fg:
+		ca, cb = l.point(clut, r1, g0, b0), l.point(clut, r1, g0, b1)
+		w0, wa, wb, w1 = 1-fr, fr-fb, fb-fg, fg
+	case fb >= fr && fr >= fg:
+		ca, cb = l.point(clut, r0, g0, b1), l.point(clut, r1, g0, b1)
+		w0, wa, wb, w1 = 1-fb, fb-fr, fr-fg, fg
+	case fg >= fr && fr >= fb:
+		ca, cb = l.point(clut, r0, g1, b0), l.point(clut, r1, g1, b0)
+		w0, wa, wb, w1 = 1-fg, fg-fr, fr-fb, fb
+	case fg >= fb && fb >= fr:
+		ca, cb = l.point(clut, r0, g1, b0), l.point(clut, r0, g1, b1)
+		w0, wa, wb, w1 = 1-fg, fg-fb, fb-fr, fr
+	default: // fb >= fg >= fr
+		ca, cb = l.point(clut, r0, g0, b1), l.point(clut, r0, g1, b1)
+		w0, wa, wb, w1 = 1-fb, fb-fg, fg-fr, fr
+	}
+
+	var out [4]float32
+	for i := range out {
+		out[i] = c000[i]*w0 + ca[i]*wa + cb[i]*wb + c111[i]*w1
+	}
+	return toRGBA(out)
+}
+
+func toRGBA(c [4]float32) color.RGBA {
+	round := func(v float32) uint8 {
+		return uint8(max(0, min(255, v+0.5)))
+	}
+	return color.RGBA{R: round(c[0]), G: round(c[1]), B: round(c[2]), A: round(c[3])}
+}
+
+// InterpolateCLUT maps every color of the identity CLUT to the palette with the mapper.
+// It returns ctx.Err() if the context gets cancelled before all chunks are done
+func InterpolateCLUT(ctx context.Context, identityClut *image.RGBA, palette []color.RGBA, level int, mapper Mapperfunc, opts ...InterpolateOptions) (*image.RGBA, error) {
+	mapColor := mapper.Map
+	if len(opts) > 0 && opts[0].Weights != nil {
+		if weighted, ok := mapper.(WeightedMapper); ok {
+			weights := opts[0].Weights
+			mapColor = func(original color.RGBA, palette []color.RGBA) color.RGBA {
+				return weighted.MapWeighted(original, palette, weights)
+			}
+		}
+	}
+
 	bounds := identityClut.Bounds()
 	newClut := image.NewRGBA(bounds)
 
@@ -118,9 +376,12 @@ func InterpolateCLUT(identityClut *image.RGBA, palette []color.RGBA, level int,
 			go func(startX, endX, startY, endY int) {
 				defer wg.Done()
 				for y := startY; y < endY; y++ {
+					if ctx.Err() != nil {
+						return
+					}
 					for x := startX; x < endX; x++ {
 						originalColor := identityClut.RGBAAt(x, y)
-						interpolatedColor := mapper.Map(originalColor, palette)
+						interpolatedColor := mapColor(originalColor, palette)
 						newClut.SetRGBA(x, y, interpolatedColor)
 					}
 				}
@@ -128,5 +389,9 @@ func InterpolateCLUT(identityClut *image.RGBA, palette []color.RGBA, level int,
 		}
 	}
 	wg.Wait()
-	return newClut
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return newClut, nil
 }
@@ -0,0 +1,265 @@
+package haldclut
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Achno/gowall/utils"
+)
+
+// CubeLUT is a 3D LUT in the Adobe/Resolve .cube format, used by darktable, ffmpeg, Resolve and most photo editors
+type CubeLUT struct {
+	Title     string
+	Size      int          // lattice points per channel
+	DomainMin [3]float64   // input range, usually 0 0 0
+	DomainMax [3]float64   // input range, usually 1 1 1
+	Table     [][3]float64 // Size^3 output colors in [0,1], red changes fastest, then green, then blue
+}
+
+// ParseCube reads a .cube 3D LUT. 1D LUTs are not supported
+func ParseCube(r io.Reader) (*CubeLUT, error) {
+	lut := &CubeLUT{DomainMax: [3]float64{1, 1, 1}}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch strings.ToUpper(fields[0]) {
+		case "TITLE":
+			lut.Title = strings.Trim(strings.TrimSpace(line[len(fields[0]):]), `"`)
+
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: LUT_3D_SIZE needs one value", lineNumber)
+			}
+			size, err := strconv.Atoi(fields[1])
+			if err != nil || size < 2 || size > 256 {
+				return nil, fmt.Errorf("line %d: invalid LUT_3D_SIZE %q", lineNumber, fields[1])
+			}
+			lut.Size = size
+			lut.Table = make([][3]float64, 0, size*size*size)
+
+		case "LUT_1D_SIZE":
+			return nil, fmt.Errorf("line %d: 1D LUTs are not supported, only 3D", lineNumber)
+
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			values, err := parseTriplet(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			if strings.EqualFold(fields[0], "DOMAIN_MIN") {
+				lut.DomainMin = values
+			} else {
+				lut.DomainMax = values
+			}
+
+		case "LUT_3D_INPUT_RANGE":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: LUT_3D_INPUT_RANGE needs two values", lineNumber)
+			}
+			lo, errLo := strconv.ParseFloat(fields[1], 64)
+			hi, errHi := strconv.ParseFloat(fields[2], 64)
+			if errLo != nil || errHi != nil {
+				return nil, fmt.Errorf("line %d: invalid LUT_3D_INPUT_RANGE", lineNumber)
+			}
+			lut.DomainMin, lut.DomainMax = [3]float64{lo, lo, lo}, [3]float64{hi, hi, hi}
+
+		default:
+			values, err := parseTriplet(fields)
+			if err != nil {
+				// unknown keywords are allowed by the format
+				if _, numErr := strconv.ParseFloat(fields[0], 64); numErr != nil {
+					continue
+				}
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			if lut.Size == 0 {
+				return nil, fmt.Errorf("line %d: LUT data before LUT_3D_SIZE", lineNumber)
+			}
+			lut.Table = append(lut.Table, values)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lut.Size == 0 {
+		return nil, fmt.Errorf("missing LUT_3D_SIZE")
+	}
+	if want := lut.Size * lut.Size * lut.Size; len(lut.Table) != want {
+		return nil, fmt.Errorf("expected %d entries for LUT_3D_SIZE %d, found %d", want, lut.Size, len(lut.Table))
+	}
+	for i := 0; i < 3; i++ {
+		if lut.DomainMax[i] <= lut.DomainMin[i] {
+			return nil, fmt.Errorf("DOMAIN_MAX must be greater than DOMAIN_MIN")
+		}
+	}
+	return lut, nil
+}
+
+func parseTriplet(fields []string) ([3]float64, error) {
+	var values [3]float64
+	if len(fields) != 3 {
+		return values, fmt.Errorf("expected 3 values, found %d", len(fields))
+	}
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return values, fmt.Errorf("invalid number %q", field)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// LoadCube reads a .cube file
+func LoadCube(filePath string) (*CubeLUT, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lut, err := ParseCube(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", utils.ErrDecode, filePath, err)
+	}
+	return lut, nil
+}
+
+// WriteCube writes the LUT in the .cube format
+func WriteCube(w io.Writer, lut *CubeLUT) error {
+	bw := bufio.NewWriter(w)
+
+	if lut.Title != "" {
+		fmt.Fprintf(bw, "TITLE \"%s\"\n", strings.ReplaceAll(lut.Title, `"`, ""))
+	}
+	fmt.Fprintf(bw, "LUT_3D_SIZE %d\n", lut.Size)
+	fmt.Fprintf(bw, "DOMAIN_MIN %g %g %g\n", lut.DomainMin[0], lut.DomainMin[1], lut.DomainMin[2])
+	fmt.Fprintf(bw, "DOMAIN_MAX %g %g %g\n\n", lut.DomainMax[0], lut.DomainMax[1], lut.DomainMax[2])
+
+	for _, c := range lut.Table {
+		fmt.Fprintf(bw, "%.6f %.6f %.6f\n", c[0], c[1], c[2])
+	}
+	return bw.Flush()
+}
+
+// SaveCube writes the LUT to a .cube file, the file only appears once it is complete
+func SaveCube(lut *CubeLUT, filePath string) error {
+	return utils.WriteFileAtomic(filePath, func(w io.Writer) error {
+		return WriteCube(w, lut)
+	})
+}
+
+// HaldToCube converts a HaldCLUT to a .cube LUT with the same lattice points, level^2 per channel
+func HaldToCube(clut *image.RGBA, level int, title string) *CubeLUT {
+	cubeSize := level * level
+	lattice := newLatticeIndex(level)
+
+	lut := &CubeLUT{
+		Title:     title,
+		Size:      cubeSize,
+		DomainMax: [3]float64{1, 1, 1},
+		Table:     make([][3]float64, 0, cubeSize*cubeSize*cubeSize),
+	}
+	for b := 0; b < cubeSize; b++ {
+		for g := 0; g < cubeSize; g++ {
+			for r := 0; r < cubeSize; r++ {
+				p := lattice.point(clut, r, g, b)
+				lut.Table = append(lut.Table, [3]float64{float64(p[0]) / 255, float64(p[1]) / 255, float64(p[2]) / 255})
+			}
+		}
+	}
+	return lut
+}
+
+// CubeToHald resamples the LUT into a HaldCLUT so ApplyCLUT can use it. The level is the smallest
+// whose lattice is at least as fine as the LUT's, capped at MaxLevel
+func CubeToHald(lut *CubeLUT) (*image.RGBA, int, error) {
+	level := MinLevel
+	for level*level < lut.Size && level < MaxLevel {
+		level++
+	}
+
+	clut, err := GenerateIdentityCLUT(level)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// every pixel of the identity CLUT holds its own input color, look it up in the LUT
+	pix := clut.Pix
+	for i := 0; i < len(pix); i += 4 {
+		out := lut.sample([3]float64{float64(pix[i]) / 255, float64(pix[i+1]) / 255, float64(pix[i+2]) / 255})
+		for c := 0; c < 3; c++ {
+			pix[i+c] = uint8(math.Round(math.Max(0, math.Min(1, out[c])) * 255))
+		}
+	}
+	return clut, level, nil
+}
+
+// sample returns the trilinear interpolation of the LUT at the input color
+func (lut *CubeLUT) sample(in [3]float64) [3]float64 {
+	var lower, upper [3]int
+	var frac [3]float64
+	for c := 0; c < 3; c++ {
+		pos := (in[c] - lut.DomainMin[c]) / (lut.DomainMax[c] - lut.DomainMin[c]) * float64(lut.Size-1)
+		pos = math.Max(0, math.Min(float64(lut.Size-1), pos))
+		lower[c] = int(pos)
+		upper[c] = min(lower[c]+1, lut.Size-1)
+		frac[c] = pos - float64(lower[c])
+	}
+
+	at := func(r, g, b int) [3]float64 {
+		return lut.Table[(b*lut.Size+g)*lut.Size+r]
+	}
+
+	var out [3]float64
+	for corner := 0; corner < 8; corner++ {
+		r, wr := lower[0], 1-frac[0]
+		if corner&1 != 0 {
+			r, wr = upper[0], frac[0]
+		}
+		g, wg := lower[1], 1-frac[1]
+		if corner&2 != 0 {
+			g, wg = upper[1], frac[1]
+		}
+		b, wb := lower[2], 1-frac[2]
+		if corner&4 != 0 {
+			b, wb = upper[2], frac[2]
+		}
+
+		w := wr * wg * wb
+		if w == 0 {
+			continue
+		}
+		v := at(r, g, b)
+		for c := range out {
+			out[c] += v[c] * w
+		}
+	}
+	return out
+}
+
+// HaldLevel returns the level of a HaldCLUT image from its size, a level n CLUT is n^3 x n^3
+func HaldLevel(clut image.Image) (int, error) {
+	bounds := clut.Bounds()
+	for level := 2; level <= MaxLevel; level++ {
+		size := level * level * level
+		if bounds.Dx() == size && bounds.Dy() == size {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("%dx%d is not the size of a HaldCLUT", bounds.Dx(), bounds.Dy())
+}
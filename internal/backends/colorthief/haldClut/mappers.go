@@ -0,0 +1,278 @@
+package haldclut
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Achno/gowall/internal/colorspace"
+	"github.com/Achno/gowall/utils"
+)
+
+// Names of the mappers that generate a CLUT
+const (
+	MapperRBF       = "rbf"       // gaussian weights of the RGB distance (default)
+	MapperShepard   = "shepard"   // inverse distance weights of every palette color
+	MapperOKLabRBF  = "oklab-rbf" // gaussian weights of the OKLab distance, blended in OKLab
+	MapperKNearest  = "knn"       // inverse distance weights of the k nearest palette colors
+	MapperLuminance = "luminance" // keeps the lightness of the color and maps only hue and chroma
+)
+
+// MapperNames returns the names of all mappers
+func MapperNames() []string {
+	return []string{MapperRBF, MapperShepard, MapperOKLabRBF, MapperKNearest, MapperLuminance}
+}
+
+// MapperOptions are the parameters of the mappers, a zero value uses the default of the mapper
+type MapperOptions struct {
+	Sigma      float64 // width of the gaussian of rbf (RGB units, 50) and oklab-rbf / luminance (OKLab units x100, 10)
+	Power      float64 // exponent of the distance in shepard and knn, 2
+	Neighbours int     // palette colors blended by knn, 3
+}
+
+// KeyedMapper is implemented by mappers that can describe themselves and their parameters,
+// so CLUTs generated with different mappers don't share a cache entry
+type KeyedMapper interface {
+	Key() string
+}
+
+// MapperKey returns a short description of the mapper and its parameters, e.g. "knn-k3-p2"
+func MapperKey(mapper Mapperfunc) string {
+	if keyed, ok := mapper.(KeyedMapper); ok {
+		return keyed.Key()
+	}
+	return strings.ToLower(fmt.Sprintf("%T", mapper))
+}
+
+// NewMapper returns the mapper with the given name, empty means rbf
+func NewMapper(name string, options MapperOptions) (Mapperfunc, error) {
+	if options.Sigma < 0 || options.Power < 0 || options.Neighbours < 0 {
+		return nil, utils.InvalidParameter("mapper parameters can't be negative")
+	}
+
+	switch strings.ToLower(name) {
+	case MapperRBF, "":
+		return NewRBFMapperWithOptions(RBFMapperOptions{Sigma: options.Sigma}), nil
+	case MapperShepard:
+		return &ShepardMapper{Power: orDefault(options.Power, 2)}, nil
+	case MapperOKLabRBF:
+		return &OKLabRBFMapper{Sigma: orDefault(options.Sigma, 10)}, nil
+	case MapperKNearest:
+		neighbours := options.Neighbours
+		if neighbours == 0 {
+			neighbours = 3
+		}
+		return &KNearestMapper{Neighbours: neighbours, Power: orDefault(options.Power, 2)}, nil
+	case MapperLuminance:
+		return &LuminanceMapper{Sigma: orDefault(options.Sigma, 10)}, nil
+	default:
+		return nil, utils.InvalidParameter("unknown mapper: %s (available: %s)", name, strings.Join(MapperNames(), ", "))
+	}
+}
+
+// ShepardMapper blends every palette color weighted by the inverse of its distance to the color.
+// Unlike RBF the weights never vanish, so far away colors still pull a little
+type ShepardMapper struct {
+	Power float64
+}
+
+func (m *ShepardMapper) Map(original color.RGBA, palette []color.RGBA) color.RGBA {
+	return inverseDistance(original, palette, nil, m.Power)
+}
+
+func (m *ShepardMapper) MapWeighted(original color.RGBA, palette []color.RGBA, weights []float64) color.RGBA {
+	return inverseDistance(original, palette, weights, m.Power)
+}
+
+func (m *ShepardMapper) Key() string {
+	return MapperShepard + "-p" + formatParam(m.Power)
+}
+
+// KNearestMapper blends only the k palette colors closest to the color, weighted by inverse distance.
+// It keeps the colors closer to the palette than shepard or rbf
+type KNearestMapper struct {
+	Neighbours int
+	Power      float64
+}
+
+func (m *KNearestMapper) Map(original color.RGBA, palette []color.RGBA) color.RGBA {
+	return m.MapWeighted(original, palette, nil)
+}
+
+// MapWeighted picks the neighbours by distance alone, the weights only change how they are blended
+func (m *KNearestMapper) MapWeighted(original color.RGBA, palette []color.RGBA, weights []float64) color.RGBA {
+	if m.Neighbours >= len(palette) {
+		return inverseDistance(original, palette, weights, m.Power)
+	}
+
+	indices := kNearest(original, palette, m.Neighbours)
+	nearest := make([]color.RGBA, len(indices))
+	var nearestWeights []float64
+	if weights != nil {
+		nearestWeights = make([]float64, len(indices))
+	}
+	for i, idx := range indices {
+		nearest[i] = palette[idx]
+		if weights != nil {
+			nearestWeights[i] = weights[idx]
+		}
+	}
+	return inverseDistance(original, nearest, nearestWeights, m.Power)
+}
+
+// kNearest returns the indices of the k palette colors closest to the color, nearest first. They are kept
+// sorted while scanning the palette once, ties go to the lower index like with a stable sort
+func kNearest(original color.RGBA, palette []color.RGBA, k int) []int {
+	indices := make([]int, 0, k)
+	distances := make([]float64, 0, k)
+
+	for i, pColor := range palette {
+		distance := rgbDistance(original, pColor)
+		if len(indices) == k && distance >= distances[k-1] {
+			continue
+		}
+
+		pos := len(indices)
+		if pos < k {
+			indices = append(indices, 0)
+			distances = append(distances, 0)
+		} else {
+			pos = k - 1
+		}
+		for ; pos > 0 && distances[pos-1] > distance; pos-- {
+			indices[pos], distances[pos] = indices[pos-1], distances[pos-1]
+		}
+		indices[pos], distances[pos] = i, distance
+	}
+	return indices
+}
+
+func (m *KNearestMapper) Key() string {
+	return fmt.Sprintf("%s-k%d-p%s", MapperKNearest, m.Neighbours, formatParam(m.Power))
+}
+
+// OKLabRBFMapper is RBFMapper in OKLab, where distances follow perceived differences
+// and blending doesn't go through muddy intermediate colors
+type OKLabRBFMapper struct {
+	Sigma float64 // in OKLab units x100
+}
+
+func (m *OKLabRBFMapper) Map(original color.RGBA, palette []color.RGBA) color.RGBA {
+	return m.MapWeighted(original, palette, nil)
+}
+
+func (m *OKLabRBFMapper) MapWeighted(original color.RGBA, palette []color.RGBA, weights []float64) color.RGBA {
+	lab := oklabRBF(original, palette, weights, m.Sigma/100)
+	r, g, b := colorspace.OKLabToSRGB(lab)
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+func (m *OKLabRBFMapper) Key() string {
+	return MapperOKLabRBF + "-s" + formatParam(m.Sigma)
+}
+
+// LuminanceMapper takes the hue and chroma of the OKLab RBF blend of the palette,
+// but keeps the lightness of the color so the contrast and texture of the image survive
+type LuminanceMapper struct {
+	Sigma float64 // in OKLab units x100
+}
+
+func (m *LuminanceMapper) Map(original color.RGBA, palette []color.RGBA) color.RGBA {
+	return m.MapWeighted(original, palette, nil)
+}
+
+func (m *LuminanceMapper) MapWeighted(original color.RGBA, palette []color.RGBA, weights []float64) color.RGBA {
+	lab := oklabRBF(original, palette, weights, m.Sigma/100)
+	lab[0] = colorspace.OKLab(original.R, original.G, original.B)[0]
+
+	r, g, b := colorspace.OKLabToSRGB(lab)
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+func (m *LuminanceMapper) Key() string {
+	return MapperLuminance + "-s" + formatParam(m.Sigma)
+}
+
+// oklabRBF blends the palette in OKLab with gaussian weights of the OKLab distance
+func oklabRBF(original color.RGBA, palette []color.RGBA, weights []float64, sigma float64) [3]float64 {
+	target := colorspace.OKLab(original.R, original.G, original.B)
+
+	var sum [3]float64
+	var denominator float64
+	closest, minDist := [3]float64{}, math.MaxFloat64
+	for i, pColor := range palette {
+		p := colorspace.OKLab(pColor.R, pColor.G, pColor.B)
+		d2 := (p[0]-target[0])*(p[0]-target[0]) + (p[1]-target[1])*(p[1]-target[1]) + (p[2]-target[2])*(p[2]-target[2])
+		if d2 < minDist {
+			closest, minDist = p, d2
+		}
+
+		weight := math.Exp(-d2/(2*sigma*sigma)) * weightAt(weights, i)
+		for c := range sum {
+			sum[c] += p[c] * weight
+		}
+		denominator += weight
+	}
+
+	// every weight underflowed, the color is far from the whole palette
+	if denominator == 0 {
+		return closest
+	}
+	return [3]float64{sum[0] / denominator, sum[1] / denominator, sum[2] / denominator}
+}
+
+// inverseDistance blends the palette with weights 1/distance^power, a palette color equal to the color wins outright
+func inverseDistance(original color.RGBA, palette []color.RGBA, weights []float64, power float64) color.RGBA {
+	var numeratorR, numeratorG, numeratorB, denominator float64
+
+	for i, pColor := range palette {
+		distance := rgbDistance(original, pColor)
+		if distance == 0 {
+			return color.RGBA{R: pColor.R, G: pColor.G, B: pColor.B, A: 255}
+		}
+
+		weight := weightAt(weights, i) / math.Pow(distance, power)
+		numeratorR += float64(pColor.R) * weight
+		numeratorG += float64(pColor.G) * weight
+		numeratorB += float64(pColor.B) * weight
+		denominator += weight
+	}
+
+	if denominator == 0 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{
+		R: uint8(math.Round(numeratorR / denominator)),
+		G: uint8(math.Round(numeratorG / denominator)),
+		B: uint8(math.Round(numeratorB / denominator)),
+		A: 255,
+	}
+}
+
+func rgbDistance(a, b color.RGBA) float64 {
+	dr := float64(a.R) - float64(b.R)
+	dg := float64(a.G) - float64(b.G)
+	db := float64(a.B) - float64(b.B)
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+// weightAt returns the weight of the i-th palette color, 1 when there are no weights
+func weightAt(weights []float64, i int) float64 {
+	if weights == nil {
+		return 1
+	}
+	return weights[i]
+}
+
+func orDefault(v, fallback float64) float64 {
+	if v == 0 {
+		return fallback
+	}
+	return v
+}
+
+func formatParam(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,74 @@
+package haldclut
+
+import (
+	"image/color"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// sortedNearest is the k nearest selection by sorting the whole palette, ties going to the lower index
+func sortedNearest(original color.RGBA, palette []color.RGBA, k int) []int {
+	indices := make([]int, len(palette))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return rgbDistance(original, palette[indices[i]]) < rgbDistance(original, palette[indices[j]])
+	})
+	return indices[:k]
+}
+
+func TestKNearestMatchesSort(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomColor := func() color.RGBA {
+		return color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+	}
+
+	palette := make([]color.RGBA, 40)
+	for i := range palette {
+		palette[i] = randomColor()
+	}
+	// duplicates are ties at every distance
+	palette = append(palette, palette[2], palette[17], palette[2])
+
+	for _, k := range []int{1, 3, 5, len(palette) - 1} {
+		for n := 0; n < 500; n++ {
+			c := randomColor()
+			if n%10 == 0 {
+				c = palette[2]
+			}
+			got, want := kNearest(c, palette, k), sortedNearest(c, palette, k)
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("k=%d color %v: kNearest = %v, sorted = %v", k, c, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRBFMapperDefaultSigma(t *testing.T) {
+	mapper := NewRBFMapperWithOptions(RBFMapperOptions{})
+	if key := mapper.Key(); key != "rbf-s50" {
+		t.Errorf("key %q, want rbf-s50", key)
+	}
+	palette := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+	if got := mapper.Map(color.RGBA{10, 10, 10, 255}, palette); got.R > 10 {
+		t.Errorf("mapped a dark color to %v", got)
+	}
+}
+
+func BenchmarkKNearestMapper(b *testing.B) {
+	rng := rand.New(rand.NewSource(2))
+	palette := make([]color.RGBA, 200)
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+	}
+	mapper := &KNearestMapper{Neighbours: 3, Power: 2}
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		mapper.Map(color.RGBA{uint8(n), uint8(n >> 8), uint8(n >> 16), 0xff}, palette)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"math"
 )
 
+// RBFMapper blends the palette colors with gaussian weights of their RGB distance to the color.
+// Create it with NewRBFMapperWithOptions, which sets the default sigma
 type RBFMapper struct {
 	options RBFMapperOptions
 }
@@ -13,29 +15,44 @@ type RBFMapperOptions struct {
 	Sigma float64 // std makes the gaussian wider
 }
 
+// NewRBFMapper returns the default options of RBFMapper
 func NewRBFMapper() RBFMapperOptions {
 	return RBFMapperOptions{
 		Sigma: 50.0,
 	}
 }
 
+// NewRBFMapperWithOptions returns an RBFMapper with the options, a zero sigma uses the default
+func NewRBFMapperWithOptions(options RBFMapperOptions) *RBFMapper {
+	if options.Sigma <= 0 {
+		options.Sigma = NewRBFMapper().Sigma
+	}
+	return &RBFMapper{options: options}
+}
+
 func (m *RBFMapper) Map(original color.RGBA, palette []color.RGBA) color.RGBA {
+	return rbfInterpolation(original, palette, nil, m.options.Sigma)
+}
+
+func (m *RBFMapper) MapWeighted(original color.RGBA, palette []color.RGBA, weights []float64) color.RGBA {
+	return rbfInterpolation(original, palette, weights, m.options.Sigma)
+}
 
-	m.options = NewRBFMapper()
-	return rbfInterpolation(original, palette, m.options.Sigma)
+func (m *RBFMapper) Key() string {
+	return MapperRBF + "-s" + formatParam(m.options.Sigma)
 }
 
-func rbfInterpolation(target color.RGBA, palette []color.RGBA, sigma float64) color.RGBA {
+func rbfInterpolation(target color.RGBA, palette []color.RGBA, weights []float64, sigma float64) color.RGBA {
 	var numeratorR, numeratorG, numeratorB, denominator float64
 
-	for _, pColor := range palette {
+	for i, pColor := range palette {
 		// Euclidean distance between target and palette color
 		distance := math.Sqrt(math.Pow(float64(target.R)-float64(pColor.R), 2) +
 			math.Pow(float64(target.G)-float64(pColor.G), 2) +
 			math.Pow(float64(target.B)-float64(pColor.B), 2))
 
 		// Gaussian RBF weight
-		weight := math.Exp(-distance * distance / (2 * sigma * sigma))
+		weight := math.Exp(-distance*distance/(2*sigma*sigma)) * weightAt(weights, i)
 
 		// Weighted sum
 		numeratorR += float64(pColor.R) * weight
@@ -0,0 +1,91 @@
+// Package colorspace converts 8 bit sRGB colors to and from linear light, CIELAB and OKLab
+package colorspace
+
+import "math"
+
+// SRGBToLinear maps an 8 bit sRGB channel to linear light in [0,1], computed once
+var SRGBToLinear = func() [256]float64 {
+	var table [256]float64
+	for i := range table {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			table[i] = c / 12.92
+		} else {
+			table[i] = math.Pow((c+0.055)/1.055, 2.4)
+		}
+	}
+	return table
+}()
+
+// linearToSRGBTable maps linear light in 4096 steps back to 8 bit sRGB
+var linearToSRGBTable = func() [4096]uint8 {
+	var table [4096]uint8
+	for i := range table {
+		c := float64(i) / 4095
+		if c <= 0.0031308 {
+			c *= 12.92
+		} else {
+			c = 1.055*math.Pow(c, 1/2.4) - 0.055
+		}
+		table[i] = uint8(math.Round(c * 255))
+	}
+	return table
+}()
+
+// LinearToSRGB converts linear light to an 8 bit sRGB channel, values outside [0,1] are clipped
+func LinearToSRGB(v float64) uint8 {
+	return linearToSRGBTable[int(Clamp01(v)*4095+0.5)]
+}
+
+// Clamp01 clips v to [0,1]
+func Clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// Lab converts sRGB to CIELAB with a D65 white point
+func Lab(r, g, b uint8) [3]float64 {
+	lr, lg, lb := SRGBToLinear[r], SRGBToLinear[g], SRGBToLinear[b]
+
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / 0.95047
+	y := 0.2126729*lr + 0.7151522*lg + 0.0721750*lb
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / 1.08883
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+func labF(t float64) float64 {
+	const epsilon = 216.0 / 24389.0
+	const kappa = 24389.0 / 27.0
+	if t > epsilon {
+		return math.Cbrt(t)
+	}
+	return (kappa*t + 16) / 116
+}
+
+// OKLab converts sRGB to OKLab, see https://bottosson.github.io/posts/oklab/
+func OKLab(r, g, b uint8) [3]float64 {
+	lr, lg, lb := SRGBToLinear[r], SRGBToLinear[g], SRGBToLinear[b]
+
+	l := math.Cbrt(0.4122214708*lr + 0.5363325363*lg + 0.0514459929*lb)
+	m := math.Cbrt(0.2119034982*lr + 0.6806995451*lg + 0.1073969566*lb)
+	s := math.Cbrt(0.0883024619*lr + 0.2817188376*lg + 0.6299787005*lb)
+
+	return [3]float64{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}
+}
+
+// OKLabToSRGB converts OKLab back to 8 bit sRGB, colors outside of the sRGB gamut are clipped
+func OKLabToSRGB(lab [3]float64) (r, g, b uint8) {
+	l := lab[0] + 0.3963377774*lab[1] + 0.2158037573*lab[2]
+	m := lab[0] - 0.1055613458*lab[1] - 0.0638541728*lab[2]
+	s := lab[0] - 0.0894841775*lab[1] - 1.2914855480*lab[2]
+	l, m, s = l*l*l, m*m*m, s*s*s
+
+	return LinearToSRGB(4.0767416621*l - 3.3077115913*m + 0.2309699292*s),
+		LinearToSRGB(-1.2684380046*l + 2.6097574011*m - 0.3413193965*s),
+		LinearToSRGB(-0.0041960863*l - 0.7034186147*m + 1.7076147010*s)
+}
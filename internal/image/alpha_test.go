@@ -0,0 +1,204 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Achno/gowall/config"
+)
+
+// alphaPalette is the theme of the conversion cases, the test image only uses its colors
+var alphaPalette = []string{"#1E1E2E", "#F38BA8", "#A6E3A1", "#89B4FA", "#F9E2AF", "#CDD6F4"}
+
+// translucentImage is 32x32, made of 4x4 blocks of the palette colors with the alpha going from 1 at the top
+// to 249 at the bottom, so no pixel is fully transparent or opaque
+func translucentImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c, _ := HexToRGBA(alphaPalette[(x/4+y/4)%len(alphaPalette)])
+			img.SetNRGBA(x, y, color.NRGBA{c.R, c.G, c.B, uint8(1 + y*8)})
+		}
+	}
+	return img
+}
+
+// identityCube writes a 2x2x2 .cube LUT that maps every color to itself
+func identityCube(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "identity.cube")
+	content := "LUT_3D_SIZE 2\n"
+	for b := 0; b < 2; b++ {
+		for g := 0; g < 2; g++ {
+			for r := 0; r < 2; r++ {
+				content += fmt.Sprintf("%d %d %d\n", r, g, b)
+			}
+		}
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProcessorsKeepPartialAlpha(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	themePath := filepath.Join(t.TempDir(), "alpha.json")
+	themeJSON := `{"name": "alpha", "colors": ["#1E1E2E", "#F38BA8", "#A6E3A1", "#89B4FA", "#F9E2AF", "#CDD6F4"]}`
+	if err := os.WriteFile(themePath, []byte(themeJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	in := translucentImage()
+	const width = 32
+	same := func(x, y int) color.NRGBA { return in.NRGBAAt(x, y) }
+	inverted := func(c color.NRGBA) color.NRGBA { return color.NRGBA{255 - c.R, 255 - c.G, 255 - c.B, c.A} }
+	pink, _ := HexToRGBA("#F38BA8")
+
+	// the upscaler runs an external binary on the file and is left out
+	cases := []struct {
+		name      string
+		processor ImageProcessor
+		theme     string
+		nn        bool // ColorCorrectionBackend: nn
+		// expected is the output pixel at x, y, computed from the straight color of the input
+		expected func(x, y int) color.NRGBA
+		// tolerance of the RGB channels, for the processors that interpolate. The alpha has to match exactly
+		tolerance int
+		// the color is expected to be the one of the same image made opaque, for the processors that blend colors
+		likeOpaque bool
+	}{
+		{name: "noop", processor: &NoOpImageProcessor{}, expected: same},
+		{name: "flip", processor: &FlipProcessor{}, expected: func(x, y int) color.NRGBA { return in.NRGBAAt(width-1-x, y) }},
+		{name: "mirror", processor: &MirrorProcessor{}, expected: func(x, y int) color.NRGBA {
+			if x < width/2 {
+				return in.NRGBAAt(x, y)
+			}
+			return in.NRGBAAt(width-1-x, y)
+		}},
+		{name: "grayscale", processor: &GrayScaleProcessor{}, expected: func(x, y int) color.NRGBA {
+			c := in.NRGBAAt(x, y)
+			gray := uint8(0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B))
+			return color.NRGBA{gray, gray, gray, c.A}
+		}},
+		{name: "brightness", processor: &BrightnessProcessor{Factor: 1.5}, expected: func(x, y int) color.NRGBA {
+			c := in.NRGBAAt(x, y)
+			scale := func(v uint8) uint8 { return uint8(clamp(int(float64(v)*1.5), 0, 255)) }
+			return color.NRGBA{scale(c.R), scale(c.G), scale(c.B), c.A}
+		}},
+		{name: "invert", processor: &Inverter{}, expected: func(x, y int) color.NRGBA { return inverted(in.NRGBAAt(x, y)) }},
+		{name: "draw", processor: &DrawProcessor{Color: color.RGBA{255, 0, 0, 255}, BorderThickness: 2}, expected: func(x, y int) color.NRGBA {
+			if x < 2 || y < 2 || x >= width-2 || y >= width-2 {
+				return color.NRGBA{255, 0, 0, 255}
+			}
+			return in.NRGBAAt(x, y)
+		}},
+		{name: "pixelate", processor: &PixelateProcessor{Scale: 25}, expected: func(x, y int) color.NRGBA {
+			return in.NRGBAAt(x/4*4, y/4*4)
+		}},
+		{name: "replace", processor: &ReplaceProcessor{FromColor: "#F38BA8", ToColor: "#00FF00", Threshold: 8.5}, expected: func(x, y int) color.NRGBA {
+			c := in.NRGBAAt(x, y)
+			if c.R == pink.R && c.G == pink.G && c.B == pink.B {
+				return color.NRGBA{0, 255, 0, c.A}
+			}
+			return c
+		}},
+		{name: "convert nn", processor: &ThemeConverter{}, theme: themePath, nn: true, expected: same},
+		{name: "convert dither", processor: &ThemeConverter{Dither: DitherFloydSteinberg}, theme: themePath, expected: same},
+		{name: "convert clut", processor: &ThemeConverter{}, theme: themePath, likeOpaque: true},
+		{name: "convert preserve luminance", processor: &ThemeConverter{PreserveLuminance: 0.5, Strength: 0.8}, theme: themePath, likeOpaque: true},
+		{name: "lut", processor: &LUTProcessor{Path: identityCube(t), Interpolation: "trilinear"}, expected: same, tolerance: 1},
+		{name: "pipeline", processor: &PipelineProcessor{Steps: []PipelineStep{
+			{Name: "invert", Processor: &Inverter{}},
+			{Name: "flip", Processor: &FlipProcessor{}},
+		}}, expected: func(x, y int) color.NRGBA { return inverted(in.NRGBAAt(width-1-x, y)) }},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := config.GowallConfig.ColorCorrectionBackend
+			if tc.nn {
+				config.GowallConfig.ColorCorrectionBackend = "nn"
+			}
+			defer func() { config.GowallConfig.ColorCorrectionBackend = backend }()
+
+			out, err := tc.processor.Process(context.Background(), in, tc.theme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := tc.expected
+			if tc.likeOpaque {
+				opaque, err := tc.processor.Process(context.Background(), opaqueCopy(in), tc.theme)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected = func(x, y int) color.NRGBA {
+					c := color.NRGBAModel.Convert(opaque.At(x, y)).(color.NRGBA)
+					c.A = in.NRGBAAt(x, y).A
+					return c
+				}
+			}
+			if out.Bounds() != in.Bounds() {
+				t.Fatalf("bounds %v, want %v", out.Bounds(), in.Bounds())
+			}
+
+			for y := 0; y < width; y++ {
+				for x := 0; x < width; x++ {
+					got := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA)
+					want := expected(x, y)
+					if got.A != want.A {
+						t.Fatalf("alpha at %d,%d = %d, want %d", x, y, got.A, want.A)
+					}
+					if !withinTolerance(got, want, tc.tolerance) {
+						t.Fatalf("color at %d,%d = %v, want %v (tolerance %d)", x, y, got, want, tc.tolerance)
+					}
+				}
+			}
+		})
+	}
+}
+
+// the background remover makes the background transparent on purpose, every other pixel has to stay as it was
+func TestBackgroundRemovalKeepsPartialAlpha(t *testing.T) {
+	in := translucentImage()
+	out, err := (&BackgroundProcessor{}).Process(context.Background(), in, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			got := color.NRGBAModel.Convert(out.At(x, y)).(color.NRGBA)
+			if got.A != 0 && got != in.NRGBAAt(x, y) {
+				t.Fatalf("kept pixel at %d,%d = %v, want %v", x, y, got, in.NRGBAAt(x, y))
+			}
+		}
+	}
+}
+
+// opaqueCopy returns the image with every alpha set to 255
+func opaqueCopy(img *image.NRGBA) *image.NRGBA {
+	opaque := image.NewNRGBA(img.Rect)
+	copy(opaque.Pix, img.Pix)
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xff
+	}
+	return opaque
+}
+
+func withinTolerance(a, b color.NRGBA, tolerance int) bool {
+	diff := func(x, y uint8) int {
+		if x > y {
+			return int(x - y)
+		}
+		return int(y - x)
+	}
+	return diff(a.R, b.R) <= tolerance && diff(a.G, b.G) <= tolerance && diff(a.B, b.B) <= tolerance
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"context"
+	"image"
+	"os"
+	"sync"
+)
+
+// bytesPerPixel estimates the memory one pixel needs while an image is processed:
+// the decoded image, the copy most processors draw into and the encoded output buffer
+const bytesPerPixel = 12
+
+// pixelBudget limits how many pixels can be decoded at the same time
+type pixelBudget struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+	freed chan struct{} // closed and replaced every time pixels are released
+}
+
+func newPixelBudget(limit int64) *pixelBudget {
+	if limit < 1 {
+		limit = 1
+	}
+	return &pixelBudget{limit: limit, freed: make(chan struct{})}
+}
+
+// acquire blocks until n pixels fit in the budget and returns how many were reserved.
+// An image larger than the whole budget is allowed once nothing else is in flight
+func (b *pixelBudget) acquire(ctx context.Context, n int64) (int64, error) {
+	n = min(max(n, 1), b.limit)
+
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return n, nil
+		}
+		wait := b.freed
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+func (b *pixelBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// estimatePixels reads only the header of the image to get its dimensions.
+// Unreadable images count as a single pixel, ProcessImg reports the real error
+func estimatePixels(filePath string) int64 {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 1
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 1
+	}
+	return int64(cfg.Width) * int64(cfg.Height)
+}
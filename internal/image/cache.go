@@ -0,0 +1,294 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Achno/gowall/utils"
+)
+
+// clutCacheFolder is the folder of the generated CLUTs, inside the cache directory
+const clutCacheFolder = "cluts"
+
+var (
+	clutCacheOnce sync.Once
+	clutCacheDir  string
+	clutCacheErr  error
+)
+
+// CLUTCacheDirectory returns the folder the generated CLUTs are cached in and creates it.
+// CLUTs cached by older versions in the output folder are moved there the first time
+func CLUTCacheDirectory() (string, error) {
+	clutCacheOnce.Do(func() {
+		cacheDir, err := utils.CacheDirectory()
+		if err != nil {
+			clutCacheErr = fmt.Errorf("while finding the cache directory: %w", err)
+			return
+		}
+		dir := filepath.Join(cacheDir, clutCacheFolder)
+		if err := os.MkdirAll(dir, dirPermissions); err != nil {
+			clutCacheErr = fmt.Errorf("while creating the CLUT cache: %w", err)
+			return
+		}
+		if err := migrateCLUTCache(dir); err != nil {
+			clutCacheErr = fmt.Errorf("while moving the CLUT cache to %s: %w", dir, err)
+			return
+		}
+		clutCacheDir = dir
+	})
+	return clutCacheDir, clutCacheErr
+}
+
+// migrateCLUTCache moves the CLUTs of the old cache in the output folder to dir and removes the old folder
+func migrateCLUTCache(dir string) error {
+	outputDir, err := utils.OutputDirectory()
+	if err != nil {
+		return err
+	}
+	oldDir := filepath.Join(outputDir, clutCacheFolder)
+	if oldDir == dir {
+		return nil
+	}
+
+	entries, err := os.ReadDir(oldDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		oldPath, newPath := filepath.Join(oldDir, entry.Name()), filepath.Join(dir, entry.Name())
+
+		// the new cache wins, the old copy is stale
+		if _, err := os.Stat(newPath); err == nil {
+			if err := os.Remove(oldPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := moveFile(oldPath, newPath); err != nil {
+			return err
+		}
+	}
+
+	// only removed when empty, anything the user put there stays
+	_ = os.Remove(oldDir)
+	return nil
+}
+
+// moveFile renames the file, or copies and removes it when the paths are on different filesystems
+func moveFile(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(newPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(newPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+
+	src.Close()
+	return os.Remove(oldPath)
+}
+
+// CacheEntry is a CLUT in the cache
+type CacheEntry struct {
+	Path     string
+	Theme    string // empty when the file name is not one gowall writes, e.g. a CLUT of an older version
+	Hash     string // hash of the theme colors the CLUT was generated from
+	Level    int
+	Mapper   string // key of the mapper, e.g. rbf-s50
+	Size     int64
+	LastUsed time.Time
+}
+
+// clutFilenamePattern matches the names of createSafeClutFilename, theme_hash_lLevel_mapper.png
+var clutFilenamePattern = regexp.MustCompile(`^(.+)_([0-9a-f]{16})_l(\d+)_(.+)\.png$`)
+
+// ListCLUTCache returns the CLUTs in the cache, the most recently used first
+func ListCLUTCache() ([]CacheEntry, error) {
+	dir, err := CLUTCacheDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("while reading the CLUT cache: %w", err)
+	}
+
+	entries := make([]CacheEntry, 0, len(files))
+	for _, file := range files {
+		// skip the lock files and the CLUTs still being written
+		if !file.Type().IsRegular() || filepath.Ext(file.Name()) != ".png" || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entry := CacheEntry{
+			Path:     filepath.Join(dir, file.Name()),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		}
+		if match := clutFilenamePattern.FindStringSubmatch(file.Name()); match != nil {
+			entry.Theme, entry.Hash, entry.Mapper = match[1], match[2], match[4]
+			entry.Level, _ = strconv.Atoi(match[3])
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// PruneCLUTCache removes the CLUTs whose colors don't match any loaded theme anymore,
+// because the theme was edited or removed, and the CLUTs gowall can't identify. It returns the removed entries
+func PruneCLUTCache() ([]CacheEntry, error) {
+	entries, err := ListCLUTCache()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]bool)
+	for _, name := range ListThemes() {
+		theme, err := SelectTheme(name)
+		if err != nil {
+			continue
+		}
+		current[themeHash(theme)] = true
+	}
+
+	var removed []CacheEntry
+	for _, entry := range entries {
+		if entry.Hash != "" && current[entry.Hash] {
+			continue
+		}
+		if err := removeCacheEntry(entry); err != nil {
+			return removed, err
+		}
+		removed = append(removed, entry)
+	}
+	return removed, nil
+}
+
+// ClearCLUTCache removes every CLUT of the cache and returns the removed entries
+func ClearCLUTCache() ([]CacheEntry, error) {
+	entries, err := ListCLUTCache()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		if err := removeCacheEntry(entry); err != nil {
+			return entries[:i], err
+		}
+	}
+	return entries, nil
+}
+
+// removeCacheEntry removes the CLUT and its lock file
+func removeCacheEntry(entry CacheEntry) error {
+	if err := os.Remove(entry.Path); err != nil {
+		return fmt.Errorf("while removing %s: %w", entry.Path, err)
+	}
+	if err := os.Remove(entry.Path + ".lock"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("while removing %s: %w", entry.Path+".lock", err)
+	}
+	return nil
+}
+
+// WarmOptions configures WarmCLUTCache
+type WarmOptions struct {
+	Jobs   int                           // CLUTs generated at the same time, defaults to runtime.NumCPU()
+	OnDone func(theme string, err error) // called after each theme, from the goroutine that generated it
+}
+
+// WarmCLUTCache generates the CLUTs of the themes with the converter's level and mapper, so converting
+// with them doesn't have to wait for the CLUT. Themes whose CLUT is cached are skipped.
+// It returns the errors of all themes that failed
+func WarmCLUTCache(ctx context.Context, themes []string, converter *ThemeConverter, opts ...WarmOptions) error {
+	options := WarmOptions{Jobs: runtime.NumCPU()}
+	if len(opts) > 0 {
+		options = opts[0]
+		if options.Jobs <= 0 {
+			options.Jobs = runtime.NumCPU()
+		}
+	}
+
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for _, theme := range themes {
+			select {
+			case jobs <- theme:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+
+	for i := 0; i < min(options.Jobs, len(themes)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for theme := range jobs {
+				_, _, err := converter.CLUT(ctx, theme)
+				if err != nil {
+					err = fmt.Errorf("%s: %w", theme, err)
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+				if options.OnDone != nil {
+					options.OnDone(theme, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
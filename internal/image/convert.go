@@ -1,95 +1,262 @@
 package image
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"image/draw"
-	"math"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Achno/gowall/config"
 	haldclut "github.com/Achno/gowall/internal/backends/colorthief/haldClut"
 	"github.com/Achno/gowall/utils"
 )
 
-var clutMutex sync.Mutex
+// clutLocks holds a mutex per CLUT path, so a CLUT is generated once while different CLUTs are generated in parallel
+var clutLocks sync.Map
 
 // Constants for file operations
 const (
 	dirPermissions = 0755 // Directory creation permissions
 )
 
+// Defaults of the CLUT backend when neither the converter nor the config set them
+const (
+	defaultCLUTLevel         = 8
+	defaultCLUTInterpolation = haldclut.InterpolationNearest
+)
+
 // ThemeConverter handles the conversion of images using color themes
 type ThemeConverter struct {
+	Metric         string                 // color distance metric of the nearest neighbour backend, defaults to ColorDistanceMetric of the config
+	Dither         string                 // dithering mode, any mode but "none" maps the colors with the nearest neighbour backend
+	DitherStrength float64                // fraction of the dithering to apply in (0,1], 0 means 1
+	Level          int                    // HaldCLUT level in [4,16], defaults to CLUTLevel of the config or 8
+	Interpolation  string                 // between the CLUT lattice points, defaults to CLUTInterpolation of the config or nearest
+	Mapper         string                 // generates the CLUT, one of haldclut.MapperNames, defaults to CLUTMapper of the config or rbf
+	MapperOptions  haldclut.MapperOptions // parameters of the mapper, zero fields use MapperSigma, MapperPower and MapperNeighbours of the config
+	// PreserveLuminance keeps this fraction of the original lightness in [0,1], 1 maps only hue and chroma to the theme
+	PreserveLuminance float64
+	Strength          float64 // mix of the converted image over the original in (0,1], 0 means 1
+}
+
+// Validate checks the theme, the distance metric, the dithering mode, the tone and the CLUT settings
+func (themeConv *ThemeConverter) Validate(theme string) error {
+	if _, err := SelectTheme(theme); err != nil {
+		return fmt.Errorf("theme selection error: %w", err)
+	}
+	if err := ValidateDistanceMetric(themeConv.metric()); err != nil {
+		return err
+	}
+	if err := ValidateDither(themeConv.Dither, themeConv.DitherStrength); err != nil {
+		return err
+	}
+	if err := ValidateTone(themeConv.tone()); err != nil {
+		return err
+	}
+	if err := ValidateCLUTLevel(themeConv.level()); err != nil {
+		return err
+	}
+	if err := ValidateInterpolation(themeConv.interpolation()); err != nil {
+		return err
+	}
+	_, err := themeConv.mapper()
+	return err
+}
+
+// level returns the CLUT level of the converter, or the one from the config
+func (themeConv *ThemeConverter) level() int {
+	switch {
+	case themeConv.Level != 0:
+		return themeConv.Level
+	case config.GowallConfig.CLUTLevel != 0:
+		return config.GowallConfig.CLUTLevel
+	}
+	return defaultCLUTLevel
+}
+
+// interpolation returns the CLUT interpolation of the converter, or the one from the config
+func (themeConv *ThemeConverter) interpolation() string {
+	return clutInterpolation(themeConv.Interpolation)
+}
+
+// clutInterpolation returns the interpolation if set, or the one from the config, or the default
+func clutInterpolation(interpolation string) string {
+	switch {
+	case interpolation != "":
+		return interpolation
+	case config.GowallConfig.CLUTInterpolation != "":
+		return config.GowallConfig.CLUTInterpolation
+	}
+	return string(defaultCLUTInterpolation)
+}
+
+// mapper builds the CLUT mapper of the converter, falling back to the config for anything not set
+func (themeConv *ThemeConverter) mapper() (haldclut.Mapperfunc, error) {
+	cfg := config.GowallConfig
+
+	name := themeConv.Mapper
+	if name == "" {
+		name = cfg.CLUTMapper
+	}
+
+	options := themeConv.MapperOptions
+	if options.Sigma == 0 {
+		options.Sigma = cfg.MapperSigma
+	}
+	if options.Power == 0 {
+		options.Power = cfg.MapperPower
+	}
+	if options.Neighbours == 0 {
+		options.Neighbours = cfg.MapperNeighbours
+	}
+
+	return haldclut.NewMapper(name, options)
+}
+
+// Interpolations returns the names of the ways to interpolate between CLUT lattice points
+func Interpolations() []string {
+	return []string{
+		string(haldclut.InterpolationNearest),
+		string(haldclut.InterpolationTrilinear),
+		string(haldclut.InterpolationTetrahedral),
+	}
+}
+
+// ValidateCLUTLevel checks that the level is within the sizes a HaldCLUT can reasonably have
+func ValidateCLUTLevel(level int) error {
+	if level < haldclut.MinLevel || level > haldclut.MaxLevel {
+		return utils.InvalidParameter("CLUT level must be between %d and %d, got %d", haldclut.MinLevel, haldclut.MaxLevel, level)
+	}
+	return nil
+}
+
+// ValidateInterpolation checks that the interpolation is one of Interpolations, empty means the default
+func ValidateInterpolation(interpolation string) error {
+	if interpolation == "" {
+		return nil
+	}
+	for _, i := range Interpolations() {
+		if strings.EqualFold(i, interpolation) {
+			return nil
+		}
+	}
+	return utils.InvalidParameter("unknown CLUT interpolation: %s (available: %s)", interpolation, strings.Join(Interpolations(), ", "))
+}
+
+// metric returns the distance metric of the converter, or the one from the config
+func (themeConv *ThemeConverter) metric() string {
+	if themeConv.Metric != "" {
+		return themeConv.Metric
+	}
+	return config.GowallConfig.ColorDistanceMetric
 }
 
 // Process applies a color theme to an image and returns the transformed image
-// The level parameter controls the quality/detail of the color transformation
-// Higher levels provide more accurate color mapping but take longer to process
-func (themeConv *ThemeConverter) Process(img image.Image, theme string) (image.Image, error) {
-	level := 8
+// The level controls the quality/detail of the color transformation
+// Higher levels provide more accurate color mapping but take longer to generate the CLUT
+func (themeConv *ThemeConverter) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
+	interpolation := themeConv.interpolation()
+	if err := ValidateInterpolation(interpolation); err != nil {
+		return nil, err
+	}
 
 	selectedTheme, err := SelectTheme(theme)
 	if err != nil {
 		return nil, fmt.Errorf("theme selection error: %w", err)
 	}
 
-	// Use NearestNeighbour backend if specified in the config
-	if config.GowallConfig.ColorCorrectionBackend == "nn" {
-		return NearestNeighbour(img, selectedTheme)
+	tone := themeConv.tone()
+	if err := ValidateTone(tone); err != nil {
+		return nil, err
 	}
 
-	// Get or create output directory for CLUTs
-	dirFolder, err := utils.CreateDirectory()
+	// Use NearestNeighbour backend if specified in the config, dithering only makes sense against the palette
+	if config.GowallConfig.ColorCorrectionBackend == "nn" || isDithering(themeConv.Dither) {
+		newImg, err := NearestNeighbour(ctx, img, selectedTheme, NNOptions{
+			Metric:         themeConv.metric(),
+			Dither:         themeConv.Dither,
+			DitherStrength: themeConv.DitherStrength,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return AdjustTone(img, newImg, tone), nil
+	}
+
+	clut, level, err := themeConv.CLUT(ctx, theme)
 	if err != nil {
-		return nil, fmt.Errorf("creating directory: %w", err)
+		return nil, err
 	}
 
-	// Get theme colors and create a hash to identify the CLUT file
-	themeColors, err := GetThemeColors(theme)
+	// Apply the CLUT to the image
+	return ApplyThemeCLUT(img, clut, level, interpolation, tone), nil
+}
+
+// tone returns the luminance and strength options of the converter
+func (themeConv *ThemeConverter) tone() ToneOptions {
+	return ToneOptions{PreserveLuminance: themeConv.PreserveLuminance, Strength: themeConv.Strength}
+}
+
+// CLUT returns the HaldCLUT of the theme and its level, generated with the converter's level and mapper.
+// It is read from the CLUT cache, or generated and cached when missing
+func (themeConv *ThemeConverter) CLUT(ctx context.Context, theme string) (*image.RGBA, int, error) {
+	level := themeConv.level()
+	if err := ValidateCLUTLevel(level); err != nil {
+		return nil, 0, err
+	}
+	mapper, err := themeConv.mapper()
 	if err != nil {
-		return nil, fmt.Errorf("getting theme colors: %w", err)
+		return nil, 0, err
 	}
-	colorHash := hashPalette(themeColors)
 
-	// Create a safe filename for the CLUT
-	clutFilename := createSafeClutFilename(theme, colorHash)
-	clutPath := filepath.Join(dirFolder, "cluts", clutFilename)
+	selectedTheme, err := SelectTheme(theme)
+	if err != nil {
+		return nil, 0, fmt.Errorf("theme selection error: %w", err)
+	}
 
-	// Generate CLUT if it doesn't exist
-	if err := ensureClutExists(clutPath, selectedTheme, level); err != nil {
-		return nil, err
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
 	}
 
-	// Load the CLUT file
-	clut, err := haldclut.LoadHaldCLUT(clutPath)
+	// Get or create the CLUT cache directory
+	cacheDir, err := CLUTCacheDirectory()
 	if err != nil {
-		return nil, fmt.Errorf("loading CLUT: %w", err)
+		return nil, 0, err
 	}
-	if clut == nil {
-		return nil, fmt.Errorf("CLUT is nil after loading")
+
+	// Hash the theme colors to identify the CLUT file
+	colorHash := themeHash(selectedTheme)
+
+	// Create a safe filename for the CLUT
+	clutFilename := createSafeClutFilename(theme, colorHash, level, haldclut.MapperKey(mapper))
+	clutPath := filepath.Join(cacheDir, clutFilename)
+
+	// Load the cached CLUT, or generate it if it is missing or corrupt
+	clut, err := loadOrGenerateCLUT(ctx, clutPath, selectedTheme, level, mapper)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// Apply the CLUT to the image
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
-	newImg := haldclut.ApplyCLUT(rgba, clut, level)
+	// the modification time is when the CLUT was last used, for gowall cache list
+	now := time.Now()
+	_ = os.Chtimes(clutPath, now, now)
 
-	return newImg, nil
+	return clut, level, nil
 }
 
 // createSafeClutFilename creates a safe filename for the CLUT based on the theme name/path
 // If the theme is a file path, it extracts just the base name to avoid path issues
-// Returns a filename combining the sanitized theme name and a hash of the colors
-func createSafeClutFilename(theme, hash string) string {
+// Returns a filename combining the sanitized theme name, a hash of the colors, the level and the mapper
+func createSafeClutFilename(theme, hash string, level int, mapperKey string) string {
 	// If the theme is a file path, extract just the base name
 	themeName := theme
 	if isLikelyPath(theme) {
@@ -97,7 +264,7 @@ func createSafeClutFilename(theme, hash string) string {
 		themeName = strings.TrimSuffix(baseName, filepath.Ext(baseName))
 	}
 
-	return fmt.Sprintf("%s_%s.png", themeName, hash)
+	return fmt.Sprintf("%s_%s_l%d_%s.png", themeName, hash, level, mapperKey)
 }
 
 // isLikelyPath checks if a string appears to be a file path
@@ -106,121 +273,131 @@ func isLikelyPath(s string) bool {
 	return filepath.IsAbs(s) || strings.HasPrefix(s, "~") || strings.Contains(s, "/") || strings.Contains(s, "\\")
 }
 
-// ensureClutExists generates a CLUT file if it doesn't already exist
-// Uses lock to prevent race conditions when multiple goroutines try to create the same file
-// Returns an error if the file cannot be created or the CLUT generation fails
-func ensureClutExists(clutPath string, theme Theme, level int) error {
-	clutMutex.Lock()
-	defer clutMutex.Unlock()
+// loadOrGenerateCLUT loads the cached CLUT at clutPath, or generates and caches it when it is missing or corrupt.
+// Generation holds a lock per path within the process and a lock file across processes, so concurrent gowall
+// runs generate a CLUT only once. The CLUT is written to a temporary file and renamed, so it is never read half written
+func loadOrGenerateCLUT(ctx context.Context, clutPath string, theme Theme, level int, mapper haldclut.Mapperfunc) (*image.RGBA, error) {
+	// the common case, the CLUT is cached and no lock is needed
+	clut, err := loadCachedCLUT(clutPath, level)
+	if err == nil && clut != nil {
+		return clut, nil
+	}
+
+	lock, _ := clutLocks.LoadOrStore(clutPath, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
 
-	// If CLUT already exists, we can use it
-	if _, err := os.Stat(clutPath); err == nil {
-		return nil
+	unlock, err := utils.LockFile(ctx, clutPath+".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// another goroutine or process may have generated it while we waited for the lock
+	clut, err = loadCachedCLUT(clutPath, level)
+	if err == nil && clut != nil {
+		return clut, nil
+	}
+	if err != nil {
+		log.Printf("regenerating corrupt CLUT %s: %v", clutPath, err)
 	}
 
-	// Create parent directory if needed
-	clutDir := filepath.Dir(clutPath)
-	if err := os.MkdirAll(clutDir, dirPermissions); err != nil {
-		return fmt.Errorf("creating CLUT directory: %w", err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	clut, err = GenerateThemeCLUT(ctx, theme, level, mapper)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the CLUT to disk
+	if err := haldclut.SaveHaldCLUT(clut, clutPath); err != nil {
+		return nil, fmt.Errorf("saving CLUT: %w", err)
+	}
+
+	return clut, nil
+}
+
+// loadCachedCLUT loads the CLUT at clutPath and checks it has the size of the level.
+// It returns nil without an error when the CLUT isn't cached
+func loadCachedCLUT(clutPath string, level int) (*image.RGBA, error) {
+	clut, err := haldclut.LoadHaldCLUT(clutPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size := level * level * level; clut.Bounds().Dx() != size || clut.Bounds().Dy() != size {
+		return nil, fmt.Errorf("CLUT is %dx%d, a level %d CLUT is %dx%d", clut.Bounds().Dx(), clut.Bounds().Dy(), level, size, size)
 	}
+	return clut, nil
+}
 
+// GenerateThemeCLUT creates the HaldCLUT that maps every color to the theme with the mapper, in memory
+// without reading or writing the CLUT cache. A nil mapper uses the default RBF mapper
+func GenerateThemeCLUT(ctx context.Context, theme Theme, level int, mapper haldclut.Mapperfunc) (*image.RGBA, error) {
 	// Generate identity CLUT
 	identityClut, err := haldclut.GenerateIdentityCLUT(level)
 	if err != nil {
-		return fmt.Errorf("generating identity CLUT: %w", err)
+		return nil, fmt.Errorf("generating identity CLUT: %w", err)
 	}
 
 	// Convert theme colors to RGBA format
 	palette, err := toRGBA(theme.Colors)
 	if err != nil {
-		return fmt.Errorf("converting colors to RGBA: %w", err)
+		return nil, fmt.Errorf("converting colors to RGBA: %w", err)
 	}
 
 	// Create the modified CLUT
-	mapper := &haldclut.RBFMapper{}
-	modifiedClut := haldclut.InterpolateCLUT(identityClut, palette, level, mapper)
-
-	// Save the CLUT to disk
-	if err := haldclut.SaveHaldCLUT(modifiedClut, clutPath); err != nil {
-		return fmt.Errorf("saving CLUT: %w", err)
+	if mapper == nil {
+		mapper = haldclut.NewRBFMapperWithOptions(haldclut.RBFMapperOptions{})
+	}
+	// the background and foreground roles pull harder than the other colors
+	modifiedClut, err := haldclut.InterpolateCLUT(ctx, identityClut, palette, level, mapper, haldclut.InterpolateOptions{
+		Weights: paletteWeights(theme),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("interpolating CLUT: %w", err)
 	}
+	return modifiedClut, nil
+}
 
-	return nil
+// NNOptions configures NearestNeighbour
+type NNOptions struct {
+	Metric         string  // one of DistanceMetrics, defaults to MetricWeightedRGB
+	Dither         string  // one of DitherModes, defaults to DitherNone
+	DitherStrength float64 // fraction of the dithering to apply in (0,1], 0 means 1
 }
 
 // NearestNeighbour transforms an image by mapping each pixel to the closest color in the theme
 // This is a simpler but potentially faster alternative to CLUT-based color mapping
-// It works by finding the closest theme color for each pixel in the image
-func NearestNeighbour(img image.Image, theme Theme) (image.Image, error) {
-	bounds := img.Bounds()
-	newImg := image.NewRGBA(bounds)
-
-	// Replace each pixel with the selected theme's nearest color
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := img.At(x, y)
-			newColor := nearestColor(originalColor, theme)
-			newImg.Set(x, y, newColor)
-		}
+// The theme colors are converted to the color space of the metric once, before the pixels are mapped,
+// and the rows of the image are mapped in parallel, see mapNearest. Cancelling ctx stops the mapping
+func NearestNeighbour(ctx context.Context, img image.Image, theme Theme, opts ...NNOptions) (image.Image, error) {
+	var options NNOptions
+	if len(opts) > 0 {
+		options = opts[0]
 	}
 
-	if newImg == nil {
-		return nil, errors.New("error processing the Image")
+	if len(theme.Colors) == 0 {
+		return nil, utils.InvalidParameter("theme %q has no colors", theme.Name)
 	}
 
-	return newImg, nil
-}
-
-// nearestColor finds the closest color in the theme to the given input color
-// It computes the perceptual distance between the input color and each theme color
-// and returns the theme color with the smallest distance
-func nearestColor(clr color.Color, theme Theme) color.Color {
-	r, g, b, _ := clr.RGBA()
-
-	// Convert from 16-bit to 8-bit
-	r, g, b = r>>8, g>>8, b>>8
-
-	minDist := math.MaxFloat64
-	var nearestClr color.Color
-
-	for _, themeColor := range theme.Colors {
-		tr, tg, tb, _ := themeColor.RGBA()
-		// Convert from 16-bit to 8-bit
-		tr, tg, tb = tr>>8, tg>>8, tb>>8
-
-		distance := colorDistance(tr, tg, tb, r, g, b)
-
-		if distance < minDist {
-			minDist = distance
-			nearestClr = themeColor
-		}
+	matcher, err := newPaletteMatcher(theme.Colors, options.Metric)
+	if err != nil {
+		return nil, err
 	}
 
-	return nearestClr
-}
-
-// colorDistance calculates the perceptual distance between two colors using a weighted approach
-// This approximates human perception by giving green more weight than red, and red more than blue
-func colorDistance(r1, g1, b1, r2, g2, b2 uint32) float64 {
-	// Weighted RGB components based on human perception
-	// These weights approximate human color perception sensitivity
-	// Humans are most sensitive to green, then red, then blue
-	const (
-		redWeight   = 0.299
-		greenWeight = 0.587
-		blueWeight  = 0.114
-	)
-
-	// Calculate squared differences with perceptual weights
-	rDiff := float64(r1) - float64(r2)
-	gDiff := float64(g1) - float64(g2)
-	bDiff := float64(b1) - float64(b2)
-
-	weightedDistance := redWeight*rDiff*rDiff +
-		greenWeight*gDiff*gDiff +
-		blueWeight*bDiff*bDiff
+	if err := ValidateDither(options.Dither, options.DitherStrength); err != nil {
+		return nil, err
+	}
+	if isDithering(options.Dither) {
+		return ditherImage(ctx, img, matcher, options.Dither, options.DitherStrength)
+	}
 
-	return math.Sqrt(weightedDistance)
+	return mapNearest(ctx, img, matcher)
 }
 
 // toRGBA converts a slice of color.Color to a slice of color.RGBA
@@ -239,6 +416,19 @@ func toRGBA(clrs []color.Color) ([]color.RGBA, error) {
 	return rgbaColors, nil
 }
 
+// themeHash creates the hash of the theme colors and of the roles that weigh its CLUT.
+// A theme without roles hashes like its colors alone
+func themeHash(theme Theme) string {
+	colors := make([]string, 0, len(theme.Colors)+1)
+	for _, c := range theme.Colors {
+		colors = append(colors, RGBtoHex(color.RGBAModel.Convert(c).(color.RGBA)))
+	}
+	if key := weightKey(theme); key != "" {
+		colors = append(colors, key)
+	}
+	return hashPalette(colors)
+}
+
 // hashPalette creates a hash from a slice of color strings
 // This is used to uniquely identify a color palette for CLUT caching
 func hashPalette(colors []string) string {
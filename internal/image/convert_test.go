@@ -0,0 +1,27 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/Achno/gowall/config"
+	haldclut "github.com/Achno/gowall/internal/backends/colorthief/haldClut"
+)
+
+// tetrahedral interpolation is opt-in, the default is the nearest lattice point like haldclut.ApplyOptions
+func TestCLUTInterpolationDefaultsToNearest(t *testing.T) {
+	configured := config.GowallConfig.CLUTInterpolation
+	defer func() { config.GowallConfig.CLUTInterpolation = configured }()
+
+	config.GowallConfig.CLUTInterpolation = ""
+	if got := clutInterpolation(""); got != string(haldclut.InterpolationNearest) {
+		t.Errorf("default interpolation %q, want nearest", got)
+	}
+
+	config.GowallConfig.CLUTInterpolation = string(haldclut.InterpolationTetrahedral)
+	if got := clutInterpolation(""); got != string(haldclut.InterpolationTetrahedral) {
+		t.Errorf("interpolation %q with CLUTInterpolation set to tetrahedral", got)
+	}
+	if got := clutInterpolation(string(haldclut.InterpolationTrilinear)); got != string(haldclut.InterpolationTrilinear) {
+		t.Errorf("interpolation %q, want the trilinear that was asked for", got)
+	}
+}
@@ -0,0 +1,223 @@
+package image
+
+import (
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/Achno/gowall/internal/colorspace"
+	"github.com/Achno/gowall/utils"
+)
+
+// Color distance metrics used by the nearest neighbour backend to pick the closest theme color
+const (
+	MetricWeightedRGB = "rgb"       // luma weighted euclidean distance in sRGB (default)
+	MetricCIE76       = "cie76"     // euclidean distance in CIELAB
+	MetricCIE94       = "cie94"     // CIE94 in CIELAB, graphic arts weights
+	MetricCIEDE2000   = "ciede2000" // CIEDE2000 in CIELAB, the most accurate and the slowest
+	MetricOKLab       = "oklab"     // euclidean distance in OKLab
+)
+
+// DistanceMetrics returns the names of all distance metrics
+func DistanceMetrics() []string {
+	return []string{MetricWeightedRGB, MetricCIE76, MetricCIE94, MetricCIEDE2000, MetricOKLab}
+}
+
+// ValidateDistanceMetric checks that the metric is one of DistanceMetrics, empty means the default
+func ValidateDistanceMetric(metric string) error {
+	if metric == "" {
+		return nil
+	}
+	for _, m := range DistanceMetrics() {
+		if strings.EqualFold(m, metric) {
+			return nil
+		}
+	}
+	return utils.InvalidParameter("unknown color distance metric: %s (available: %s)", metric, strings.Join(DistanceMetrics(), ", "))
+}
+
+// colorPoint is a color in the space of a metric: sRGB, CIELAB or OKLab
+type colorPoint [3]float64
+
+// paletteMatcher finds the closest palette color with a distance metric.
+// The palette is converted to the metric's color space once, so every pixel costs one conversion
+type paletteMatcher struct {
+	colors   []color.Color
+	points   []colorPoint
+	toPoint  func(r, g, b uint8) colorPoint
+	distance func(a, b colorPoint) float64
+	tree     *kdTree // only for euclidean metrics, the others scan the whole palette
+}
+
+func newPaletteMatcher(colors []color.Color, metric string) (*paletteMatcher, error) {
+	if err := ValidateDistanceMetric(metric); err != nil {
+		return nil, err
+	}
+
+	m := &paletteMatcher{colors: colors}
+	euclidean := true
+
+	switch strings.ToLower(metric) {
+	case MetricCIE76:
+		m.toPoint, m.distance = rgbToLab, squaredEuclidean
+	case MetricCIE94:
+		m.toPoint, m.distance, euclidean = rgbToLab, cie94, false
+	case MetricCIEDE2000:
+		m.toPoint, m.distance, euclidean = rgbToLab, ciede2000, false
+	case MetricOKLab:
+		m.toPoint, m.distance = rgbToOKLab, squaredEuclidean
+	default:
+		m.toPoint, m.distance = weightedRGBPoint, squaredEuclidean
+	}
+
+	m.points = make([]colorPoint, len(colors))
+	for i, c := range colors {
+		r, g, b, _ := c.RGBA()
+		m.points[i] = m.toPoint(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	}
+
+	// a tree only pays off once the palette is larger than a few colors
+	if euclidean && len(m.points) > 8 {
+		m.tree = newKDTree(m.points)
+	}
+	return m, nil
+}
+
+// nearest returns the index of the palette color closest to r,g,b
+func (m *paletteMatcher) nearest(r, g, b uint8) int {
+	p := m.toPoint(r, g, b)
+	if m.tree != nil {
+		return m.tree.nearest(p)
+	}
+
+	best, minDist := 0, math.MaxFloat64
+	for i, q := range m.points {
+		if d := m.distance(p, q); d < minDist {
+			best, minDist = i, d
+		}
+	}
+	return best
+}
+
+// weightedRGBPoint scales the channels by the square root of their luma weight, so the euclidean distance
+// between two points is the weighted distance. It approximates human perception by giving green more weight
+// than red, and red more than blue
+func weightedRGBPoint(r, g, b uint8) colorPoint {
+	return colorPoint{float64(r) * sqrtRedWeight, float64(g) * sqrtGreenWeight, float64(b) * sqrtBlueWeight}
+}
+
+var (
+	sqrtRedWeight   = math.Sqrt(0.299)
+	sqrtGreenWeight = math.Sqrt(0.587)
+	sqrtBlueWeight  = math.Sqrt(0.114)
+)
+
+// squaredEuclidean skips the square root, it doesn't change which color is closest
+func squaredEuclidean(a, b colorPoint) float64 {
+	d0, d1, d2 := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return d0*d0 + d1*d1 + d2*d2
+}
+
+func rgbToLab(r, g, b uint8) colorPoint {
+	return colorspace.Lab(r, g, b)
+}
+
+func rgbToOKLab(r, g, b uint8) colorPoint {
+	return colorspace.OKLab(r, g, b)
+}
+
+// cie94 returns the squared CIE94 difference with the graphic arts weights, a is the reference color
+func cie94(a, b colorPoint) float64 {
+	const kL, k1, k2 = 1.0, 0.045, 0.015
+
+	dL := a[0] - b[0]
+	c1 := math.Hypot(a[1], a[2])
+	c2 := math.Hypot(b[1], b[2])
+	dC := c1 - c2
+	da, db := a[1]-b[1], a[2]-b[2]
+	dH2 := math.Max(da*da+db*db-dC*dC, 0)
+
+	sC := 1 + k1*c1
+	sH := 1 + k2*c1
+
+	l, c := dL/kL, dC/sC
+	return l*l + c*c + dH2/(sH*sH)
+}
+
+// ciede2000 returns the squared CIEDE2000 difference of two CIELAB colors
+func ciede2000(lab1, lab2 colorPoint) float64 {
+	const deg = math.Pi / 180
+	const pow25to7 = 6103515625.0 // 25^7
+
+	l1, a1, b1 := lab1[0], lab1[1], lab1[2]
+	l2, a2, b2 := lab2[0], lab2[1], lab2[2]
+
+	c1 := math.Hypot(a1, b1)
+	c2 := math.Hypot(a2, b2)
+	cBar7 := math.Pow((c1+c2)/2, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25to7)))
+
+	a1p, a2p := (1+g)*a1, (1+g)*a2
+	c1p, c2p := math.Hypot(a1p, b1), math.Hypot(a2p, b2)
+
+	h1p := hueAngle(b1, a1p)
+	h2p := hueAngle(b2, a2p)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+
+	var dhp float64
+	switch {
+	case c1p*c2p == 0:
+		dhp = 0
+	case math.Abs(h2p-h1p) <= 180:
+		dhp = h2p - h1p
+	case h2p-h1p > 180:
+		dhp = h2p - h1p - 360
+	default:
+		dhp = h2p - h1p + 360
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(dhp*deg/2)
+
+	lBarP := (l1 + l2) / 2
+	cBarP := (c1p + c2p) / 2
+
+	var hBarP float64
+	switch {
+	case c1p*c2p == 0:
+		hBarP = h1p + h2p
+	case math.Abs(h1p-h2p) <= 180:
+		hBarP = (h1p + h2p) / 2
+	case h1p+h2p < 360:
+		hBarP = (h1p + h2p + 360) / 2
+	default:
+		hBarP = (h1p + h2p - 360) / 2
+	}
+
+	t := 1 - 0.17*math.Cos((hBarP-30)*deg) + 0.24*math.Cos(2*hBarP*deg) +
+		0.32*math.Cos((3*hBarP+6)*deg) - 0.20*math.Cos((4*hBarP-63)*deg)
+
+	dTheta := 30 * math.Exp(-math.Pow((hBarP-275)/25, 2))
+	cBarP7 := math.Pow(cBarP, 7)
+	rC := 2 * math.Sqrt(cBarP7/(cBarP7+pow25to7))
+	lBarP50 := (lBarP - 50) * (lBarP - 50)
+	sL := 1 + 0.015*lBarP50/math.Sqrt(20+lBarP50)
+	sC := 1 + 0.045*cBarP
+	sH := 1 + 0.015*cBarP*t
+	rT := -math.Sin(2*dTheta*deg) * rC
+
+	l, c, h := dLp/sL, dCp/sC, dHp/sH
+	return l*l + c*c + h*h + rT*c*h
+}
+
+// hueAngle returns the hue of a CIELAB color in degrees [0,360)
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
@@ -0,0 +1,368 @@
+package image
+
+import (
+	"context"
+	"image"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/Achno/gowall/internal/colorspace"
+	"github.com/Achno/gowall/utils"
+)
+
+// Dithering modes of the nearest neighbour backend, they trade the hard bands of a small palette for a grain
+const (
+	DitherNone           = "none"
+	DitherFloydSteinberg = "floyd-steinberg"
+	DitherAtkinson       = "atkinson" // diffuses only 3/4 of the error, keeps more contrast
+	DitherSierra         = "sierra"
+	DitherBayer2         = "bayer2"
+	DitherBayer4         = "bayer4"
+	DitherBayer8         = "bayer8"
+	DitherBlueNoise      = "blue-noise"
+)
+
+// DitherModes returns the names of all dithering modes
+func DitherModes() []string {
+	return []string{DitherNone, DitherFloydSteinberg, DitherAtkinson, DitherSierra, DitherBayer2, DitherBayer4, DitherBayer8, DitherBlueNoise}
+}
+
+// ValidateDither checks the dithering mode and its strength, empty means no dithering and 0 the full strength
+func ValidateDither(mode string, strength float64) error {
+	if strength < 0 || strength > 1 {
+		return utils.InvalidParameter("dither strength must be between 0 and 1, got %v", strength)
+	}
+	if mode == "" {
+		return nil
+	}
+	for _, m := range DitherModes() {
+		if strings.EqualFold(m, mode) {
+			return nil
+		}
+	}
+	return utils.InvalidParameter("unknown dither mode: %s (available: %s)", mode, strings.Join(DitherModes(), ", "))
+}
+
+// isDithering reports whether the mode dithers at all
+func isDithering(mode string) bool {
+	return mode != "" && !strings.EqualFold(mode, DitherNone)
+}
+
+// diffusionWeight spreads a part of the quantization error to the pixel at dx,dy
+type diffusionWeight struct {
+	dx, dy int
+	weight float64
+}
+
+var diffusionKernels = map[string][]diffusionWeight{
+	DitherFloydSteinberg: {
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	DitherAtkinson: {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8}, {0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8},
+	},
+	DitherSierra: {
+		{1, 0, 5.0 / 32}, {2, 0, 3.0 / 32},
+		{-2, 1, 2.0 / 32}, {-1, 1, 4.0 / 32}, {0, 1, 5.0 / 32}, {1, 1, 4.0 / 32}, {2, 1, 2.0 / 32},
+		{-1, 2, 2.0 / 32}, {0, 2, 3.0 / 32}, {1, 2, 2.0 / 32},
+	},
+}
+
+// ditherImage quantizes the image to the palette of the matcher with the dithering mode, keeping the alpha.
+// It stops at the next row once ctx is cancelled
+func ditherImage(ctx context.Context, img image.Image, matcher *paletteMatcher, mode string, strength float64) (*image.NRGBA, error) {
+	if strength == 0 {
+		strength = 1
+	}
+
+	mode = strings.ToLower(mode)
+	if kernel, ok := diffusionKernels[mode]; ok {
+		return diffuseError(ctx, img, matcher, kernel, strength)
+	}
+	return orderedDither(ctx, img, matcher, thresholdMap(mode), strength)
+}
+
+// diffuseError quantizes the pixels one by one and spreads the error to the pixels not yet quantized.
+// The error is measured in linear light, so a dithered area has the same brightness as the original.
+// Rows are scanned in alternating directions, which avoids the diagonal artifacts of a raster scan.
+// Fully transparent pixels are skipped, their error is dropped
+func diffuseError(ctx context.Context, img image.Image, matcher *paletteMatcher, kernel []diffusionWeight, strength float64) (*image.NRGBA, error) {
+	src := newPixelReader(img)
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	newImg := image.NewNRGBA(bounds)
+	palette := paletteRGBA(matcher)
+	cache := newNearestCache(matcher)
+
+	paletteLinear := make([][3]float64, len(palette))
+	for i, c := range palette {
+		paletteLinear[i] = [3]float64{colorspace.SRGBToLinear[c[0]], colorspace.SRGBToLinear[c[1]], colorspace.SRGBToLinear[c[2]]}
+	}
+
+	// errors of the current row and the next two, the furthest any kernel reaches
+	rows := [3][]float64{}
+	for i := range rows {
+		rows[i] = make([]float64, width*3)
+	}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		reverse := y%2 == 1
+		row := newImg.Pix[y*newImg.Stride:]
+
+		for i := 0; i < width; i++ {
+			x := i
+			if reverse {
+				x = width - 1 - i
+			}
+
+			r, g, b, a := src.nrgba(bounds.Min.X+x, bounds.Min.Y+y)
+			if a == 0 {
+				continue
+			}
+			errs := rows[0][x*3 : x*3+3]
+			want := [3]float64{
+				colorspace.Clamp01(colorspace.SRGBToLinear[r] + errs[0]),
+				colorspace.Clamp01(colorspace.SRGBToLinear[g] + errs[1]),
+				colorspace.Clamp01(colorspace.SRGBToLinear[b] + errs[2]),
+			}
+
+			nearest := cache.nearest(colorspace.LinearToSRGB(want[0]), colorspace.LinearToSRGB(want[1]), colorspace.LinearToSRGB(want[2]))
+			setNRGBA(row[x*4:], palette[nearest], a)
+
+			for c := 0; c < 3; c++ {
+				quantErr := (want[c] - paletteLinear[nearest][c]) * strength
+				for _, k := range kernel {
+					dx := k.dx
+					if reverse {
+						dx = -dx
+					}
+					nx := x + dx
+					if nx < 0 || nx >= width {
+						continue
+					}
+					rows[k.dy][nx*3+c] += quantErr * k.weight
+				}
+			}
+		}
+
+		// shift the rows up, the row that was just finished is reused as the last one
+		done := rows[0]
+		rows[0], rows[1] = rows[1], rows[2]
+		clear(done)
+		rows[2] = done
+	}
+
+	return newImg, nil
+}
+
+// orderedDither offsets every pixel by a threshold that repeats across the image before quantizing it.
+// Unlike error diffusion every pixel is independent, so the pattern is stable between frames and images
+func orderedDither(ctx context.Context, img image.Image, matcher *paletteMatcher, thresholds [][]float64, strength float64) (*image.NRGBA, error) {
+	src := newPixelReader(img)
+	bounds := img.Bounds()
+	newImg := image.NewNRGBA(bounds)
+	palette := paletteRGBA(matcher)
+	size := len(thresholds)
+
+	// the fewer colors the further apart they are, so the offsets have to be larger to reach the next color
+	spread := 255 / math.Cbrt(float64(len(palette))) * strength
+
+	parallelRows(bounds, func(minY, maxY int) {
+		cache := newNearestCache(matcher)
+		for y := minY; y < maxY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+			thresholdRow := thresholds[(y-bounds.Min.Y)%size]
+			row := newImg.Pix[(y-bounds.Min.Y)*newImg.Stride:]
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				offset := thresholdRow[(x-bounds.Min.X)%size] * spread
+
+				r, g, b, a := src.nrgba(x, y)
+				nearest := cache.nearest(
+					clamp8(float64(r)+offset),
+					clamp8(float64(g)+offset),
+					clamp8(float64(b)+offset),
+				)
+				setNRGBA(row[(x-bounds.Min.X)*4:], palette[nearest], a)
+			}
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return newImg, nil
+}
+
+// thresholdMap returns the thresholds of an ordered dithering mode, centered around 0 in [-0.5,0.5)
+func thresholdMap(mode string) [][]float64 {
+	switch mode {
+	case DitherBayer2:
+		return bayerMatrix(2)
+	case DitherBayer4:
+		return bayerMatrix(4)
+	case DitherBlueNoise:
+		return blueNoiseMatrix()
+	default:
+		return bayerMatrix(8)
+	}
+}
+
+// bayerMatrix builds the recursive Bayer index matrix of the given power of two size
+func bayerMatrix(size int) [][]float64 {
+	indices := [][]int{{0}}
+	for n := 1; n < size; n *= 2 {
+		next := make([][]int, n*2)
+		for y := range next {
+			next[y] = make([]int, n*2)
+		}
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				v := indices[y][x] * 4
+				next[y][x] = v
+				next[y][x+n] = v + 2
+				next[y+n][x] = v + 3
+				next[y+n][x+n] = v + 1
+			}
+		}
+		indices = next
+	}
+	return rankThresholds(indices)
+}
+
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce      sync.Once
+	blueNoiseThreshold [][]float64
+)
+
+// blueNoiseMatrix returns a tileable blue noise threshold map, generated on first use
+func blueNoiseMatrix() [][]float64 {
+	blueNoiseOnce.Do(func() {
+		blueNoiseThreshold = rankThresholds(voidAndCluster(blueNoiseSize, 1.5))
+	})
+	return blueNoiseThreshold
+}
+
+// voidAndCluster ranks the cells of a size*size tile with Ulichney's void-and-cluster method,
+// so that the cells of every rank and below are spread as evenly as possible.
+// The tile wraps around, so it can be repeated across the image without seams
+func voidAndCluster(size int, sigma float64) [][]int {
+	n := size * size
+
+	// gaussian energy one set cell adds to the others, by wrapped distance
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			wx := math.Min(float64(dx), float64(size-dx))
+			wy := math.Min(float64(dy), float64(size-dy))
+			kernel[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * sigma * sigma))
+		}
+	}
+
+	set := make([]bool, n)
+	energy := make([]float64, n)
+	toggle := func(cell int, on bool) {
+		set[cell] = on
+		sign := 1.0
+		if !on {
+			sign = -1
+		}
+		cx, cy := cell%size, cell/size
+		for y := 0; y < size; y++ {
+			dy := (y - cy + size) % size
+			for x := 0; x < size; x++ {
+				dx := (x - cx + size) % size
+				energy[y*size+x] += sign * kernel[dy*size+dx]
+			}
+		}
+	}
+	// tightestCluster is the set cell with the most set neighbours, largestVoid the empty cell with the fewest
+	tightestCluster := func() int {
+		best := -1
+		for i := range energy {
+			if set[i] && (best < 0 || energy[i] > energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for i := range energy {
+			if !set[i] && (best < 0 || energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// start from a random pattern with a tenth of the cells set, seeded so every run gives the same tile
+	rng := rand.New(rand.NewSource(1))
+	initial := n / 10
+	for _, cell := range rng.Perm(n)[:initial] {
+		toggle(cell, true)
+	}
+
+	// move cells from the tightest clusters to the largest voids until the pattern is even
+	for {
+		cluster := tightestCluster()
+		toggle(cluster, false)
+		void := largestVoid()
+		if void == cluster {
+			toggle(cluster, true)
+			break
+		}
+		toggle(void, true)
+	}
+
+	ranks := make([]int, n)
+	prototype := append([]bool(nil), set...)
+	prototypeEnergy := append([]float64(nil), energy...)
+
+	// the initial cells get the lowest ranks, removed from the tightest cluster first
+	for rank := initial - 1; rank >= 0; rank-- {
+		cell := tightestCluster()
+		toggle(cell, false)
+		ranks[cell] = rank
+	}
+
+	// the other cells are ranked by filling the largest void
+	copy(set, prototype)
+	copy(energy, prototypeEnergy)
+	for rank := initial; rank < n; rank++ {
+		cell := largestVoid()
+		toggle(cell, true)
+		ranks[cell] = rank
+	}
+
+	matrix := make([][]int, size)
+	for y := range matrix {
+		matrix[y] = ranks[y*size : (y+1)*size]
+	}
+	return matrix
+}
+
+// rankThresholds turns a matrix of ranks 0..n-1 into thresholds in [-0.5,0.5)
+func rankThresholds(ranks [][]int) [][]float64 {
+	n := float64(len(ranks) * len(ranks))
+	thresholds := make([][]float64, len(ranks))
+	for y, row := range ranks {
+		thresholds[y] = make([]float64, len(row))
+		for x, rank := range row {
+			thresholds[y][x] = (float64(rank)+0.5)/n - 0.5
+		}
+	}
+	return thresholds
+}
+
+func clamp8(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"image"
 	"image/color"
 	"image/draw"
@@ -11,7 +12,7 @@ type DrawProcessor struct {
 	BorderThickness int
 }
 
-func (b *DrawProcessor) Process(img image.Image, theme string) (image.Image, error) {
+func (b *DrawProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	newImg := drawBorder(img, b.BorderThickness, b.Color)
 
@@ -25,7 +26,7 @@ func drawBorder(img image.Image, borderThickness int, borderColor color.Color) i
 	height := bounds.Dy()
 
 	// draw on new image
-	newImg := image.NewRGBA(bounds)
+	newImg := image.NewNRGBA(bounds)
 	draw.Draw(newImg, bounds, img, image.Point{0, 0}, draw.Src)
 
 	// top and bottom borders
@@ -1,18 +1,21 @@
 package image
 
 import (
-	"fmt"
+	"context"
 	"image"
 	"image/color"
+	"image/draw"
+
+	"github.com/Achno/gowall/utils"
 )
 
 type FlipProcessor struct{}
 
-func (p *FlipProcessor) Process(img image.Image, theme string) (image.Image, error) {
+func (p *FlipProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	bounds := img.Bounds()
 	width, height := bounds.Dx(), bounds.Dy()
-	newImg := image.NewRGBA(bounds)
+	newImg := image.NewNRGBA(bounds)
 
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
@@ -25,11 +28,11 @@ func (p *FlipProcessor) Process(img image.Image, theme string) (image.Image, err
 
 type MirrorProcessor struct{}
 
-func (p *MirrorProcessor) Process(img image.Image, theme string) (image.Image, error) {
+func (p *MirrorProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	bounds := img.Bounds()
 	width, height := bounds.Dx(), bounds.Dy()
-	newImg := image.NewRGBA(bounds)
+	newImg := image.NewNRGBA(bounds)
 
 	// Copy the original left half
 	for y := 0; y < height; y++ {
@@ -51,48 +54,76 @@ func (p *MirrorProcessor) Process(img image.Image, theme string) (image.Image, e
 
 type GrayScaleProcessor struct{}
 
-func (p *GrayScaleProcessor) Process(img image.Image, theme string) (image.Image, error) {
+// Process returns a grayscale image, or a grayscale NRGBA image when the image has transparency
+func (p *GrayScaleProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	bounds := img.Bounds()
-	grayImg := image.NewGray(bounds)
+	opaque := isOpaque(img)
+
+	var grayImg draw.Image
+	if opaque {
+		grayImg = image.NewGray(bounds)
+	} else {
+		grayImg = image.NewNRGBA(bounds)
+	}
 
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := img.At(x, y)
-			r, g, b, _ := originalColor.RGBA()
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
 
 			// luminosity formula
-			grayValue := uint8((0.299*float64(r>>8) + 0.587*float64(g>>8) + 0.114*float64(b>>8)))
+			grayValue := uint8((0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)))
 
-			grayImg.SetGray(x, y, color.Gray{Y: grayValue})
+			if opaque {
+				grayImg.Set(x, y, color.Gray{Y: grayValue})
+			} else {
+				grayImg.Set(x, y, color.NRGBA{R: grayValue, G: grayValue, B: grayValue, A: c.A})
+			}
 		}
 	}
 	return grayImg, nil
 }
 
+// isOpaque reports whether every pixel of the image is fully opaque, images that can't tell are assumed not to be
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
 type BrightnessProcessor struct {
 	Factor float64
 }
 
-func (p *BrightnessProcessor) Process(img image.Image, theme string) (image.Image, error) {
-
+// Validate checks that the factor is in (0.0,10.0]
+func (p *BrightnessProcessor) Validate(theme string) error {
 	if p.Factor <= 0.0 || p.Factor > 10 {
-		return nil, fmt.Errorf("enter a valid factor : from (0.0,10.0] ")
+		return utils.InvalidParameter("enter a valid factor : from (0.0,10.0] ")
+	}
+	return nil
+}
+
+func (p *BrightnessProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
+
+	if err := p.Validate(theme); err != nil {
+		return nil, err
 	}
 
 	bounds := img.Bounds()
-	newImg := image.NewRGBA(bounds)
+	newImg := image.NewNRGBA(bounds)
 
+	// scale the straight color, scaling a premultiplied one would push it above its alpha
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			origColor := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			origColor := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
 
 			newR := uint8(clamp(int(float64(origColor.R)*p.Factor), 0, 255))
 			newG := uint8(clamp(int(float64(origColor.G)*p.Factor), 0, 255))
 			newB := uint8(clamp(int(float64(origColor.B)*p.Factor), 0, 255))
 			newA := origColor.A
 
-			newImg.Set(x, y, color.RGBA{R: newR, G: newG, B: newB, A: newA})
+			newImg.SetNRGBA(x, y, color.NRGBA{R: newR, G: newG, B: newB, A: newA})
 		}
 	}
 
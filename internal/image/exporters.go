@@ -0,0 +1,424 @@
+package image
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Achno/gowall/internal/colorspace"
+	"github.com/Achno/gowall/utils"
+)
+
+// ThemeExporter writes a theme in the config format of another program
+type ThemeExporter struct {
+	Extension string // of the files of the format, e.g. ".conf"
+	// Export writes the theme. roles has every role set, the theme's own or filled from its colors by CompleteRoles
+	Export func(w io.Writer, theme Theme, roles ThemeRoles) error
+}
+
+var (
+	themeExportersMu sync.RWMutex
+	themeExporters   = map[string]ThemeExporter{
+		"json":       textExporter(".json", exportGowallJSON),
+		"yaml":       textExporter(".yaml", exportGowallYAML),
+		"emacs":      textExporter("-theme.el", exportEmacs),
+		"kitty":      textExporter(".conf", exportKitty),
+		"alacritty":  textExporter(".toml", exportAlacritty),
+		"wezterm":    textExporter(".toml", exportWezTerm),
+		"foot":       textExporter(".ini", exportFoot),
+		"xresources": textExporter(".Xresources", exportXresources),
+		"base16":     textExporter(".yaml", exportBase16),
+		"gimp":       textExporter(".gpl", exportGIMP),
+		"css":        textExporter(".css", exportCSS),
+		"hyprland":   textExporter(".conf", exportHyprland),
+		"rofi":       textExporter(".rasi", exportRofi),
+	}
+)
+
+// RegisterThemeExporter adds an exporter for theme export, replacing the exporter of the same name
+func RegisterThemeExporter(name string, exporter ThemeExporter) {
+	themeExportersMu.Lock()
+	defer themeExportersMu.Unlock()
+	themeExporters[strings.ToLower(name)] = exporter
+}
+
+// ExportFormats returns the names of the registered exporters, sorted
+func ExportFormats() []string {
+	themeExportersMu.RLock()
+	defer themeExportersMu.RUnlock()
+	formats := make([]string, 0, len(themeExporters))
+	for name := range themeExporters {
+		formats = append(formats, name)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// LookupThemeExporter returns the exporter registered under the name
+func LookupThemeExporter(format string) (ThemeExporter, error) {
+	themeExportersMu.RLock()
+	exporter, ok := themeExporters[strings.ToLower(format)]
+	themeExportersMu.RUnlock()
+	if !ok {
+		return ThemeExporter{}, utils.InvalidParameter("unknown export format: %s (available: %s)", format, strings.Join(ExportFormats(), ", "))
+	}
+	return exporter, nil
+}
+
+// ExportTheme writes the theme in the format of a registered exporter
+func ExportTheme(w io.Writer, theme Theme, format string) error {
+	exporter, err := LookupThemeExporter(format)
+	if err != nil {
+		return err
+	}
+	if len(theme.Colors) == 0 {
+		return utils.InvalidParameter("theme %q has no colors", theme.Name)
+	}
+	if err := exporter.Export(w, theme, CompleteRoles(theme)); err != nil {
+		return fmt.Errorf("while exporting theme %s to %s: %w", theme.Name, format, err)
+	}
+	return nil
+}
+
+// textExporter adapts a function that builds the file in memory to a ThemeExporter
+func textExporter(extension string, build func(b *strings.Builder, theme Theme, roles ThemeRoles) error) ThemeExporter {
+	return ThemeExporter{
+		Extension: extension,
+		Export: func(w io.Writer, theme Theme, roles ThemeRoles) error {
+			var b strings.Builder
+			if err := build(&b, theme, roles); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, b.String())
+			return err
+		},
+	}
+}
+
+// ansiHues are the OKLab hues of pure red, green, yellow, blue, magenta and cyan, for color1-color6
+var ansiHues = func() [6]float64 {
+	var hues [6]float64
+	for i, c := range []color.RGBA{{R: 255}, {G: 255}, {R: 255, G: 255}, {B: 255}, {R: 255, B: 255}, {G: 255, B: 255}} {
+		lab := colorspace.OKLab(c.R, c.G, c.B)
+		hues[i] = hueAngle(lab[2], lab[1])
+	}
+	return hues
+}()
+
+// CompleteRoles returns the roles of the theme with the missing ones filled from its colors, sorted by lightness:
+// the darkest is the background and black, the lightest the foreground and bright white. The selection, bright black
+// and white are the darkest and lightest gray of the theme, or blends of the background and foreground when it has none.
+// Each of color1-color6 is the theme color closest in hue, their bright versions repeat them
+func CompleteRoles(theme Theme) ThemeRoles {
+	roles := theme.Roles
+	colors := dedupeColors(theme.Colors)
+	if len(colors) == 0 {
+		return roles
+	}
+
+	type entry struct {
+		rgba color.RGBA
+		lab  [3]float64
+	}
+	entries := make([]entry, len(colors))
+	for i, c := range colors {
+		rgba := c.(color.RGBA)
+		entries[i] = entry{rgba, colorspace.OKLab(rgba.R, rgba.G, rgba.B)}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].lab[0] < entries[j].lab[0] })
+
+	fill := func(slot *color.Color, c color.Color) {
+		if *slot == nil {
+			*slot = c
+		}
+	}
+	fill(&roles.Background, entries[0].rgba)
+	fill(&roles.Foreground, entries[len(entries)-1].rgba)
+
+	var accents []entry
+	var grays []color.Color
+	for i, e := range entries {
+		if math.Hypot(e.lab[1], e.lab[2]) >= neutralChroma {
+			accents = append(accents, e)
+		} else if i > 0 && i < len(entries)-1 {
+			grays = append(grays, e.rgba)
+		}
+	}
+	darkGray := mixColors(roles.Background, roles.Foreground, 0.25)
+	lightGray := mixColors(roles.Background, roles.Foreground, 0.75)
+	if len(grays) > 0 {
+		darkGray, lightGray = grays[0], grays[len(grays)-1]
+	}
+
+	fill(&roles.Cursor, roles.Foreground)
+	fill(&roles.Selection, darkGray)
+	fill(&roles.ANSI[0], roles.Background)
+	fill(&roles.ANSI[7], lightGray)
+	fill(&roles.ANSI[8], darkGray)
+	fill(&roles.ANSI[15], roles.Foreground)
+
+	for i, hue := range ansiHues {
+		if len(accents) == 0 {
+			fill(&roles.ANSI[i+1], roles.Foreground)
+			continue
+		}
+		closest, minDiff := accents[0].rgba, math.MaxFloat64
+		for _, accent := range accents {
+			diff := math.Abs(hueAngle(accent.lab[2], accent.lab[1]) - hue)
+			diff = math.Min(diff, 360-diff)
+			if diff < minDiff {
+				closest, minDiff = accent.rgba, diff
+			}
+		}
+		fill(&roles.ANSI[i+1], closest)
+	}
+	for i := 1; i <= 6; i++ {
+		fill(&roles.ANSI[i+8], roles.ANSI[i])
+	}
+	return roles
+}
+
+// hexOf returns the color as #RRGGBB
+func hexOf(c color.Color) string {
+	return RGBtoHex(color.RGBAModel.Convert(c).(color.RGBA))
+}
+
+// bareHexOf returns the color as RRGGBB, for formats without the #
+func bareHexOf(c color.Color) string {
+	return strings.TrimPrefix(hexOf(c), "#")
+}
+
+// mixColors blends a into b in OKLab, t = 0 is a and t = 1 is b
+func mixColors(a, b color.Color, t float64) color.Color {
+	ca, cb := color.RGBAModel.Convert(a).(color.RGBA), color.RGBAModel.Convert(b).(color.RGBA)
+	la, lb := colorspace.OKLab(ca.R, ca.G, ca.B), colorspace.OKLab(cb.R, cb.G, cb.B)
+	var mixed [3]float64
+	for i := range mixed {
+		mixed[i] = la[i] + (lb[i]-la[i])*t
+	}
+	r, g, bl := colorspace.OKLabToSRGB(mixed)
+	return color.RGBA{R: r, G: g, B: bl, A: 255}
+}
+
+// isLightTheme reports whether the background is lighter than the foreground
+func isLightTheme(roles ThemeRoles) bool {
+	bg, fg := color.RGBAModel.Convert(roles.Background).(color.RGBA), color.RGBAModel.Convert(roles.Foreground).(color.RGBA)
+	return colorspace.OKLab(bg.R, bg.G, bg.B)[0] > colorspace.OKLab(fg.R, fg.G, fg.B)[0]
+}
+
+func exportGowallJSON(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	hexColors, err := themeColorsToHex(theme.Colors)
+	if err != nil {
+		return err
+	}
+	data, err := generateJSONTheme(theme.Name, hexColors, theme.Roles)
+	if err != nil {
+		return err
+	}
+	b.Write(data)
+	b.WriteString("\n")
+	return nil
+}
+
+func exportGowallYAML(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	hexColors, err := themeColorsToHex(theme.Colors)
+	if err != nil {
+		return err
+	}
+	data, err := generateYAMLTheme(theme.Name, hexColors, theme.Roles)
+	if err != nil {
+		return err
+	}
+	b.Write(data)
+	return nil
+}
+
+// exportEmacs writes the Emacs theme of SaveThemeToFile, the name must be an Emacs symbol
+func exportEmacs(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	if err := ValidateThemeName(theme.Name); err != nil {
+		return err
+	}
+	hexColors, err := themeColorsToHex(theme.Colors)
+	if err != nil {
+		return err
+	}
+	b.Write(generateEmacsTheme(theme.Name, hexColors, theme.Roles))
+	return nil
+}
+
+func exportKitty(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	fmt.Fprintf(b, "## name: %s\n## Generated by gowall\n\n", theme.Name)
+	fmt.Fprintf(b, "foreground %s\nbackground %s\n", hexOf(roles.Foreground), hexOf(roles.Background))
+	fmt.Fprintf(b, "selection_foreground %s\nselection_background %s\n", hexOf(roles.Foreground), hexOf(roles.Selection))
+	fmt.Fprintf(b, "cursor %s\ncursor_text_color %s\n\n", hexOf(roles.Cursor), hexOf(roles.Background))
+	for i, c := range roles.ANSI {
+		fmt.Fprintf(b, "color%d %s\n", i, hexOf(c))
+	}
+	return nil
+}
+
+func exportAlacritty(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	fmt.Fprintf(b, "# %s, generated by gowall\n\n", theme.Name)
+	fmt.Fprintf(b, "[colors.primary]\nbackground = %q\nforeground = %q\n\n", hexOf(roles.Background), hexOf(roles.Foreground))
+	fmt.Fprintf(b, "[colors.cursor]\ntext = %q\ncursor = %q\n\n", hexOf(roles.Background), hexOf(roles.Cursor))
+	fmt.Fprintf(b, "[colors.selection]\ntext = %q\nbackground = %q\n", hexOf(roles.Foreground), hexOf(roles.Selection))
+	for half, table := range []string{"normal", "bright"} {
+		fmt.Fprintf(b, "\n[colors.%s]\n", table)
+		for i, name := range ansiColorNames {
+			fmt.Fprintf(b, "%s = %q\n", name, hexOf(roles.ANSI[half*8+i]))
+		}
+	}
+	return nil
+}
+
+func exportWezTerm(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	quoted := func(colors []color.Color) string {
+		values := make([]string, len(colors))
+		for i, c := range colors {
+			values[i] = fmt.Sprintf("%q", hexOf(c))
+		}
+		return strings.Join(values, ", ")
+	}
+
+	fmt.Fprintf(b, "# %s, generated by gowall\n\n[colors]\n", theme.Name)
+	fmt.Fprintf(b, "background = %q\nforeground = %q\n", hexOf(roles.Background), hexOf(roles.Foreground))
+	fmt.Fprintf(b, "cursor_bg = %q\ncursor_border = %q\ncursor_fg = %q\n", hexOf(roles.Cursor), hexOf(roles.Cursor), hexOf(roles.Background))
+	fmt.Fprintf(b, "selection_bg = %q\nselection_fg = %q\n", hexOf(roles.Selection), hexOf(roles.Foreground))
+	fmt.Fprintf(b, "ansi = [%s]\nbrights = [%s]\n", quoted(roles.ANSI[:8]), quoted(roles.ANSI[8:]))
+	fmt.Fprintf(b, "\n[metadata]\nname = %q\n", theme.Name)
+	return nil
+}
+
+func exportFoot(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	fmt.Fprintf(b, "# %s, generated by gowall\n\n", theme.Name)
+	fmt.Fprintf(b, "[cursor]\ncolor=%s %s\n\n", bareHexOf(roles.Background), bareHexOf(roles.Cursor))
+	fmt.Fprintf(b, "[colors]\nforeground=%s\nbackground=%s\n", bareHexOf(roles.Foreground), bareHexOf(roles.Background))
+	fmt.Fprintf(b, "selection-foreground=%s\nselection-background=%s\n", bareHexOf(roles.Foreground), bareHexOf(roles.Selection))
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(b, "regular%d=%s\n", i, bareHexOf(roles.ANSI[i]))
+	}
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(b, "bright%d=%s\n", i, bareHexOf(roles.ANSI[i+8]))
+	}
+	return nil
+}
+
+func exportXresources(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	fmt.Fprintf(b, "! %s, generated by gowall\n\n", theme.Name)
+	fmt.Fprintf(b, "*.background: %s\n*.foreground: %s\n", hexOf(roles.Background), hexOf(roles.Foreground))
+	fmt.Fprintf(b, "*.cursorColor: %s\n*.highlightColor: %s\n\n", hexOf(roles.Cursor), hexOf(roles.Selection))
+	for i, c := range roles.ANSI {
+		fmt.Fprintf(b, "*.color%d: %s\n", i, hexOf(c))
+	}
+	return nil
+}
+
+// exportBase16 writes a base16 scheme. base16 has a ramp of 8 shades from the background to the brightest color,
+// the shades between the roles are blended
+func exportBase16(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	variant := "dark"
+	if isLightTheme(roles) {
+		variant = "light"
+	}
+	palette := []color.Color{
+		roles.Background,
+		mixColors(roles.Background, roles.Selection, 0.5),
+		roles.Selection,
+		roles.ANSI[8],
+		mixColors(roles.ANSI[8], roles.Foreground, 0.5),
+		roles.Foreground,
+		mixColors(roles.Foreground, roles.ANSI[15], 0.5),
+		roles.ANSI[15],
+		roles.ANSI[1],
+		mixColors(roles.ANSI[1], roles.ANSI[3], 0.5), // orange
+		roles.ANSI[3],
+		roles.ANSI[2],
+		roles.ANSI[6],
+		roles.ANSI[4],
+		roles.ANSI[5],
+		mixColors(roles.ANSI[1], roles.Background, 0.35), // brown
+	}
+
+	fmt.Fprintf(b, "system: \"base16\"\nname: %q\nauthor: \"gowall\"\nvariant: %q\npalette:\n", theme.Name, variant)
+	for i, c := range palette {
+		fmt.Fprintf(b, "  base%02X: %q\n", i, hexOf(c))
+	}
+	return nil
+}
+
+// exportGIMP writes a GIMP palette of every theme color, named by its role when it has one
+func exportGIMP(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	names := make(map[color.RGBA]string)
+	roleNames := RoleNames()
+	for i, slot := range theme.Roles.slots() {
+		if *slot == nil {
+			continue
+		}
+		rgba := color.RGBAModel.Convert(*slot).(color.RGBA)
+		if _, ok := names[rgba]; !ok {
+			names[rgba] = roleNames[i]
+		}
+	}
+
+	fmt.Fprintf(b, "GIMP Palette\nName: %s\nColumns: 8\n# Generated by gowall\n", theme.Name)
+	for _, c := range dedupeColors(theme.Colors) {
+		rgba := c.(color.RGBA)
+		name, ok := names[rgba]
+		if !ok {
+			name = RGBtoHex(rgba)
+		}
+		fmt.Fprintf(b, "%3d %3d %3d\t%s\n", rgba.R, rgba.G, rgba.B, name)
+	}
+	return nil
+}
+
+// exportCSS writes the roles and the theme colors as CSS custom properties
+func exportCSS(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	fmt.Fprintf(b, "/* %s, generated by gowall */\n:root {\n", theme.Name)
+	for i, name := range RoleNames() {
+		fmt.Fprintf(b, "  --%s: %s;\n", name, hexOf(*roles.slots()[i]))
+	}
+	for i, c := range dedupeColors(theme.Colors) {
+		fmt.Fprintf(b, "  --palette-%d: %s;\n", i, hexOf(c))
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+// exportHyprland writes the roles as Hyprland variables, to source from hyprland.conf
+func exportHyprland(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	fmt.Fprintf(b, "# %s, generated by gowall\n# source = path/to/this/file in hyprland.conf, then use e.g. $color4\n\n", theme.Name)
+	for i, name := range RoleNames() {
+		fmt.Fprintf(b, "$%s = rgb(%s)\n", name, bareHexOf(*roles.slots()[i]))
+	}
+	return nil
+}
+
+// exportRofi writes a rofi theme with the usual variables of rofi themes, and the roles
+func exportRofi(b *strings.Builder, theme Theme, roles ThemeRoles) error {
+	fmt.Fprintf(b, "/* %s, generated by gowall */\n* {\n", theme.Name)
+	variables := []struct {
+		name string
+		c    color.Color
+	}{
+		{"background", roles.Background},
+		{"background-alt", roles.Selection},
+		{"foreground", roles.Foreground},
+		{"selected", roles.ANSI[4]},
+		{"active", roles.ANSI[2]},
+		{"urgent", roles.ANSI[1]},
+		{"cursor", roles.Cursor},
+	}
+	for _, v := range variables {
+		fmt.Fprintf(b, "    %s: %s;\n", v.name, hexOf(v.c))
+	}
+	for i, c := range roles.ANSI {
+		fmt.Fprintf(b, "    color%d: %s;\n", i, hexOf(c))
+	}
+	b.WriteString("}\n")
+	return nil
+}
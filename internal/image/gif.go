@@ -1,19 +1,29 @@
 package image
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"image"
 	"image/color/palette"
 	"image/draw"
 	"image/gif"
 	"math"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/Achno/gowall/utils"
 )
 
 type GifOptions struct {
 	Loop       int    // 0 loops forever, -1 shows the frames only once, anything else loop+1
 	Delay      int    // Delay in 100ths of a second between frames
 	outputName string // outputName of the gif
+
+	nameTemplate string // optional template for the name, see ProcessOptions.NameTemplate
+	collision    string // what to do when the gif already exists, see ProcessOptions.Collision
+	dryRun       bool   // only resolve the output path, see ProcessOptions.DryRun
 }
 
 type GifOption func(*GifOptions)
@@ -30,6 +40,18 @@ func WithOutputName(name string) GifOption {
 	return func(g *GifOptions) { g.outputName = name }
 }
 
+func WithNameTemplate(tmpl string) GifOption {
+	return func(g *GifOptions) { g.nameTemplate = tmpl }
+}
+
+func WithCollision(policy string) GifOption {
+	return func(g *GifOptions) { g.collision = policy }
+}
+
+func WithDryRun(dryRun bool) GifOption {
+	return func(g *GifOptions) { g.dryRun = dryRun }
+}
+
 func defaultGifOptions(options []GifOption) GifOptions {
 	opts := GifOptions{
 		Loop:       0,
@@ -45,21 +67,36 @@ func defaultGifOptions(options []GifOption) GifOptions {
 	return opts
 }
 
-func CreateGif(files []string, opts ...GifOption) error {
+// CreateGif creates a gif out of the images, in order. With WithDryRun only the headers of the
+// images are read and nothing is written
+func CreateGif(files []string, opts ...GifOption) (result ProcessResult, err error) {
 	options := defaultGifOptions(opts)
 
+	start := time.Now()
+	result = ProcessResult{Inputs: files, Processor: "gif", Op: "gif", DryRun: options.dryRun}
+	defer func() { result.Duration = time.Since(start) }()
+
 	var maxWidth, maxHeight int
 	images := []image.Image{}
 
 	for _, pngFile := range files {
-		img, err := LoadImage(pngFile)
-		if err != nil {
-			return fmt.Errorf("while loading image: %w", err)
+		var bounds image.Rectangle
+		if options.dryRun {
+			cfg, _, err := decodeImageConfig(pngFile)
+			if err != nil {
+				return result, fmt.Errorf("while loading image: %w", err)
+			}
+			bounds = image.Rect(0, 0, cfg.Width, cfg.Height)
+		} else {
+			img, err := LoadImage(pngFile)
+			if err != nil {
+				return result, fmt.Errorf("while loading image: %w", err)
+			}
+			images = append(images, img)
+			bounds = img.Bounds()
 		}
-		images = append(images, img)
 
 		// Update max dimensions
-		bounds := img.Bounds()
 		if bounds.Dx() > maxWidth {
 			maxWidth = bounds.Dx()
 		}
@@ -67,6 +104,52 @@ func CreateGif(files []string, opts ...GifOption) error {
 			maxHeight = bounds.Dy()
 		}
 	}
+	result.Width, result.Height = maxWidth, maxHeight
+
+	timestamp := time.Now().Format(time.DateTime)
+	fileName := fmt.Sprintf("gif-%s", timestamp)
+
+	if options.outputName != "" {
+		fileName = options.outputName
+	} else if options.nameTemplate != "" && len(files) > 0 {
+		data := nameData{
+			Name: strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0])),
+			Op:   "gif",
+			W:    maxWidth,
+			H:    maxHeight,
+			hash: func() string { return hashFrames(files) },
+		}
+		name, err := renderNameTemplate(options.nameTemplate, data)
+		if err != nil {
+			return result, err
+		}
+		fileName = name
+	}
+
+	createDirectory := utils.CreateDirectory
+	if options.dryRun {
+		createDirectory = utils.OutputDirectory
+	}
+	dirFolder, err := createDirectory()
+	if err != nil {
+		return result, err
+	}
+	outputPath, skip, err := resolveCollision(filepath.Join(dirFolder, "gifs", fileName+".gif"), options.collision)
+	if err != nil {
+		return result, err
+	}
+	result.Output = outputPath
+	result.Skipped = skip
+
+	switch {
+	case skip:
+		fmt.Fprintf(Messages(), "Gif already exists, skipped %s\n\n", outputPath)
+		return result, nil
+	case options.dryRun:
+		fmt.Fprintf(Messages(), "Gif would be saved as %s\n\n", outputPath)
+		return result, nil
+	}
+	fileName = strings.TrimSuffix(filepath.Base(outputPath), ".gif")
 
 	newGif := &gif.GIF{
 		LoopCount: options.Loop,
@@ -82,18 +165,11 @@ func CreateGif(files []string, opts ...GifOption) error {
 		newGif.Delay = append(newGif.Delay, options.Delay)
 	}
 
-	timestamp := time.Now().Format(time.DateTime)
-	fileName := fmt.Sprintf("gif-%s", timestamp)
-
-	if options.outputName != "" {
-		fileName = options.outputName
-	}
-
-	err := SaveGif(*newGif, fileName)
+	err = SaveGif(*newGif, fileName)
 	if err != nil {
-		return fmt.Errorf("while saving gif: %w", err)
+		return result, fmt.Errorf("while saving gif: %w", err)
 	}
-	return nil
+	return result, nil
 }
 
 func resizeAspectRatio(img image.Image, targetWidth, targetHeight int) image.Image {
@@ -122,3 +198,13 @@ func resizeAspectRatio(img image.Image, targetWidth, targetHeight int) image.Ima
 	}
 	return newImg
 }
+
+// hashFrames combines the hashes of every frame of the gif into one short hash
+func hashFrames(files []string) string {
+	hasher := md5.New()
+	for _, file := range files {
+		h, _ := hashFile(file)
+		hasher.Write([]byte(h))
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:HashLength/2]
+}
@@ -1,10 +1,11 @@
 package image
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
@@ -16,7 +17,6 @@ import (
 	"runtime"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/Achno/gowall/config"
@@ -28,27 +28,86 @@ import (
 )
 
 // Available formats to Encode an image in
-var encoders = map[string]func(file *os.File, img image.Image) error{
-	"png": func(file *os.File, img image.Image) error {
+var encoders = map[string]func(w io.Writer, img image.Image) error{
+	"png": func(w io.Writer, img image.Image) error {
 		png := &png.Encoder{
 			CompressionLevel: png.BestSpeed,
 		}
-		return png.Encode(file, img)
+		return png.Encode(w, img)
 	},
-	"jpg": func(file *os.File, img image.Image) error {
-		return jpeg.Encode(file, img, nil)
+	"jpg": func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, nil)
 	},
-	"jpeg": func(file *os.File, img image.Image) error {
-		return jpeg.Encode(file, img, nil)
+	"jpeg": func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, nil)
 	},
-	"webp": func(file *os.File, img image.Image) error {
-		return webp.Encode(file, img, nil)
+	"webp": func(w io.Writer, img image.Image) error {
+		return webp.Encode(w, img, nil)
+	},
+	"gif": func(w io.Writer, img image.Image) error {
+		if paletted := exactPaletted(img); paletted != nil {
+			return gif.Encode(w, paletted, nil)
+		}
+		return gif.Encode(w, img, nil)
 	},
 }
 
+// exactPaletted returns the image with a palette of its own colors, so a gif of an image mapped to a theme
+// keeps the exact theme colors. It returns nil when the image has more than 256 colors, which the gif encoder
+// then quantizes. Gifs only know fully transparent pixels, the colors of the others are kept opaque
+func exactPaletted(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, nil)
+	indices := make(map[color.RGBA]uint8)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			key := color.RGBA{}
+			if c.A != 0 {
+				key = color.RGBA{c.R, c.G, c.B, 0xff}
+			}
+			index, ok := indices[key]
+			if !ok {
+				if len(paletted.Palette) == 256 {
+					return nil
+				}
+				index = uint8(len(paletted.Palette))
+				indices[key] = index
+				paletted.Palette = append(paletted.Palette, key)
+			}
+			paletted.Pix[paletted.PixOffset(x, y)] = index
+		}
+	}
+	return paletted
+}
+
+// StdioPath used as an input path reads the image from stdin, used as an output path writes it to stdout
+const StdioPath = "-"
+
+// messages is where the status messages of gowall and the tools it runs are printed
+var messages io.Writer = os.Stdout
+
+// StreamToStdout reserves stdout for the image data and sends the status messages to stderr,
+// so gowall can be used inside a shell pipeline. Call it before anything gets printed.
+func StreamToStdout() {
+	messages = os.Stderr
+}
+
+// Messages returns the writer for status messages, stdout unless StreamToStdout reserved it
+func Messages() io.Writer {
+	return messages
+}
+
+// Stdout returns the writer for machine readable output and images written to StdioPath
+func Stdout() io.Writer {
+	return os.Stdout
+}
+
 // Create a Processor of this interface and call 'ProcessImg'
+// Long running processors should return early with ctx.Err() once the context is cancelled
 type ImageProcessor interface {
-	Process(image.Image, string) (image.Image, error)
+	Process(context.Context, image.Image, string) (image.Image, error)
 }
 
 // NoOpImageProcessor  implements ImageProcessor but does nothing.
@@ -58,43 +117,90 @@ type ImageProcessor interface {
 type NoOpImageProcessor struct{}
 
 // Implement the Process method
-func (p *NoOpImageProcessor) Process(img image.Image, options string) (image.Image, error) {
+func (p *NoOpImageProcessor) Process(ctx context.Context, img image.Image, options string) (image.Image, error) {
 	// Simply return the image without any modifications
 	return img, nil
 }
 
 func LoadImage(filePath string) (image.Image, error) {
+	img, _, err := LoadImageWithFormat(filePath)
+	return img, err
+}
+
+// LoadImageWithFormat loads the image and returns the format detected from its magic bytes.
+// A filePath of "-" reads the image from stdin
+func LoadImageWithFormat(filePath string) (image.Image, string, error) {
+
+	if filePath == StdioPath {
+		return DecodeImage(os.Stdin)
+	}
 
 	file, err := os.Open(filePath)
 
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	return DecodeImage(file)
+}
 
-	return img, err
+// DecodeImage decodes an image from any reader, the format is detected from the magic bytes
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", utils.ErrDecode, err)
+	}
+	return img, format, nil
 }
 
+// SaveImage encodes the image in the given format, a filePath of "-" writes it to stdout
 func SaveImage(img image.Image, filePath string, format string) error {
+	return SaveImageContext(context.Background(), img, filePath, format)
+}
 
-	encoder, ok := encoders[strings.ToLower(format)]
+// SaveImageContext is like SaveImage but stops writing once ctx is cancelled.
+// The image is written to a temporary file renamed into place once complete,
+// so no corrupt outputs are left behind and nothing reads a half written image
+func SaveImageContext(ctx context.Context, img image.Image, filePath string, format string) error {
 
-	if !ok {
-		return fmt.Errorf("unsupported format: %s", format)
+	if filePath == StdioPath {
+		return EncodeImage(&ctxWriter{ctx: ctx, w: Stdout()}, img, format)
 	}
 
-	file, err := os.Create(filePath)
+	if _, ok := encoders[strings.ToLower(format)]; !ok {
+		return fmt.Errorf("%w: %s", utils.ErrUnsupportedFormat, format)
+	}
 
-	if err != nil {
-		return err
+	return utils.WriteFileAtomic(filePath, func(w io.Writer) error {
+		return EncodeImage(&ctxWriter{ctx: ctx, w: w}, img, format)
+	})
+}
+
+// ctxWriter fails every write after the context is cancelled, which aborts the encoder
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *ctxWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return cw.w.Write(p)
+}
 
-	defer file.Close()
-	return encoder(file, img)
+// EncodeImage encodes the image in the given format to any writer
+func EncodeImage(w io.Writer, img image.Image, format string) error {
 
+	encoder, ok := encoders[strings.ToLower(format)]
+
+	if !ok {
+		return fmt.Errorf("%w: %s", utils.ErrUnsupportedFormat, format)
+	}
+
+	return encoder(w, img)
 }
 
 func SaveGif(gifData gif.GIF, fileName string) error {
@@ -103,18 +209,15 @@ func SaveGif(gifData gif.GIF, fileName string) error {
 		return err
 	}
 
-	outFile, err := os.Create(filepath.Join(dirFolder, "gifs", fileName+".gif"))
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outFile.Close()
-
-	err = gif.EncodeAll(outFile, &gifData)
+	outPath := filepath.Join(dirFolder, "gifs", fileName+".gif")
+	err = utils.WriteFileAtomic(outPath, func(w io.Writer) error {
+		return gif.EncodeAll(w, &gifData)
+	})
 	if err != nil {
 		return fmt.Errorf("while Encoding gif : %w", err)
 	}
 
-	fmt.Printf("Gif processed and saved as %s\n\n", outFile.Name())
+	fmt.Fprintf(Messages(), "Gif processed and saved as %s\n\n", outPath)
 	return nil
 }
 
@@ -137,23 +240,20 @@ func SaveUrlAsImg(url string) (string, error) {
 
 	path := filepath.Join(dirFolder, fileName)
 
-	file, err := os.Create(path)
-	if err != nil {
-		return "", fmt.Errorf("could not create file: %w", err)
-	}
-	defer file.Close()
-
 	resp, err := http.Get(url)
 	if err != nil {
-		return "", fmt.Errorf("could not fetch the URL: %w", err)
+		return "", fmt.Errorf("%w: could not fetch the URL: %w", utils.ErrNetwork, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch image: status code %d", resp.StatusCode)
+		return "", fmt.Errorf("%w: failed to fetch image: status code %d", utils.ErrNetwork, resp.StatusCode)
 	}
 
-	_, err = io.Copy(file, resp.Body)
+	err = utils.WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
 	if err != nil {
 		return "", fmt.Errorf("could not write to file: %w", err)
 	}
@@ -165,7 +265,7 @@ func SaveUrlAsImg(url string) (string, error) {
 // or in the terminal for kitty,wezterm,ghostty and konsole
 func OpenImage(filePath string) error {
 
-	if !config.GowallConfig.EnableImagePreviewing {
+	if !config.GowallConfig.EnableImagePreviewing || filePath == StdioPath {
 		return nil
 	}
 
@@ -173,7 +273,7 @@ func OpenImage(filePath string) error {
 
 	if terminal.IsKittyTerminalRunning() {
 		cmd = exec.Command("kitty", "icat", filePath)
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = Messages()
 
 		return cmd.Run()
 	}
@@ -182,7 +282,7 @@ func OpenImage(filePath string) error {
 
 	if isKonsoleOrGhostty && terminal.HasIcat() && !config.GowallConfig.InlineImagePreview {
 		cmd = exec.Command("kitty", "icat", filePath)
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = Messages()
 
 		return cmd.Run()
 	}
@@ -193,7 +293,7 @@ func OpenImage(filePath string) error {
 
 	if terminal.IsWeztermTerminalRunning() {
 		cmd = exec.Command("wezterm", "imgcat", filePath)
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = Messages()
 
 		return cmd.Run()
 	}
@@ -219,6 +319,14 @@ type ProcessOptions struct {
 	SaveToFile bool   // Whether to save the processed image to file
 	OutputExt  string // Optional output extension to override the original
 	OutputName string // Optional outputName
+	Quiet      bool   // Don't print where the image was saved, batch processing reports progress instead
+	OutputDir  string // Optional directory to save to instead of the default output folder
+
+	// Optional template for the output name, placeholders: {name} {theme} {op} {date} {w} {h} {hash}
+	NameTemplate string
+	Collision    string // What to do when the output exists: overwrite (default), skip, suffix or error
+	Op           string // Name of the operation, used by the {op} placeholder
+	DryRun       bool   // Resolve the theme, parameters and output path without decoding, processing or saving
 }
 
 func DefaultProcessOptions() ProcessOptions {
@@ -229,86 +337,225 @@ func DefaultProcessOptions() ProcessOptions {
 
 // Processes the image depending on a processor that impliments the "ImageProcessor" interface.
 // You can pass an optional  "ProcessOptions" struct with extra options.
-func ProcessImg(imgPath string, processor ImageProcessor, theme string, opts ...ProcessOptions) (string, *image.Image, error) {
+// Processing stops as soon as ctx is cancelled.
+func ProcessImg(ctx context.Context, imgPath string, processor ImageProcessor, theme string, opts ...ProcessOptions) (string, *image.Image, error) {
+	result, img, err := ProcessImgResult(ctx, imgPath, processor, theme, opts...)
+	if err != nil {
+		return "", nil, err
+	}
+	return result.Output, img, nil
+}
+
+// ProcessImgResult works like ProcessImg but also returns a ProcessResult describing the run.
+// With ProcessOptions.DryRun nothing is decoded, processed or written
+func ProcessImgResult(ctx context.Context, imgPath string, processor ImageProcessor, theme string, opts ...ProcessOptions) (result ProcessResult, newImg *image.Image, err error) {
 	// Use default options if none provided
 	options := DefaultProcessOptions()
 	if len(opts) > 0 {
 		options = opts[0]
 	}
 
+	start := time.Now()
+	result = ProcessResult{
+		Input:     imgPath,
+		Processor: processorName(processor),
+		Op:        options.Op,
+		Theme:     theme,
+		DryRun:    options.DryRun,
+	}
+	defer func() { result.Duration = time.Since(start) }()
+
+	if err := ctx.Err(); err != nil {
+		return result, nil, err
+	}
+
+	if options.DryRun {
+		err := dryRunImg(&result, imgPath, processor, theme, options)
+		return result, nil, err
+	}
+
 	// Handle directory creation
 	dirPath, err := utils.CreateDirectory()
 	if err != nil {
-		return "", nil, fmt.Errorf("while creating directory: %w", err)
+		return result, nil, fmt.Errorf("while creating directory: %w", err)
 	}
 
 	// Load the image
-	img, err := LoadImage(imgPath)
+	img, inputFormat, err := LoadImageWithFormat(imgPath)
 	if err != nil {
-		return "", nil, fmt.Errorf("while loading image: %w", err)
+		return result, nil, fmt.Errorf("while loading image: %w", err)
 	}
 
 	// optionally specify a temporary theme via json file in runtime
 	if strings.HasSuffix(theme, ".json") {
 		theme, err = loadThemeFromJson(theme)
 		if err != nil {
-			return "", nil, err
+			return result, nil, err
+		}
+	}
+
+	// Resolve the output path before processing, so skipped images are never processed
+	outputFilePath := ""
+	if options.SaveToFile {
+		var skip bool
+		bounds := img.Bounds()
+		hash := func() string { return hashInput(imgPath, img) }
+
+		outputFilePath, skip, err = resolveOutputPath(imgPath, inputFormat, theme, bounds.Dx(), bounds.Dy(), hash, options, dirPath)
+		if err != nil {
+			return result, nil, err
+		}
+		result.Output = outputFilePath
+		if skip {
+			result.Skipped = true
+			if !options.Quiet {
+				fmt.Fprintf(Messages(), "Image already exists, skipped %s\n\n", outputFilePath)
+			}
+			return result, nil, nil
 		}
 	}
 
 	// Process the image
-	newImg, err := processor.Process(img, theme)
+	processed, err := processor.Process(ctx, img, theme)
 	if err != nil {
-		return "", nil, fmt.Errorf("while processing image: %w", err)
+		return result, nil, fmt.Errorf("while processing image: %w", err)
+	}
+	if processed != nil {
+		result.Width, result.Height = processed.Bounds().Dx(), processed.Bounds().Dy()
 	}
 
 	// If we don't need to save, return early with the processed image
 	if !options.SaveToFile {
-		return "", &newImg, nil
+		return result, &processed, nil
 	}
 
-	outputFilePath, err := buildOutputPath(imgPath, options, dirPath)
-	if err != nil {
-		return "", nil, err
+	if outputFilePath != StdioPath {
+		if err := os.MkdirAll(filepath.Dir(outputFilePath), dirPermissions); err != nil {
+			return result, nil, fmt.Errorf("while creating output directory: %w", err)
+		}
 	}
 
-	ext := strings.ToLower(filepath.Ext(outputFilePath))[1:]
 	// Save the image
-	err = SaveImage(newImg, outputFilePath, ext)
+	ext := outputFormat(outputFilePath, options)
+	err = SaveImageContext(ctx, processed, outputFilePath, ext)
 	if err != nil {
-		return "", nil, fmt.Errorf("while saving image: %w in %s", err, outputFilePath)
+		return result, nil, fmt.Errorf("while saving image: %w in %s", err, outputFilePath)
 	}
 
-	fmt.Printf("Image processed and saved as %s\n\n", outputFilePath)
-	return outputFilePath, &newImg, nil
+	if outputFilePath == StdioPath || options.Quiet {
+		return result, &processed, nil
+	}
+
+	fmt.Fprintf(Messages(), "Image processed and saved as %s\n\n", outputFilePath)
+	return result, &processed, nil
 }
 
-// returns themeName that was inserted to the theme map
-func loadThemeFromJson(jsonTheme string) (string, error) {
-	expandFile := utils.ExpandHomeDirectory([]string{jsonTheme})
-	data, err := os.ReadFile(expandFile[0])
+// dryRunImg resolves the theme, parameters and output path of ProcessImg. Only the image header is read
+func dryRunImg(result *ProcessResult, imgPath string, processor ImageProcessor, theme string, options ProcessOptions) error {
+	dirPath, err := utils.OutputDirectory()
+	if err != nil {
+		return fmt.Errorf("while getting output directory: %w", err)
+	}
+
+	cfg, inputFormat, err := decodeImageConfig(imgPath)
 	if err != nil {
-		return "", fmt.Errorf("while reading the json file")
+		return fmt.Errorf("while loading image: %w", err)
+	}
+	result.Width, result.Height = cfg.Width, cfg.Height
+
+	if strings.HasSuffix(theme, ".json") {
+		theme, err = loadThemeFromJson(theme)
+		if err != nil {
+			return err
+		}
 	}
-	var tm struct {
-		Name   string   `json:"name"`
-		Colors []string `json:"colors"`
+
+	if err := ValidateProcessor(processor, theme); err != nil {
+		return fmt.Errorf("while validating parameters: %w", err)
 	}
 
-	if err := json.Unmarshal(data, &tm); err != nil {
-		return "", fmt.Errorf("while parsing json theme file, ensure your .json is written correctly")
+	if !options.SaveToFile {
+		return nil
 	}
-	if len(tm.Name) <= 0 || len(tm.Colors) < 1 {
-		return "", fmt.Errorf("json file does not contain a name or colors field(s)")
+
+	hash := func() string {
+		if h, err := hashFile(imgPath); err == nil {
+			return h[:HashLength/2]
+		}
+		return ""
 	}
-	clrs, err := HexToRGBASlice(tm.Colors)
+	outputFilePath, skip, err := resolveOutputPath(imgPath, inputFormat, theme, cfg.Width, cfg.Height, hash, options, dirPath)
 	if err != nil {
-		return "", err
+		return err
 	}
-	themes[strings.ToLower(tm.Name)] = Theme{
-		Name:   tm.Name,
-		Colors: clrs,
+	if _, ok := encoders[outputFormat(outputFilePath, options)]; !ok {
+		return fmt.Errorf("%w: %s", utils.ErrUnsupportedFormat, outputFormat(outputFilePath, options))
 	}
+	result.Output = outputFilePath
+	result.Skipped = skip
+
+	if outputFilePath != StdioPath && !options.Quiet {
+		if skip {
+			fmt.Fprintf(Messages(), "Image already exists, would skip %s\n\n", outputFilePath)
+		} else {
+			fmt.Fprintf(Messages(), "Image would be saved as %s\n\n", outputFilePath)
+		}
+	}
+	return nil
+}
+
+// decodeImageConfig reads only the dimensions and format of an image, "-" reads from stdin
+func decodeImageConfig(imgPath string) (image.Config, string, error) {
+	r := io.Reader(os.Stdin)
+	if imgPath != StdioPath {
+		file, err := os.Open(imgPath)
+		if err != nil {
+			return image.Config{}, "", err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return image.Config{}, "", fmt.Errorf("%w: %w", utils.ErrDecode, err)
+	}
+	return cfg, format, nil
+}
+
+// resolveOutputPath builds the output path of the image and applies the collision policy.
+// skip is true when the collision policy says the image should not be processed
+func resolveOutputPath(imgPath, inputFormat, theme string, w, h int, hash func() string, options ProcessOptions, dirPath string) (string, bool, error) {
+	if options.OutputDir != "" {
+		dirPath = options.OutputDir
+	}
+
+	data := nameData{
+		Name:  strings.TrimSuffix(filepath.Base(imgPath), filepath.Ext(imgPath)),
+		Theme: theme,
+		Op:    options.Op,
+		W:     w,
+		H:     h,
+		hash:  hash,
+	}
+
+	outputFilePath, err := buildOutputPath(imgPath, inputFormat, options, dirPath, data)
+	if err != nil {
+		return "", false, err
+	}
+
+	return resolveCollision(outputFilePath, options.Collision)
+}
+
+// returns themeName that was inserted to the theme map
+func loadThemeFromJson(jsonTheme string) (string, error) {
+	expandFile := utils.ExpandHomeDirectory([]string{jsonTheme})
+	tm, err := ParseThemeFile(expandFile[0])
+	if err != nil {
+		return "", fmt.Errorf("while loading the json theme: %w", err)
+	}
+	loadThemes()
+	registerTheme(strings.ToLower(tm.Name), tm)
 
 	return tm.Name, nil
 }
@@ -316,18 +563,38 @@ func loadThemeFromJson(jsonTheme string) (string, error) {
 // returns the outputFilePath where the image should be saved, taking into account the ProcessOptions.
 // If options.OutputName has no extension, its inferred and is saved to the default Dir.
 // otherwise options.OutputName is treated like an absolute path, so you can save the image outside the default directory
-func buildOutputPath(imgPath string, options ProcessOptions, dirPath string) (string, error) {
+// An OutputName of "-" means stdout and needs an OutputExt. Images read from stdin use the format detected from their magic bytes.
+// Without an OutputName the optional NameTemplate is rendered with data
+func buildOutputPath(imgPath string, inputFormat string, options ProcessOptions, dirPath string, data nameData) (string, error) {
+	if options.OutputExt != "" {
+		if _, exists := encoders[strings.ToLower(options.OutputExt)]; !exists {
+			return "", fmt.Errorf("%w: %s", utils.ErrUnsupportedFormat, options.OutputExt)
+		}
+	}
+
+	if options.OutputName == StdioPath {
+		if options.OutputExt == "" {
+			return "", utils.InvalidParameter("writing the image to stdout needs an output format, set it with --format")
+		}
+		return StdioPath, nil
+	}
+
 	originalExt := strings.ToLower(filepath.Ext(imgPath))
-	if originalExt == "" {
-		return "", fmt.Errorf("error: Could not determine file extension")
+	baseName := strings.TrimSuffix(filepath.Base(imgPath), filepath.Ext(imgPath))
+
+	if imgPath == StdioPath {
+		originalExt = "." + inputFormat
+		baseName = fmt.Sprintf("stdin-%s", time.Now().Format("20060102-150405"))
+		data.Name = baseName
+	}
+
+	if originalExt == "" || originalExt == "." {
+		return "", fmt.Errorf("%w: could not determine file extension", utils.ErrUnsupportedFormat)
 	}
 	originalExt = originalExt[1:] // remove '.'
 
 	finalExt := originalExt
 	if options.OutputExt != "" {
-		if _, exists := encoders[strings.ToLower(options.OutputExt)]; !exists {
-			return "", fmt.Errorf("unsupported format: %s", options.OutputExt)
-		}
 		finalExt = options.OutputExt
 	}
 
@@ -337,42 +604,240 @@ func buildOutputPath(imgPath string, options ProcessOptions, dirPath string) (st
 	}
 
 	// Build filename without extension
-	baseName := strings.TrimSuffix(filepath.Base(imgPath), filepath.Ext(imgPath))
 	if options.OutputName != "" {
 		baseName = options.OutputName
+	} else if options.NameTemplate != "" {
+		name, err := renderNameTemplate(options.NameTemplate, data)
+		if err != nil {
+			return "", err
+		}
+		baseName = name
 	}
 
 	return filepath.Join(dirPath, baseName+"."+finalExt), nil
 }
 
-// Process images concurrently and return the first error if there was one
-func ProcessBatchImgs(files []string, theme string, processor ImageProcessor) error {
+// outputFormat returns the format the image should be encoded in. Files use their extension,
+// stdout uses the --format option, which buildOutputPath requires
+func outputFormat(outputFilePath string, options ProcessOptions) string {
+	if outputFilePath != StdioPath {
+		return strings.ToLower(filepath.Ext(outputFilePath))[1:]
+	}
+	return strings.ToLower(options.OutputExt)
+}
+
+// BatchOptions controls how many images of a batch are processed at once
+type BatchOptions struct {
+	Jobs         int              // Number of images processed concurrently, defaults to runtime.NumCPU()
+	MemoryBudget int64            // Optional limit in bytes for the estimated memory of in-flight images, 0 means no limit
+	Progress     ProgressReporter // Optional progress reporter, defaults to a terminal progress bar
+	// Optional output path per input file, relative to the output folder. Used to mirror the input
+	// directory tree instead of saving every image directly in the output folder
+	RelativeOutputs map[string]string
+
+	NameTemplate string // See ProcessOptions
+	Collision    string // See ProcessOptions
+	Op           string // See ProcessOptions
+
+	// Record every output in a manifest in the output folder and skip inputs whose content,
+	// processor, parameters and theme palette didn't change since the last run
+	Manifest bool
+	Force    bool // With Manifest, process every input even when it is up to date
+	Resume   bool // With Manifest, only process the inputs an interrupted run didn't finish. files may be empty
+	DryRun   bool // See ProcessOptions, the manifest is read but not written
+}
+
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		Jobs:     runtime.NumCPU(),
+		Progress: NewTerminalProgress(os.Stderr),
+	}
+}
+
+// Process images with a bounded pool of workers and return all the errors there were, one per line.
+// When a memory budget is set, decoding waits until the estimated memory of the images in flight
+// leaves room for the next one. Once ctx is cancelled no new images are started
+func ProcessBatchImgs(ctx context.Context, files []string, theme string, processor ImageProcessor, opts ...BatchOptions) error {
+
+	options := DefaultBatchOptions()
+	if len(opts) > 0 {
+		if opts[0].Jobs > 0 {
+			options.Jobs = opts[0].Jobs
+		}
+		if opts[0].Progress != nil {
+			options.Progress = opts[0].Progress
+		}
+		options.MemoryBudget = opts[0].MemoryBudget
+		options.RelativeOutputs = opts[0].RelativeOutputs
+		options.NameTemplate = opts[0].NameTemplate
+		options.Collision = opts[0].Collision
+		options.Op = opts[0].Op
+		options.Manifest = opts[0].Manifest
+		options.Force = opts[0].Force
+		options.Resume = opts[0].Resume
+		options.DryRun = opts[0].DryRun
+	}
+	progress := options.Progress
+
+	createDirectory := utils.CreateDirectory
+	if options.DryRun {
+		createDirectory = utils.OutputDirectory
+	}
+	dirPath, err := createDirectory()
+	if err != nil {
+		return fmt.Errorf("while creating directory: %w", err)
+	}
+
+	// load a json theme once instead of once per image
+	if strings.HasSuffix(theme, ".json") {
+		theme, err = loadThemeFromJson(theme)
+		if err != nil {
+			return err
+		}
+	}
+
+	var manifest *Manifest
+	if options.Manifest {
+		manifest, err = LoadManifest(dirPath)
+		if err != nil {
+			return err
+		}
+		if options.Resume {
+			files, err = manifest.resumeInputs(processorName(processor), files)
+			if err != nil {
+				return err
+			}
+		}
+		if !options.DryRun {
+			if err := manifest.StartRun(processorName(processor), theme, files); err != nil {
+				return fmt.Errorf("while saving manifest: %w", err)
+			}
+		}
+	}
+
+	var budget *pixelBudget
+	if options.MemoryBudget > 0 {
+		budget = newPixelBudget(options.MemoryBudget / bytesPerPixel)
+	}
+
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for index := range files {
+			select {
+			case jobs <- index:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	var wg sync.WaitGroup
-	var remaining int32 = int32(len(files))
+	var mu sync.Mutex
+	var completed int
 	errChan := make(chan error, len(files))
 
-	for index, file := range files {
+	start := time.Now()
+	progress.Start(len(files))
 
-		wg.Add(1)
+	worker := func() {
+		defer wg.Done()
 
-		go func(file string, index int) {
-			defer wg.Done()
+		for index := range jobs {
+			file := files[index]
 
-			_, _, err := ProcessImg(file, processor, theme)
+			// wait for enough memory before decoding the image
+			var reserved int64
+			if budget != nil {
+				var err error
+				reserved, err = budget.acquire(ctx, estimatePixels(file))
+				if err != nil {
+					return
+				}
+			}
 
-			if err != nil {
-				errChan <- fmt.Errorf("file %s : %w", file, err)
+			opts := DefaultProcessOptions()
+			opts.Quiet = true
+			opts.NameTemplate = options.NameTemplate
+			opts.Collision = options.Collision
+			opts.Op = options.Op
+			opts.DryRun = options.DryRun
+			if rel, ok := options.RelativeOutputs[file]; ok {
+				opts.OutputDir = filepath.Join(dirPath, filepath.Dir(filepath.FromSlash(rel)))
+			}
+
+			var entry ManifestEntry
+			var err error
+			result := ProcessResult{Input: file, Processor: processorName(processor), Op: options.Op, Theme: theme, DryRun: options.DryRun}
+			if manifest != nil {
+				entry, err = newManifestEntry(file, processor, theme, opts)
+				if err == nil && !options.Force {
+					result.Output, result.Skipped = manifest.Lookup(entry)
+				}
+			}
+
+			if err == nil && !result.Skipped {
+				result, _, err = ProcessImgResult(ctx, file, processor, theme, opts)
+				if err == nil && !result.Skipped && !result.DryRun && manifest != nil {
+					entry.Output, _ = filepath.Abs(result.Output)
+					err = manifest.Complete(entry)
+				}
+			}
+
+			if budget != nil {
+				budget.release(reserved)
+			}
+
+			// cancelled images are not reported one by one, the whole batch fails below
+			if ctx.Err() != nil {
 				return
 			}
-			remainingCount := atomic.AddInt32(&remaining, -1)
-			fmt.Printf(" ::: Image %d Completed , %d Images left ::: \n", index, remainingCount)
-		}(file, index)
 
+			if err != nil {
+				err = fmt.Errorf("file %s : %w", file, err)
+				errChan <- err
+			}
+
+			mu.Lock()
+			completed++
+			progress.Update(ProgressEvent{
+				Index:     index,
+				Input:     file,
+				Output:    result.Output,
+				Err:       err,
+				Skipped:   result.Skipped,
+				Result:    result,
+				Completed: completed,
+				Total:     len(files),
+				Elapsed:   time.Since(start),
+			})
+			mu.Unlock()
+		}
+	}
+
+	for i := 0; i < min(options.Jobs, len(files)); i++ {
+		wg.Add(1)
+		go worker()
 	}
 
 	wg.Wait()
 	close(errChan)
+	progress.Finish()
+
+	// the run stays in the manifest when it was interrupted or images failed, so --resume can retry them
+	if manifest != nil && !options.DryRun {
+		save := manifest.Flush
+		if ctx.Err() == nil && len(errChan) == 0 {
+			save = manifest.FinishRun
+		}
+		if err := save(); err != nil {
+			return fmt.Errorf("while saving manifest: %w", err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("batch processing cancelled: %w", err)
+	}
 
 	if len(errChan) > 0 {
 		// return <-errChan
@@ -382,7 +847,7 @@ func ProcessBatchImgs(files []string, theme string, processor ImageProcessor) er
 			errs = append(errs, err)
 		}
 
-		return errors.New(utils.FormatErrors(errs))
+		return errors.Join(errs...)
 	}
 
 	return nil
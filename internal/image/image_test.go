@@ -0,0 +1,84 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+
+	"github.com/Achno/gowall/utils"
+)
+
+func TestStdoutNeedsOutputFormat(t *testing.T) {
+	options := ProcessOptions{OutputName: StdioPath}
+	for _, input := range []string{"wall.png", StdioPath} {
+		_, err := buildOutputPath(input, "png", options, t.TempDir(), nameData{})
+		if !errors.Is(err, utils.ErrInvalidParameter) {
+			t.Errorf("%s to stdout without a format: error %v, want ErrInvalidParameter", input, err)
+		}
+	}
+
+	options.OutputExt = "webp"
+	path, err := buildOutputPath(StdioPath, "png", options, t.TempDir(), nameData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != StdioPath || outputFormat(path, options) != "webp" {
+		t.Errorf("output %q in %q, want stdout in webp", path, outputFormat(path, options))
+	}
+}
+
+// images read from stdin are saved in their own format, gifs included
+func TestStdinGifHasEncoder(t *testing.T) {
+	path, err := buildOutputPath(StdioPath, "gif", ProcessOptions{}, t.TempDir(), nameData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := encoders[outputFormat(path, ProcessOptions{})]; !ok {
+		t.Errorf("no encoder for %s", path)
+	}
+}
+
+func TestGifKeepsExactColors(t *testing.T) {
+	img := translucentImage()
+	img.SetNRGBA(0, 0, color.NRGBA{})
+
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, img, "gif"); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gif.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			want := img.NRGBAAt(x, y)
+			if want.A != 0 {
+				want.A = 0xff
+			}
+			if got != want {
+				t.Fatalf("color at %d,%d = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestGifQuantizesManyColors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 8), uint8(y * 8), 0x80, 0xff})
+		}
+	}
+	if exactPaletted(img) != nil {
+		t.Fatal("an image with more than 256 colors got an exact palette")
+	}
+	if err := EncodeImage(&bytes.Buffer{}, img, "gif"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,817 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of the theme files gowall reads
+const (
+	FormatGowall          = "gowall"           // gowall's JSON / YAML themes
+	FormatEmacs           = "emacs"            // Emacs .el themes
+	FormatBase16          = "base16"           // base16 and base24 YAML schemes
+	FormatAlacritty       = "alacritty"        // Alacritty TOML or YAML colors
+	FormatKitty           = "kitty"            // Kitty .conf themes
+	FormatXresources      = "xresources"       // Xresources / Xdefaults
+	FormatITerm2          = "iterm2"           // iTerm2 .itermcolors plists
+	FormatWindowsTerminal = "windows-terminal" // Windows Terminal schemes or settings.json
+	FormatGhostty         = "ghostty"          // Ghostty configs and themes
+	FormatVSCode          = "vscode"           // VS Code color themes or workbench.colorCustomizations
+)
+
+// errNotATheme is returned for files whose format can't be recognized
+var errNotATheme = errors.New("not a theme file")
+
+// themeImporters parse the themes of a file in a format other than gowall's and Emacs'.
+// name is the file name without the extension, for formats that don't name their themes
+var themeImporters = map[string]func(name string, data []byte) ([]Theme, error){
+	FormatBase16:          importBase16,
+	FormatAlacritty:       importAlacritty,
+	FormatKitty:           importKitty,
+	FormatXresources:      importXresources,
+	FormatITerm2:          importITerm2,
+	FormatWindowsTerminal: importWindowsTerminal,
+	FormatGhostty:         importGhostty,
+	FormatVSCode:          importVSCode,
+}
+
+// ThemeFormats returns the formats of the theme files gowall reads
+func ThemeFormats() []string {
+	formats := []string{FormatGowall, FormatEmacs}
+	for format := range themeImporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats[2:])
+	return formats
+}
+
+// ParseThemeFiles reads the themes of a file in any supported format. Most formats have one theme per file,
+// a Windows Terminal settings.json has one per scheme
+func ParseThemeFiles(filePath string) ([]Theme, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading theme file %s: %w", filePath, err)
+	}
+
+	format := detectThemeFormat(filePath, data)
+	switch format {
+	case "":
+		return nil, fmt.Errorf("%s: %w", filePath, errNotATheme)
+	case FormatGowall:
+		theme, err := parseJSONYAMLTheme(filePath, strings.ToLower(filepath.Ext(filePath)))
+		if err != nil {
+			return nil, err
+		}
+		return []Theme{theme}, nil
+	case FormatEmacs:
+		theme, err := parseEmacsTheme(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return []Theme{theme}, nil
+	}
+
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	themes, err := themeImporters[format](name, data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s theme file %s: %w", format, filePath, err)
+	}
+	return themes, nil
+}
+
+// detectThemeFormat returns the format of a theme file from its name and content, empty when it isn't a theme
+func detectThemeFormat(filePath string, data []byte) string {
+	base := strings.ToLower(filepath.Base(filePath))
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if base == "xresources" || base == "xdefaults" {
+		ext = "." + base
+	}
+
+	switch ext {
+	case ".el":
+		return FormatEmacs
+	case ".itermcolors":
+		return FormatITerm2
+	case ".toml":
+		return FormatAlacritty
+	case ".xresources", ".xdefaults":
+		return FormatXresources
+	case ".json":
+		var fields map[string]any
+		if err := json.Unmarshal(stripJSONComments(data), &fields); err != nil {
+			return FormatGowall // reports the syntax error
+		}
+		return detectJSONFormat(fields)
+	case ".yaml", ".yml":
+		var fields map[string]any
+		if err := yaml.Unmarshal(data, &fields); err != nil {
+			return FormatGowall
+		}
+		return detectYAMLFormat(fields)
+	case "", ".conf", ".ghostty":
+		return sniffConfigFormat(data)
+	}
+	return ""
+}
+
+func detectJSONFormat(fields map[string]any) string {
+	if _, ok := fields["schemes"]; ok {
+		return FormatWindowsTerminal
+	}
+	if _, ok := fields["brightBlack"]; ok {
+		return FormatWindowsTerminal
+	}
+	if _, ok := fields["workbench.colorCustomizations"]; ok {
+		return FormatVSCode
+	}
+	// VS Code themes have an object of colors, gowall themes a list
+	if _, ok := fields["colors"].(map[string]any); ok {
+		return FormatVSCode
+	}
+	for key := range fields {
+		if strings.HasPrefix(key, "terminal.") || strings.HasPrefix(key, "editor.") {
+			return FormatVSCode
+		}
+	}
+	return FormatGowall
+}
+
+func detectYAMLFormat(fields map[string]any) string {
+	if _, ok := fields["base00"]; ok {
+		return FormatBase16
+	}
+	if _, ok := fields["palette"].(map[any]any); ok {
+		return FormatBase16
+	}
+	if _, ok := fields["colors"].(map[any]any); ok {
+		return FormatAlacritty
+	}
+	return FormatGowall
+}
+
+var (
+	ghosttyLinePattern    = regexp.MustCompile(`(?m)^\s*(palette|background|foreground)\s*=`)
+	kittyLinePattern      = regexp.MustCompile(`(?m)^\s*(color\d{1,2}|background|foreground)\s+\S`)
+	xresourcesLinePattern = regexp.MustCompile(`(?m)^\s*[\w.*-]*[*.](color\d{1,2}|background|foreground)\s*:`)
+)
+
+// sniffConfigFormat tells the plain text formats apart by their lines, they share the extensions or have none
+func sniffConfigFormat(data []byte) string {
+	switch {
+	case ghosttyLinePattern.Match(data):
+		return FormatGhostty
+	case kittyLinePattern.Match(data):
+		return FormatKitty
+	case xresourcesLinePattern.Match(data):
+		return FormatXresources
+	}
+	return ""
+}
+
+// ansiColorNames are the names of color0-color7 in most formats, the bright ones are color8-color15
+var ansiColorNames = [8]string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// roleKeys are the keys of a format that set each role, the first one a file has wins
+type roleKeys map[string][]string
+
+// roleTheme creates a theme from the values of a format's keys. Values that aren't colors are skipped,
+// formats allow things like CellForeground in their place
+func roleTheme(name string, values map[string]string, keys roleKeys, extra ...color.Color) (Theme, error) {
+	var roles ThemeRoles
+	for _, role := range RoleNames() {
+		for _, key := range keys[role] {
+			value, ok := values[key]
+			if !ok {
+				continue
+			}
+			if rgba, err := parseColorValue(value); err == nil {
+				_ = roles.Set(role, rgba)
+				break
+			}
+		}
+	}
+	return newImportedTheme(name, roles, extra...)
+}
+
+// newImportedTheme returns the theme with its extra colors and roles as its colors
+func newImportedTheme(name string, roles ThemeRoles, extra ...color.Color) (Theme, error) {
+	colors := mergeRoleColors(dedupeColors(extra), roles)
+	if len(colors) == 0 {
+		return Theme{}, fmt.Errorf("no colors found in theme %s", name)
+	}
+	return Theme{Name: name, Colors: colors, Roles: roles}, nil
+}
+
+// dedupeColors returns the colors as color.RGBA without repeats
+func dedupeColors(colors []color.Color) []color.Color {
+	seen := make(map[color.RGBA]bool, len(colors))
+	deduped := make([]color.Color, 0, len(colors))
+	for _, c := range colors {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		if !seen[rgba] {
+			seen[rgba] = true
+			deduped = append(deduped, rgba)
+		}
+	}
+	return deduped
+}
+
+// parseColorValue parses the color notations of terminal and editor configs: #RGB, #RRGGBB, #RRGGBBAA,
+// 0xRRGGBB, RRGGBB and rgb:RR/GG/BB. Alpha is dropped, theme colors are opaque
+func parseColorValue(value string) (color.RGBA, error) {
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+	if rest, ok := strings.CutPrefix(strings.ToLower(value), "rgb:"); ok {
+		parts := strings.Split(rest, "/")
+		if len(parts) != 3 {
+			return color.RGBA{}, fmt.Errorf("invalid color %s", value)
+		}
+		var rgb [3]uint8
+		for i, part := range parts {
+			v, err := strconv.ParseUint(part, 16, 16)
+			if err != nil || len(part) == 0 || len(part) > 4 {
+				return color.RGBA{}, fmt.Errorf("invalid color %s", value)
+			}
+			// scale 1 to 4 hex digits to 8 bits
+			maxValue := math.Pow(16, float64(len(part))) - 1
+			rgb[i] = uint8(math.Round(float64(v) / maxValue * 255))
+		}
+		return color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255}, nil
+	}
+
+	digits := strings.TrimPrefix(value, "#")
+	if len(digits) == len(value) {
+		digits = strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	}
+	switch len(digits) {
+	case 3:
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	case 8:
+		digits = digits[:6]
+	case 6:
+	default:
+		return color.RGBA{}, fmt.Errorf("invalid color %s", value)
+	}
+
+	bytes, err := hex.DecodeString(digits)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %s", value)
+	}
+	return color.RGBA{R: bytes[0], G: bytes[1], B: bytes[2], A: 255}, nil
+}
+
+// base16Keys are the conventional terminal colors of base16 schemes, from base16-shell. base24 schemes
+// have their own bright colors, base16 ones repeat the normal colors
+var base16Keys = roleKeys{
+	"background": {"base00"},
+	"foreground": {"base05"},
+	"cursor":     {"base05"},
+	"selection":  {"base02"},
+	"color0":     {"base00"},
+	"color1":     {"base08"},
+	"color2":     {"base0B"},
+	"color3":     {"base0A"},
+	"color4":     {"base0D"},
+	"color5":     {"base0E"},
+	"color6":     {"base0C"},
+	"color7":     {"base05"},
+	"color8":     {"base03"},
+	"color9":     {"base12", "base08"},
+	"color10":    {"base14", "base0B"},
+	"color11":    {"base13", "base0A"},
+	"color12":    {"base16", "base0D"},
+	"color13":    {"base17", "base0E"},
+	"color14":    {"base15", "base0C"},
+	"color15":    {"base07"},
+}
+
+// importBase16 reads base16 and base24 schemes, both the original format with the colors at the top level
+// and the tinted-theming one with a palette. Colors may omit the #
+func importBase16(name string, data []byte) ([]Theme, error) {
+	var scheme struct {
+		Scheme  string            `yaml:"scheme"`
+		Name    string            `yaml:"name"`
+		Palette map[string]string `yaml:"palette"`
+	}
+	if err := yaml.Unmarshal(data, &scheme); err != nil {
+		return nil, err
+	}
+	var flat map[string]any
+	if err := yaml.Unmarshal(data, &flat); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for key, value := range flat {
+		if s, ok := value.(string); ok && strings.HasPrefix(strings.ToLower(key), "base") {
+			values[normalizeBaseKey(key)] = s
+		}
+	}
+	for key, value := range scheme.Palette {
+		values[normalizeBaseKey(key)] = value
+	}
+
+	// every baseXX is a theme color, in order
+	var extra []color.Color
+	for i := 0; i < 0x18; i++ {
+		if value, ok := values[fmt.Sprintf("base%02X", i)]; ok {
+			if rgba, err := parseColorValue(value); err == nil {
+				extra = append(extra, rgba)
+			}
+		}
+	}
+
+	theme, err := roleTheme(firstNonEmpty(scheme.Name, scheme.Scheme, name), values, base16Keys, extra...)
+	if err != nil {
+		return nil, err
+	}
+	return []Theme{theme}, nil
+}
+
+// normalizeBaseKey writes base16 keys like base0b as base0B
+func normalizeBaseKey(key string) string {
+	if len(key) != 6 || !strings.HasPrefix(strings.ToLower(key), "base") {
+		return key
+	}
+	return "base" + strings.ToUpper(key[4:])
+}
+
+// alacrittyKeys are the flattened Alacritty color keys of the roles
+var alacrittyKeys = func() roleKeys {
+	keys := roleKeys{
+		"background": {"colors.primary.background"},
+		"foreground": {"colors.primary.foreground"},
+		"cursor":     {"colors.cursor.cursor"},
+		"selection":  {"colors.selection.background"},
+	}
+	for i, name := range ansiColorNames {
+		keys[fmt.Sprintf("color%d", i)] = []string{"colors.normal." + name}
+		keys[fmt.Sprintf("color%d", i+8)] = []string{"colors.bright." + name}
+	}
+	return keys
+}()
+
+// importAlacritty reads the colors of an Alacritty TOML or YAML config
+func importAlacritty(name string, data []byte) ([]Theme, error) {
+	values, err := parseTOMLStrings(data)
+	if err != nil || len(values) == 0 {
+		// the YAML configs of older Alacritty versions
+		var fields map[string]any
+		if yamlErr := yaml.Unmarshal(data, &fields); yamlErr != nil {
+			if err != nil {
+				return nil, err
+			}
+			return nil, yamlErr
+		}
+		values = make(map[string]string)
+		flattenYAML("", fields, values)
+	}
+
+	theme, err := roleTheme(name, values, alacrittyKeys)
+	if err != nil {
+		return nil, err
+	}
+	return []Theme{theme}, nil
+}
+
+// parseTOMLStrings reads the string values of a TOML document as dotted keys, e.g. colors.primary.background.
+// It understands the subset color configs use: tables, dotted keys, quoted strings and comments.
+// Values in arrays of tables are skipped
+func parseTOMLStrings(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	table := ""
+	inArray := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(stripTOMLComment(scanner.Text()))
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[["):
+			inArray = true
+			continue
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: invalid table %s", lineNum, line)
+			}
+			table = unquoteTOMLKey(strings.TrimSpace(line[1 : len(line)-1]))
+			inArray = false
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+		if inArray {
+			continue
+		}
+		key = unquoteTOMLKey(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if len(value) < 2 || (value[0] != '"' && value[0] != '\'') || value[len(value)-1] != value[0] {
+			continue // not a string
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		values[key] = value[1 : len(value)-1]
+	}
+	return values, scanner.Err()
+}
+
+// stripTOMLComment removes a # comment from the line, unless the # is in a string
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquoteTOMLKey removes the quotes and spaces around the parts of a dotted key
+func unquoteTOMLKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+// flattenYAML collects the string values of nested YAML maps as dotted keys
+func flattenYAML(prefix string, value any, values map[string]string) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			flattenYAML(joinKey(prefix, key), child, values)
+		}
+	case map[any]any:
+		for key, child := range v {
+			flattenYAML(joinKey(prefix, fmt.Sprint(key)), child, values)
+		}
+	case string:
+		values[prefix] = v
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// terminalColorKeys are the keys most terminals share, background, foreground and color0-color15,
+// with the format's names of the cursor and selection
+func terminalColorKeys(cursor, selection string) roleKeys {
+	keys := roleKeys{"background": {"background"}, "foreground": {"foreground"}, "cursor": {cursor}, "selection": {selection}}
+	for i := 0; i < 16; i++ {
+		keys[fmt.Sprintf("color%d", i)] = []string{fmt.Sprintf("color%d", i)}
+	}
+	return keys
+}
+
+var kittyNamePattern = regexp.MustCompile(`(?m)^##\s*name:\s*(.+?)\s*$`)
+
+// importKitty reads a kitty theme or kitty.conf, lines of key and value separated by spaces
+func importKitty(name string, data []byte) ([]Theme, error) {
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			values[fields[0]] = fields[1]
+		}
+	}
+
+	if match := kittyNamePattern.FindSubmatch(data); match != nil {
+		name = string(match[1])
+	}
+	theme, err := roleTheme(name, values, terminalColorKeys("cursor", "selection_background"))
+	if err != nil {
+		return nil, err
+	}
+	return []Theme{theme}, nil
+}
+
+// importGhostty reads a Ghostty config or theme, key = value lines with the ANSI colors as palette = N=color
+func importGhostty(name string, data []byte) ([]Theme, error) {
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "palette" {
+			index, paletteColor, ok := strings.Cut(value, "=")
+			if !ok {
+				continue
+			}
+			key, value = "color"+strings.TrimSpace(index), paletteColor
+		}
+		values[key] = value
+	}
+
+	theme, err := roleTheme(name, values, terminalColorKeys("cursor-color", "selection-background"))
+	if err != nil {
+		return nil, err
+	}
+	return []Theme{theme}, nil
+}
+
+var xresourcesDefinePattern = regexp.MustCompile(`^#define\s+(\S+)\s+(\S+)`)
+
+// importXresources reads the colors of an Xresources file, like *.background: #1d1f21 or URxvt*color0: ...
+// Colors defined with #define can be used by name, as base16-xresources does
+func importXresources(name string, data []byte) ([]Theme, error) {
+	defines := make(map[string]string)
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if match := xresourcesDefinePattern.FindStringSubmatch(line); match != nil {
+			defines[match[1]] = match[2]
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		resource, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		// the resource name is the part after the last . or *, e.g. URxvt*color0 is color0
+		resource = strings.TrimSpace(resource)
+		if i := strings.LastIndexAny(resource, ".*"); i >= 0 {
+			resource = resource[i+1:]
+		}
+		value = strings.TrimSpace(value)
+		if defined, ok := defines[value]; ok {
+			value = defined
+		}
+		values[resource] = value
+	}
+
+	theme, err := roleTheme(name, values, terminalColorKeys("cursorColor", "highlightColor"))
+	if err != nil {
+		return nil, err
+	}
+	return []Theme{theme}, nil
+}
+
+// plistNode is an element of an XML property list, dicts have their keys and values as alternating nodes
+type plistNode struct {
+	XMLName xml.Name
+	Content string      `xml:",chardata"`
+	Nodes   []plistNode `xml:",any"`
+}
+
+// dict returns the entries of a dict node
+func (node plistNode) dict() map[string]plistNode {
+	entries := make(map[string]plistNode)
+	for i := 0; i+1 < len(node.Nodes); i += 2 {
+		if node.Nodes[i].XMLName.Local == "key" {
+			entries[node.Nodes[i].Content] = node.Nodes[i+1]
+		}
+	}
+	return entries
+}
+
+// itermColor converts a dict with Red, Green and Blue Components between 0 and 1 to a hex color
+func itermColor(node plistNode) (string, bool) {
+	components := node.dict()
+	var rgb [3]uint8
+	for i, component := range []string{"Red Component", "Green Component", "Blue Component"} {
+		value, ok := components[component]
+		if !ok {
+			return "", false
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value.Content), 64)
+		if err != nil {
+			return "", false
+		}
+		rgb[i] = uint8(math.Round(max(0, min(1, v)) * 255))
+	}
+	return RGBtoHex(color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 255}), true
+}
+
+// importITerm2 reads an iTerm2 .itermcolors property list
+func importITerm2(name string, data []byte) ([]Theme, error) {
+	var plist plistNode
+	if err := xml.Unmarshal(data, &plist); err != nil {
+		return nil, err
+	}
+	if len(plist.Nodes) == 0 || plist.Nodes[0].XMLName.Local != "dict" {
+		return nil, errors.New("the property list has no dict")
+	}
+
+	values := make(map[string]string)
+	for key, node := range plist.Nodes[0].dict() {
+		if hexColor, ok := itermColor(node); ok {
+			values[key] = hexColor
+		}
+	}
+
+	keys := roleKeys{
+		"background": {"Background Color"},
+		"foreground": {"Foreground Color"},
+		"cursor":     {"Cursor Color"},
+		"selection":  {"Selection Color"},
+	}
+	for i := 0; i < 16; i++ {
+		keys[fmt.Sprintf("color%d", i)] = []string{fmt.Sprintf("Ansi %d Color", i)}
+	}
+
+	theme, err := roleTheme(name, values, keys)
+	if err != nil {
+		return nil, err
+	}
+	return []Theme{theme}, nil
+}
+
+// windowsTerminalKeys are the keys of a Windows Terminal scheme, it calls magenta purple
+var windowsTerminalKeys = func() roleKeys {
+	keys := roleKeys{
+		"background": {"background"},
+		"foreground": {"foreground"},
+		"cursor":     {"cursorColor"},
+		"selection":  {"selectionBackground"},
+	}
+	for i, name := range ansiColorNames {
+		if name == "magenta" {
+			name = "purple"
+		}
+		keys[fmt.Sprintf("color%d", i)] = []string{name}
+		keys[fmt.Sprintf("color%d", i+8)] = []string{"bright" + strings.ToUpper(name[:1]) + name[1:]}
+	}
+	return keys
+}()
+
+// importWindowsTerminal reads a Windows Terminal color scheme, or every scheme of a settings.json
+func importWindowsTerminal(name string, data []byte) ([]Theme, error) {
+	var settings struct {
+		Schemes []map[string]any `json:"schemes"`
+	}
+	data = stripJSONComments(data)
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, err
+	}
+	if settings.Schemes == nil {
+		var scheme map[string]any
+		if err := json.Unmarshal(data, &scheme); err != nil {
+			return nil, err
+		}
+		settings.Schemes = []map[string]any{scheme}
+	}
+
+	themes := make([]Theme, 0, len(settings.Schemes))
+	for _, scheme := range settings.Schemes {
+		values := stringValues(scheme)
+		theme, err := roleTheme(firstNonEmpty(values["name"], name), values, windowsTerminalKeys)
+		if err != nil {
+			return nil, err
+		}
+		themes = append(themes, theme)
+	}
+	if len(themes) == 0 {
+		return nil, errors.New("no color schemes found")
+	}
+	return themes, nil
+}
+
+// vscodeKeys are the VS Code colors of the roles. The terminal colors win over the editor ones,
+// which are there for themes that don't set the terminal colors
+var vscodeKeys = func() roleKeys {
+	keys := roleKeys{
+		"background": {"terminal.background", "editor.background"},
+		"foreground": {"terminal.foreground", "editor.foreground"},
+		"cursor":     {"terminalCursor.foreground", "editorCursor.foreground"},
+		"selection":  {"terminal.selectionBackground", "editor.selectionBackground"},
+	}
+	for i, name := range ansiColorNames {
+		name = strings.ToUpper(name[:1]) + name[1:]
+		keys[fmt.Sprintf("color%d", i)] = []string{"terminal.ansi" + name}
+		keys[fmt.Sprintf("color%d", i+8)] = []string{"terminal.ansiBright" + name}
+	}
+	return keys
+}()
+
+// importVSCode reads a VS Code color theme, or the workbench.colorCustomizations of a settings.json
+func importVSCode(name string, data []byte) ([]Theme, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(stripJSONComments(data), &fields); err != nil {
+		return nil, err
+	}
+
+	colors := fields
+	if customizations, ok := fields["workbench.colorCustomizations"].(map[string]any); ok {
+		colors = customizations
+	} else if themeColors, ok := fields["colors"].(map[string]any); ok {
+		colors = themeColors
+		if themeName, ok := fields["name"].(string); ok && themeName != "" {
+			name = themeName
+		}
+	}
+	theme, err := roleTheme(name, stringValues(colors), vscodeKeys)
+	if err != nil {
+		return nil, err
+	}
+	return []Theme{theme}, nil
+}
+
+// stringValues returns the string values of a JSON object
+func stringValues(fields map[string]any) map[string]string {
+	values := make(map[string]string, len(fields))
+	for key, value := range fields {
+		if s, ok := value.(string); ok {
+			values[key] = s
+		}
+	}
+	return values
+}
+
+// stripJSONComments removes the // and /* */ comments and the trailing commas that VS Code
+// and Windows Terminal allow in their settings, so encoding/json can read them
+func stripJSONComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			out = append(out, c)
+			if c == '\\' && i+1 < len(data) {
+				i++
+				out = append(out, data[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return out
+			}
+			i += end + 3
+		case c == '}' || c == ']':
+			// drop a comma before the closing bracket
+			trimmed := bytes.TrimRight(out, " \t\r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				whitespace := append([]byte(nil), out[len(trimmed):]...)
+				out = append(trimmed[:len(trimmed)-1], whitespace...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,269 @@
+package image
+
+import (
+	"image/color"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// importerCases are sample theme files of every format in testdata/themes, with the roles each should have.
+// An empty role is expected to be unset
+var importerCases = []struct {
+	file   string
+	format string
+	name   string
+	colors int // 0 to not check
+	roles  map[string]string
+}{
+	{
+		file: "tomorrow-night.yaml", format: FormatBase16, name: "Tomorrow Night", colors: 16,
+		roles: map[string]string{
+			"background": "#1D1F21", "foreground": "#C5C8C6", "color0": "#1D1F21", "color1": "#CC6666",
+			"color4": "#81A2BE", "color8": "#969896", "color9": "#CC6666", "color15": "#FFFFFF",
+		},
+	},
+	{
+		file: "dracula-base24.yaml", format: FormatBase16, name: "Dracula", colors: 24,
+		roles: map[string]string{
+			"background": "#282A36", "foreground": "#F8F8F2", "color1": "#FF5555",
+			"color9": "#FF6E6E", "color12": "#D6ACFF", "color14": "#A4FFFF",
+		},
+	},
+	{
+		file: "catppuccin_mocha.toml", format: FormatAlacritty, name: "catppuccin_mocha",
+		roles: map[string]string{
+			"background": "#1E1E2E", "foreground": "#CDD6F4", "cursor": "#F5E0DC", "selection": "#F5E0DC",
+			"color0": "#45475A", "color1": "#F38BA8", "color8": "#585B70", "color15": "#A6ADC8",
+		},
+	},
+	{
+		file: "gruvbox_dark.yml", format: FormatAlacritty, name: "gruvbox_dark",
+		roles: map[string]string{
+			"background": "#282828", "foreground": "#EBDBB2", "cursor": "", "color1": "#CC241D",
+			"color8": "#928374", "color15": "#EBDBB2",
+		},
+	},
+	{
+		file: "nord.conf", format: FormatKitty, name: "Nord",
+		roles: map[string]string{
+			"background": "#2E3440", "foreground": "#D8DEE9", "cursor": "#81A1C1", "selection": "#FFFACD",
+			"color0": "#3B4252", "color6": "#88C0D0", "color14": "#8FBCBB",
+		},
+	},
+	{
+		file: "solarized.Xresources", format: FormatXresources, name: "solarized",
+		roles: map[string]string{
+			"background": "#002B36", "foreground": "#839496", "cursor": "#93A1A1", "selection": "",
+			"color0": "#073642", "color7": "#EEE8D5", "color11": "#657B83", "color15": "#FDF6E3",
+		},
+	},
+	{
+		file: "Tango Dark.itermcolors", format: FormatITerm2, name: "Tango Dark",
+		roles: map[string]string{
+			"background": "#171717", "foreground": "#D3D7CF", "cursor": "",
+			"color0": "#000000", "color1": "#CC0000", "color4": "#3465A4",
+		},
+	},
+	{
+		file: "ubuntu-scheme.json", format: FormatWindowsTerminal, name: "Ubuntu",
+		roles: map[string]string{
+			"background": "#300A24", "foreground": "#EEEEEC", "color5": "#75507B", "color13": "#AD7FA8",
+		},
+	},
+	{
+		file: "tokyonight", format: FormatGhostty, name: "tokyonight",
+		roles: map[string]string{
+			"background": "#1A1B26", "foreground": "#C0CAF5", "cursor": "#C0CAF5", "selection": "#283457",
+			"color0": "#15161E", "color8": "#414868", "color15": "#C0CAF5",
+		},
+	},
+	{
+		file: "monokai-pro-color-theme.json", format: FormatVSCode, name: "Monokai Pro",
+		roles: map[string]string{
+			"background": "#2D2A2E", "foreground": "#FCFCFA", "color1": "#FF6188", "color4": "#FC9867", "color0": "",
+		},
+	},
+	{
+		file: filepath.Join("vscode", "settings.json"), format: FormatVSCode, name: "settings",
+		roles: map[string]string{
+			"background": "#1F1F1F", "foreground": "#CCCCCC", "cursor": "#AEAFAD", "selection": "#264F78",
+			"color0": "#000000", "color1": "#CD3131", "color15": "#E5E5E5",
+		},
+	},
+}
+
+func testThemePath(file string) string {
+	return filepath.Join("testdata", "themes", file)
+}
+
+// checkRoles compares the roles of the theme with the expected hex colors
+func checkRoles(t *testing.T, theme Theme, roles map[string]string) {
+	t.Helper()
+	for role, want := range roles {
+		got := theme.Roles.Get(role)
+		switch {
+		case want == "" && got != nil:
+			t.Errorf("%s: %s = %s, want it unset", theme.Name, role, hexOf(got))
+		case want != "" && got == nil:
+			t.Errorf("%s: %s is unset, want %s", theme.Name, role, want)
+		case want != "" && hexOf(got) != want:
+			t.Errorf("%s: %s = %s, want %s", theme.Name, role, hexOf(got), want)
+		}
+	}
+}
+
+func TestImportThemeFiles(t *testing.T) {
+	for _, tc := range importerCases {
+		t.Run(tc.file, func(t *testing.T) {
+			path := testThemePath(tc.file)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format := detectThemeFormat(path, data); format != tc.format {
+				t.Fatalf("detected format %q, want %q", format, tc.format)
+			}
+
+			themes, err := ParseThemeFiles(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(themes) != 1 {
+				t.Fatalf("got %d themes, want 1", len(themes))
+			}
+			theme := themes[0]
+			if theme.Name != tc.name {
+				t.Errorf("name %q, want %q", theme.Name, tc.name)
+			}
+			if tc.colors != 0 && len(theme.Colors) != tc.colors {
+				t.Errorf("%d colors, want %d", len(theme.Colors), tc.colors)
+			}
+			checkRoles(t, theme, tc.roles)
+
+			// every role color is one of the theme colors, so conversions use them
+			palette := make(map[string]bool)
+			for _, c := range theme.Colors {
+				palette[hexOf(c)] = true
+			}
+			for _, role := range RoleNames() {
+				if c := theme.Roles.Get(role); c != nil && !palette[hexOf(c)] {
+					t.Errorf("%s color %s is not in the theme colors", role, hexOf(c))
+				}
+			}
+		})
+	}
+}
+
+func TestImportAlacrittySkipsIndexedColors(t *testing.T) {
+	theme, err := ParseThemeFile(testThemePath("catppuccin_mocha.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range theme.Colors {
+		if hexOf(c) == "#FAB387" {
+			t.Fatal("the indexed_colors array was imported")
+		}
+	}
+}
+
+func TestImportWindowsTerminalSettings(t *testing.T) {
+	path := testThemePath("settings.json")
+	themes, err := ParseThemeFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(themes) != 2 {
+		t.Fatalf("got %d themes, want one per scheme", len(themes))
+	}
+	if themes[0].Name != "Campbell" || themes[1].Name != "One Half Dark" {
+		t.Errorf("names %q and %q, want Campbell and One Half Dark", themes[0].Name, themes[1].Name)
+	}
+	checkRoles(t, themes[0], map[string]string{"background": "#0C0C0C", "cursor": "#FFFFFF", "color12": "#3B78FF"})
+	checkRoles(t, themes[1], map[string]string{"background": "#282C34", "cursor": "", "color1": "#E06C75"})
+
+	// a single theme is expected from a path, the schemes have to be picked by name from a theme directory
+	if _, err := ParseThemeFile(path); err == nil {
+		t.Error("ParseThemeFile of a settings.json with 2 schemes succeeded")
+	}
+}
+
+// convert --theme path/to/file goes through SelectTheme
+func TestSelectThemeFromPath(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	for _, tc := range importerCases {
+		t.Run(tc.file, func(t *testing.T) {
+			theme, err := SelectTheme(testThemePath(tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if theme.Name != tc.name {
+				t.Errorf("name %q, want %q", theme.Name, tc.name)
+			}
+			checkRoles(t, theme, tc.roles)
+
+			parsed, err := ParseThemeFile(testThemePath(tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if themeHash(theme) != themeHash(parsed) {
+				t.Error("the selected theme differs from the parsed one")
+			}
+		})
+	}
+
+	if _, err := SelectTheme(testThemePath("settings.json")); err == nil {
+		t.Error("selecting a settings.json with 2 schemes by path succeeded")
+	}
+}
+
+func TestParseColorValue(t *testing.T) {
+	for value, want := range map[string]color.RGBA{
+		"#1d1f21":         {0x1D, 0x1F, 0x21, 0xFF},
+		"#abc":            {0xAA, 0xBB, 0xCC, 0xFF},
+		"#aeafad80":       {0xAE, 0xAF, 0xAD, 0xFF},
+		"0x282828":        {0x28, 0x28, 0x28, 0xFF},
+		"c5c8c6":          {0xC5, 0xC8, 0xC6, 0xFF},
+		"rgb:ff/80/00":    {0xFF, 0x80, 0x00, 0xFF},
+		" '#FFFFFF' ":     {0xFF, 0xFF, 0xFF, 0xFF},
+		"rgb:ffff/0/8080": {0xFF, 0x00, 0x80, 0xFF},
+	} {
+		got, err := parseColorValue(value)
+		if err != nil {
+			t.Errorf("parseColorValue(%q): %v", value, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("parseColorValue(%q) = %v, want %v", value, got, want)
+		}
+	}
+
+	for _, value := range []string{"CellForeground", "", "#12345", "rgb:zz/00/00"} {
+		if _, err := parseColorValue(value); err == nil {
+			t.Errorf("parseColorValue(%q) succeeded", value)
+		}
+	}
+}
+
+// batch workers select the same path themes at once, run with -race
+func TestSelectThemeConcurrently(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, tc := range importerCases {
+				if _, err := SelectTheme(testThemePath(tc.file)); err != nil {
+					t.Error(err)
+				}
+				paletteHash(&ThemeConverter{}, testThemePath(tc.file))
+			}
+			ListThemes()
+		}(i)
+	}
+	wg.Wait()
+}
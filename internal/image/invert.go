@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"errors"
 	"image"
 	"image/color"
@@ -9,7 +10,7 @@ import (
 type Inverter struct {
 }
 
-func (Invrt *Inverter) Process(img image.Image, theme string) (image.Image, error) {
+func (Invrt *Inverter) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	newImg, err := invertImage(img)
 
@@ -22,7 +23,7 @@ func (Invrt *Inverter) Process(img image.Image, theme string) (image.Image, erro
 
 func invertImage(img image.Image) (image.Image, error) {
 	bounds := img.Bounds()
-	newImg := image.NewRGBA(bounds)
+	newImg := image.NewNRGBA(bounds)
 
 	// replace each pixel with the inverted ones
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
@@ -41,15 +42,15 @@ func invertImage(img image.Image) (image.Image, error) {
 
 }
 
-// You can invert a color
+// You can invert a color, the straight color is inverted and the alpha kept
 func invertColor(clr color.Color) color.Color {
-	r, g, b, a := clr.RGBA()
+	c := color.NRGBAModel.Convert(clr).(color.NRGBA)
 
-	return color.RGBA{
-		R: uint8(255 - r/257),
-		G: uint8(255 - g/257),
-		B: uint8(255 - b/257),
-		A: uint8(a / 257),
+	return color.NRGBA{
+		R: 255 - c.R,
+		G: 255 - c.G,
+		B: 255 - c.B,
+		A: c.A,
 	}
 
 }
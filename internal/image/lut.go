@@ -0,0 +1,122 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	haldclut "github.com/Achno/gowall/internal/backends/colorthief/haldClut"
+	"github.com/Achno/gowall/utils"
+)
+
+// Formats a theme's CLUT can be exported to
+const (
+	LUTFormatCube = "cube" // Adobe/Resolve .cube 3D LUT
+	LUTFormatHald = "hald" // HaldCLUT .png
+)
+
+// LUTProcessor applies a .cube 3D LUT or a HaldCLUT .png to the image, the theme is ignored.
+// The LUT is loaded once and reused for every image of a batch
+type LUTProcessor struct {
+	Path          string
+	Interpolation string // defaults to CLUTInterpolation of the config or nearest
+
+	once  sync.Once
+	clut  *image.RGBA
+	level int
+	err   error
+}
+
+// Validate checks that the LUT can be loaded
+func (p *LUTProcessor) Validate(theme string) error {
+	if err := ValidateInterpolation(p.Interpolation); err != nil {
+		return err
+	}
+	_, _, err := p.load()
+	return err
+}
+
+func (p *LUTProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
+	interpolation := clutInterpolation(p.Interpolation)
+	if err := ValidateInterpolation(interpolation); err != nil {
+		return nil, err
+	}
+
+	clut, level, err := p.load()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return ApplyThemeCLUT(img, clut, level, interpolation, ToneOptions{}), nil
+}
+
+// load reads the LUT on first use, a .cube LUT is resampled into a HaldCLUT
+func (p *LUTProcessor) load() (*image.RGBA, int, error) {
+	p.once.Do(func() {
+		path := utils.ExpandHomeDirectory([]string{p.Path})[0]
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".cube":
+			lut, err := haldclut.LoadCube(path)
+			if err != nil {
+				p.err = fmt.Errorf("while loading LUT: %w", err)
+				return
+			}
+			p.clut, p.level, p.err = haldclut.CubeToHald(lut)
+
+		case ".png":
+			clut, err := haldclut.LoadHaldCLUT(path)
+			if err != nil {
+				p.err = fmt.Errorf("while loading HaldCLUT: %w", err)
+				return
+			}
+			level, err := haldclut.HaldLevel(clut)
+			if err != nil {
+				p.err = fmt.Errorf("%w: %s: %v", utils.ErrDecode, path, err)
+				return
+			}
+			p.clut, p.level = clut, level
+
+		default:
+			p.err = fmt.Errorf("%w: LUT %s, use a .cube or a HaldCLUT .png", utils.ErrUnsupportedFormat, path)
+		}
+	})
+	return p.clut, p.level, p.err
+}
+
+// ExportThemeLUT writes the CLUT of the theme, generated with the converter's level and mapper,
+// to path as a .cube LUT or a HaldCLUT .png
+func ExportThemeLUT(ctx context.Context, theme string, converter *ThemeConverter, format, path string) error {
+	clut, level, err := converter.CLUT(ctx, theme)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, dirPermissions); err != nil {
+			return fmt.Errorf("while creating directory: %w", err)
+		}
+	}
+
+	switch strings.ToLower(format) {
+	case LUTFormatCube:
+		lut := haldclut.HaldToCube(clut, level, fmt.Sprintf("gowall %s", theme))
+		if err := haldclut.SaveCube(lut, path); err != nil {
+			return fmt.Errorf("while saving LUT: %w", err)
+		}
+	case LUTFormatHald:
+		if err := haldclut.SaveHaldCLUT(clut, path); err != nil {
+			return fmt.Errorf("while saving HaldCLUT: %w", err)
+		}
+	default:
+		return utils.InvalidParameter("unknown LUT format: %s (available: %s, %s)", format, LUTFormatCube, LUTFormatHald)
+	}
+	return nil
+}
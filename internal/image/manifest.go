@@ -0,0 +1,341 @@
+package image
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Achno/gowall/config"
+	"github.com/Achno/gowall/utils"
+)
+
+const (
+	ManifestFile    = ".gowall-manifest.json" // saved in the output folder, next to the outputs
+	manifestVersion = 1
+
+	// manifestSaveInterval is how often Complete saves the manifest, rewriting it after every image of a large
+	// batch would take longer than processing them. An unsaved input stays pending and is processed again on resume
+	manifestSaveInterval = 2 * time.Second
+)
+
+// ManifestEntry records how an output was produced, so later batch runs can skip inputs that didn't change
+type ManifestEntry struct {
+	Input       string    `json:"input"`
+	InputHash   string    `json:"input_hash"`
+	Processor   string    `json:"processor"`
+	Theme       string    `json:"theme,omitempty"`
+	Params      string    `json:"params"`
+	PaletteHash string    `json:"palette_hash,omitempty"`
+	Output      string    `json:"output"`
+	Updated     time.Time `json:"updated"`
+}
+
+// ManifestRun is the batch run in progress. It is removed once the run finishes without errors,
+// so when it is still there the run was interrupted and Pending lists the inputs left to process
+type ManifestRun struct {
+	Started   time.Time `json:"started"`
+	Processor string    `json:"processor"`
+	Theme     string    `json:"theme,omitempty"`
+	Pending   []string  `json:"pending"`
+}
+
+// Manifest is the record of every batch output in an output folder
+type Manifest struct {
+	Version int                      `json:"version"`
+	Entries map[string]ManifestEntry `json:"entries"`
+	Run     *ManifestRun             `json:"run,omitempty"`
+
+	path     string
+	mu       sync.Mutex
+	dirty    bool // changed since the last save
+	lastSave time.Time
+}
+
+// LoadManifest reads the manifest of the output folder dir. A missing manifest is not an error,
+// an empty one is returned instead
+func LoadManifest(dir string) (*Manifest, error) {
+	m := &Manifest{
+		Version: manifestVersion,
+		Entries: make(map[string]ManifestEntry),
+		path:    filepath.Join(dir, ManifestFile),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("while parsing manifest %s: %w", m.path, err)
+	}
+	if m.Entries == nil {
+		m.Entries = make(map[string]ManifestEntry)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to a temporary file and renames it, so an interrupted save never corrupts it
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.save()
+}
+
+// Flush saves the changes Complete hasn't saved yet, e.g. when the run is interrupted
+func (m *Manifest) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	return m.save()
+}
+
+func (m *Manifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("while encoding manifest: %w", err)
+	}
+
+	err = utils.WriteFileAtomic(m.path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("while writing manifest: %w", err)
+	}
+	m.dirty = false
+	m.lastSave = time.Now()
+	return nil
+}
+
+// Lookup returns the output recorded for the entry when the input, processor, parameters and palette
+// are all unchanged and the output still exists
+func (m *Manifest) Lookup(entry ManifestEntry) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	recorded, ok := m.Entries[manifestKey(entry)]
+	if !ok {
+		return "", false
+	}
+	if recorded.InputHash != entry.InputHash || recorded.Params != entry.Params || recorded.PaletteHash != entry.PaletteHash {
+		return "", false
+	}
+	if _, err := os.Stat(recorded.Output); err != nil {
+		return "", false
+	}
+	return recorded.Output, true
+}
+
+// StartRun records the inputs of a batch run before any of them are processed
+func (m *Manifest) StartRun(processor, theme string, files []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Run = &ManifestRun{
+		Started:   time.Now(),
+		Processor: processor,
+		Theme:     theme,
+	}
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		m.Run.Pending = append(m.Run.Pending, file)
+	}
+	return m.save()
+}
+
+// Complete records a finished input and removes it from the pending inputs of the run.
+// The manifest is saved at most every manifestSaveInterval, FinishRun or Flush save the rest
+func (m *Manifest) Complete(entry ManifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.Updated = time.Now()
+	m.Entries[manifestKey(entry)] = entry
+
+	if m.Run != nil {
+		for i, file := range m.Run.Pending {
+			if file == entry.Input {
+				m.Run.Pending = append(m.Run.Pending[:i], m.Run.Pending[i+1:]...)
+				break
+			}
+		}
+	}
+
+	m.dirty = true
+	if time.Since(m.lastSave) < manifestSaveInterval {
+		return nil
+	}
+	return m.save()
+}
+
+// FinishRun removes the run once every input was processed
+func (m *Manifest) FinishRun() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Run = nil
+	return m.save()
+}
+
+// resumeInputs returns the inputs the interrupted run didn't finish. When files are given only
+// those among them are returned. It fails when there is no interrupted run or it used another processor
+func (m *Manifest) resumeInputs(processor string, files []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Run == nil || len(m.Run.Pending) == 0 {
+		return nil, fmt.Errorf("there is no interrupted batch run to resume in %s", filepath.Dir(m.path))
+	}
+	if m.Run.Processor != processor {
+		return nil, fmt.Errorf("the interrupted batch run used %s, not %s", m.Run.Processor, processor)
+	}
+	if len(files) == 0 {
+		return append([]string(nil), m.Run.Pending...), nil
+	}
+
+	pending := make(map[string]bool, len(m.Run.Pending))
+	for _, file := range m.Run.Pending {
+		pending[file] = true
+	}
+	var remaining []string
+	for _, file := range files {
+		if abs, err := filepath.Abs(file); err == nil && pending[abs] {
+			remaining = append(remaining, file)
+		}
+	}
+	return remaining, nil
+}
+
+// manifestKey identifies an output by its input, processor and theme. Changing the parameters or
+// editing the theme replaces the entry, while using another processor or theme adds a new one
+func manifestKey(entry ManifestEntry) string {
+	return entry.Input + "|" + entry.Processor + "|" + strings.ToLower(entry.Theme)
+}
+
+// newManifestEntry describes the output of an input before it is processed. The output is filled in once saved
+func newManifestEntry(file string, processor ImageProcessor, theme string, options ProcessOptions) (ManifestEntry, error) {
+	input, err := filepath.Abs(file)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+
+	inputHash, err := hashFile(input)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+
+	return ManifestEntry{
+		Input:       input,
+		InputHash:   inputHash,
+		Processor:   processorName(processor),
+		Theme:       theme,
+		Params:      processorParams(processor, options),
+		PaletteHash: paletteHash(processor, theme),
+	}, nil
+}
+
+// processorName returns the type name of the processor, e.g. "ThemeConverter"
+func processorName(processor ImageProcessor) string {
+	t := reflect.TypeOf(processor)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	return t.Name()
+}
+
+// processorParams serializes the exported fields of the processor together with the options
+// that change the output, so any change to them causes the image to be processed again
+func processorParams(processor ImageProcessor, options ProcessOptions) string {
+	params, err := json.Marshal(processor)
+	if err != nil {
+		params = []byte(fmt.Sprintf("%+v", processor))
+	}
+	cfg := config.GowallConfig
+	return fmt.Sprintf("%s format=%s name=%s dir=%s backend=%s metric=%s level=%d interp=%s mapper=%s:%g:%g:%d", params,
+		options.OutputExt, options.NameTemplate, options.OutputDir, cfg.ColorCorrectionBackend, cfg.ColorDistanceMetric,
+		cfg.CLUTLevel, cfg.CLUTInterpolation, cfg.CLUTMapper, cfg.MapperSigma, cfg.MapperPower, cfg.MapperNeighbours)
+}
+
+// paletteHash hashes the colors of every theme the processor uses, like the CLUT cache does with
+// hashPalette, so editing a theme invalidates the outputs made with it. Theme and LUT files given by
+// path are hashed by their content
+func paletteHash(processor ImageProcessor, theme string) string {
+	themes := []string{theme}
+	if pipeline, ok := processor.(*PipelineProcessor); ok {
+		for _, step := range pipeline.Steps {
+			if step.Theme != "" {
+				themes = append(themes, step.Theme)
+			}
+		}
+	}
+
+	var colors []string
+	if lut, ok := processor.(*LUTProcessor); ok {
+		// the LUT replaces the theme, so a changed LUT file has to invalidate the outputs too
+		if hash, err := hashFile(utils.ExpandHomeDirectory([]string{lut.Path})[0]); err == nil {
+			colors = append(colors, "lut:"+hash)
+		}
+	}
+	for _, name := range themes {
+		if name == "" {
+			continue
+		}
+		if path := utils.ExpandHomeDirectory([]string{name})[0]; isThemeFile(path) {
+			if hash, err := hashFile(path); err == nil {
+				colors = append(colors, "theme:"+hash)
+				continue
+			}
+		}
+		selected, err := SelectTheme(name)
+		if err != nil {
+			continue
+		}
+		themeColors, err := GetThemeColors(name)
+		if err != nil {
+			continue
+		}
+		sorted := append([]string(nil), themeColors...)
+		sort.Strings(sorted)
+		colors = append(colors, strings.ToLower(name)+":"+strings.Join(sorted, ",")+weightKey(selected))
+	}
+
+	if len(colors) == 0 {
+		return ""
+	}
+	return hashPalette(colors)
+}
+
+// hashFile returns the md5 of the file's content
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
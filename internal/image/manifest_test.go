@@ -0,0 +1,86 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestCompleteBatchesSaves(t *testing.T) {
+	dir := t.TempDir()
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{"/in/a.png", "/in/b.png", "/in/c.png"}
+	if err := m.StartRun("Inverter", "", files); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := func() int {
+		t.Helper()
+		saved, err := LoadManifest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Run == nil {
+			return -1
+		}
+		return len(saved.Run.Pending)
+	}
+
+	// right after StartRun saved, completions are kept in memory
+	for _, file := range files[:2] {
+		if err := m.Complete(ManifestEntry{Input: file, Processor: "Inverter", Output: file + ".out"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := pending(); got != 3 {
+		t.Fatalf("%d pending inputs saved before the flush, want the 3 of StartRun", got)
+	}
+
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := pending(); got != 1 {
+		t.Fatalf("%d pending inputs saved after the flush, want 1", got)
+	}
+
+	if err := m.FinishRun(); err != nil {
+		t.Fatal(err)
+	}
+	if got := pending(); got != -1 {
+		t.Fatalf("the run is still in the manifest after FinishRun")
+	}
+	saved, _ := LoadManifest(dir)
+	if len(saved.Entries) != 2 {
+		t.Errorf("%d entries saved, want 2", len(saved.Entries))
+	}
+}
+
+func TestPaletteHashOfThemeFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	for _, file := range []string{"custom.conf", "custom.el"} {
+		path := filepath.Join(t.TempDir(), file)
+		write := func(background string) {
+			content := "background " + background + "\nforeground #D8DEE9\ncolor1 #BF616A\n"
+			if filepath.Ext(file) == ".el" {
+				content = "(deftheme custom)\n(custom-theme-set-faces 'custom '(default ((t (:background \"" + background + "\")))))\n"
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		write("#2E3440")
+		before := paletteHash(&ThemeConverter{}, path)
+		if before == "" {
+			t.Fatalf("%s: no palette hash for a theme file", file)
+		}
+		write("#000000")
+		if after := paletteHash(&ThemeConverter{}, path); after == before {
+			t.Errorf("%s: editing the theme file didn't change the palette hash", file)
+		}
+	}
+}
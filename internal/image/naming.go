@@ -0,0 +1,182 @@
+package image
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Achno/gowall/utils"
+)
+
+// Policies for when the output file already exists
+const (
+	CollisionOverwrite = "overwrite" // replace the existing file (default)
+	CollisionSkip      = "skip"      // keep the existing file and don't process the image
+	CollisionSuffix    = "suffix"    // save as name-1.ext, name-2.ext ...
+	CollisionError     = "error"     // fail with an error
+)
+
+// ErrOutputExists is returned with the "error" collision policy when the output file already exists
+var ErrOutputExists = utils.ErrOutputExists
+
+// nameData holds the values of the placeholders of a name template
+type nameData struct {
+	Name  string // base name of the input without extension
+	Theme string
+	Op    string
+	W, H  int
+	hash  func() string // computed lazily, hashing the input is only needed for {hash}
+}
+
+var placeholderRegex = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// renderNameTemplate fills the placeholders {name} {theme} {op} {date} {w} {h} {hash} of the template
+//
+//	Example "{name}-{theme}-{w}x{h}" --> "forest-nord-1920x1080"
+func renderNameTemplate(tmpl string, data nameData) (string, error) {
+	var unknown []string
+
+	name := placeholderRegex.ReplaceAllStringFunc(tmpl, func(match string) string {
+		switch match[1 : len(match)-1] {
+		case "name":
+			return data.Name
+		case "theme":
+			return sanitizeNamePart(data.Theme)
+		case "op":
+			return data.Op
+		case "date":
+			return time.Now().Format("20060102-150405")
+		case "w":
+			return fmt.Sprint(data.W)
+		case "h":
+			return fmt.Sprint(data.H)
+		case "hash":
+			if data.hash == nil {
+				return ""
+			}
+			return data.hash()
+		default:
+			unknown = append(unknown, match)
+			return match
+		}
+	})
+
+	if len(unknown) > 0 {
+		return "", utils.InvalidParameter("unknown placeholder(s) in name template: %s (available: {name} {theme} {op} {date} {w} {h} {hash})", strings.Join(unknown, ", "))
+	}
+	if strings.TrimSpace(name) == "" {
+		return "", utils.InvalidParameter("name template %q results in an empty name", tmpl)
+	}
+	return name, nil
+}
+
+// ValidateNameTemplate checks the template for unknown placeholders without rendering it
+func ValidateNameTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	_, err := renderNameTemplate(tmpl, nameData{Name: "x"})
+	return err
+}
+
+// ValidateCollisionPolicy checks that the policy is one of overwrite, skip, suffix or error
+func ValidateCollisionPolicy(policy string) error {
+	switch policy {
+	case "", CollisionOverwrite, CollisionSkip, CollisionSuffix, CollisionError:
+		return nil
+	default:
+		return utils.InvalidParameter("unknown collision policy: %s (available: overwrite, skip, suffix, error)", policy)
+	}
+}
+
+// sanitizeNamePart turns a theme name or theme file path into something usable inside a file name
+func sanitizeNamePart(s string) string {
+	if isLikelyPath(s) {
+		s = strings.TrimSuffix(filepath.Base(s), filepath.Ext(s))
+	}
+	return strings.NewReplacer("/", "-", "\\", "-", " ", "-").Replace(strings.ToLower(s))
+}
+
+// claimedPaths are the output paths already picked by this process, so concurrent batch
+// workers using the "suffix" policy never pick the same name
+var (
+	claimedMu    sync.Mutex
+	claimedPaths = make(map[string]bool)
+)
+
+// resolveCollision applies the collision policy to the output path.
+// It returns the path to write to, or skip=true when the image should not be processed at all
+func resolveCollision(outputPath, policy string) (path string, skip bool, err error) {
+	if outputPath == StdioPath {
+		return outputPath, false, nil
+	}
+
+	claimedMu.Lock()
+	defer claimedMu.Unlock()
+
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil || claimedPaths[p]
+	}
+
+	switch policy {
+	case "", CollisionOverwrite:
+		path = outputPath
+
+	case CollisionSkip:
+		if exists(outputPath) {
+			return outputPath, true, nil
+		}
+		path = outputPath
+
+	case CollisionError:
+		if exists(outputPath) {
+			return "", false, fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+		}
+		path = outputPath
+
+	case CollisionSuffix:
+		ext := filepath.Ext(outputPath)
+		base := strings.TrimSuffix(outputPath, ext)
+		path = outputPath
+		for i := 1; exists(path); i++ {
+			path = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+
+	default:
+		return "", false, ValidateCollisionPolicy(policy)
+	}
+
+	claimedPaths[path] = true
+	return path, false, nil
+}
+
+// hashInput returns a short hash of the input file's content, or of the decoded pixels for stdin
+func hashInput(imgPath string, img image.Image) string {
+	hasher := md5.New()
+
+	if imgPath != StdioPath {
+		if file, err := os.Open(imgPath); err == nil {
+			defer file.Close()
+			if _, err := io.Copy(hasher, file); err == nil {
+				return hex.EncodeToString(hasher.Sum(nil))[:HashLength/2]
+			}
+			hasher.Reset()
+		}
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	hasher.Write(rgba.Pix)
+	return hex.EncodeToString(hasher.Sum(nil))[:HashLength/2]
+}
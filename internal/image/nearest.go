@@ -0,0 +1,235 @@
+package image
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// kdTree finds the nearest palette color in O(log n) for the metrics that are euclidean in their color space
+type kdTree struct {
+	nodes []kdNode
+	root  int
+}
+
+type kdNode struct {
+	point       colorPoint
+	index       int // of the color in the palette
+	axis        int
+	left, right int // -1 when there is no child
+}
+
+func newKDTree(points []colorPoint) *kdTree {
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+
+	t := &kdTree{nodes: make([]kdNode, 0, len(points))}
+	t.root = t.build(points, order)
+	return t
+}
+
+// build splits the points at the median of the axis they are spread the most along
+func (t *kdTree) build(points []colorPoint, order []int) int {
+	if len(order) == 0 {
+		return -1
+	}
+
+	axis := 0
+	widest := -1.0
+	for a := 0; a < 3; a++ {
+		lo, hi := math.MaxFloat64, -math.MaxFloat64
+		for _, i := range order {
+			lo, hi = math.Min(lo, points[i][a]), math.Max(hi, points[i][a])
+		}
+		if hi-lo > widest {
+			axis, widest = a, hi-lo
+		}
+	}
+
+	sort.Slice(order, func(i, j int) bool { return points[order[i]][axis] < points[order[j]][axis] })
+	median := len(order) / 2
+
+	node := len(t.nodes)
+	t.nodes = append(t.nodes, kdNode{point: points[order[median]], index: order[median], axis: axis})
+	left := t.build(points, order[:median])
+	right := t.build(points, order[median+1:])
+	t.nodes[node].left, t.nodes[node].right = left, right
+	return node
+}
+
+// nearest returns the palette index of the closest point. Ties go to the lowest index, like a linear scan
+func (t *kdTree) nearest(p colorPoint) int {
+	best, bestDist := -1, math.MaxFloat64
+	t.search(t.root, p, &best, &bestDist)
+	return best
+}
+
+func (t *kdTree) search(n int, p colorPoint, best *int, bestDist *float64) {
+	if n < 0 {
+		return
+	}
+	node := &t.nodes[n]
+
+	d := squaredEuclidean(p, node.point)
+	if d < *bestDist || (d == *bestDist && node.index < *best) {
+		*best, *bestDist = node.index, d
+	}
+
+	diff := p[node.axis] - node.point[node.axis]
+	near, far := node.left, node.right
+	if diff >= 0 {
+		near, far = node.right, node.left
+	}
+
+	t.search(near, p, best, bestDist)
+	// the other side can only hold a closer point if the splitting plane is closer than the best so far
+	if diff*diff <= *bestDist {
+		t.search(far, p, best, bestDist)
+	}
+}
+
+const nearestCacheBits = 16
+
+// nearestCache remembers the nearest palette color of recently seen colors. Wallpapers reuse the same
+// colors a lot, so most pixels skip the color space conversion and the search altogether.
+// It is not safe for concurrent use, every goroutine gets its own
+type nearestCache struct {
+	matcher *paletteMatcher
+	keys    []uint32 // the color with bit 24 set, 0 for an empty slot
+	indices []int32
+}
+
+func newNearestCache(matcher *paletteMatcher) *nearestCache {
+	return &nearestCache{
+		matcher: matcher,
+		keys:    make([]uint32, 1<<nearestCacheBits),
+		indices: make([]int32, 1<<nearestCacheBits),
+	}
+}
+
+func (c *nearestCache) nearest(r, g, b uint8) int {
+	key := 1<<24 | uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+	// fibonacci hashing spreads neighbouring colors over the whole table
+	slot := (key * 2654435769) >> (32 - nearestCacheBits)
+
+	if c.keys[slot] == key {
+		return int(c.indices[slot])
+	}
+
+	index := c.matcher.nearest(r, g, b)
+	c.keys[slot], c.indices[slot] = key, int32(index)
+	return index
+}
+
+// pixelReader reads 8 bit straight, not premultiplied, RGBA from the pixel slice of *image.NRGBA and *image.RGBA,
+// so translucent pixels are matched by their color and not by a color darkened by their alpha.
+// Other images are converted to *image.NRGBA first
+type pixelReader struct {
+	pix           []uint8
+	stride        int
+	rect          image.Rectangle
+	premultiplied bool // the pixels are RGBA
+}
+
+func newPixelReader(img image.Image) pixelReader {
+	switch src := img.(type) {
+	case *image.NRGBA:
+		return pixelReader{pix: src.Pix, stride: src.Stride, rect: src.Rect}
+	case *image.RGBA:
+		return pixelReader{pix: src.Pix, stride: src.Stride, rect: src.Rect, premultiplied: true}
+	default:
+		bounds := img.Bounds()
+		nrgba := image.NewNRGBA(bounds)
+		draw.Draw(nrgba, bounds, img, bounds.Min, draw.Src)
+		return pixelReader{pix: nrgba.Pix, stride: nrgba.Stride, rect: nrgba.Rect}
+	}
+}
+
+func (p pixelReader) nrgba(x, y int) (r, g, b, a uint8) {
+	i := (y-p.rect.Min.Y)*p.stride + (x-p.rect.Min.X)*4
+	s := p.pix[i : i+4 : i+4]
+	if !p.premultiplied || s[3] == 0xff {
+		return s[0], s[1], s[2], s[3]
+	}
+	if s[3] == 0 {
+		return 0, 0, 0, 0
+	}
+	return unpremultiply(s[0], s[3]), unpremultiply(s[1], s[3]), unpremultiply(s[2], s[3]), s[3]
+}
+
+// unpremultiply returns the straight value of a premultiplied channel, rounded
+func unpremultiply(c, a uint8) uint8 {
+	return uint8(min(255, (uint32(c)*255+uint32(a)/2)/uint32(a)))
+}
+
+// paletteRGBA returns the colors of the palette as 8 bit RGBA, ready to be copied into a pixel slice
+func paletteRGBA(matcher *paletteMatcher) [][4]uint8 {
+	colors := make([][4]uint8, len(matcher.colors))
+	for i, c := range matcher.colors {
+		r, g, b, a := c.RGBA()
+		colors[i] = [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+	}
+	return colors
+}
+
+// mapNearest replaces every pixel with the nearest palette color, keeping its alpha, working on bands of rows in parallel.
+// The bands stop at the next row once ctx is cancelled
+func mapNearest(ctx context.Context, img image.Image, matcher *paletteMatcher) (*image.NRGBA, error) {
+	src := newPixelReader(img)
+	bounds := img.Bounds()
+	newImg := image.NewNRGBA(bounds)
+	palette := paletteRGBA(matcher)
+
+	parallelRows(bounds, func(minY, maxY int) {
+		cache := newNearestCache(matcher)
+		for y := minY; y < maxY; y++ {
+			if ctx.Err() != nil {
+				return
+			}
+			row := newImg.Pix[(y-bounds.Min.Y)*newImg.Stride:]
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, a := src.nrgba(x, y)
+				setNRGBA(row[(x-bounds.Min.X)*4:], palette[cache.nearest(r, g, b)], a)
+			}
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return newImg, nil
+}
+
+// setNRGBA writes the palette color with the alpha to the first 4 bytes of pix
+func setNRGBA(pix []uint8, c [4]uint8, alpha uint8) {
+	pix[0], pix[1], pix[2], pix[3] = c[0], c[1], c[2], alpha
+}
+
+// parallelRows splits the rows of bounds in one band per CPU and calls fn for every band concurrently
+func parallelRows(bounds image.Rectangle, fn func(minY, maxY int)) {
+	workers := min(runtime.GOMAXPROCS(0), bounds.Dy())
+	if workers <= 1 {
+		fn(bounds.Min.Y, bounds.Max.Y)
+		return
+	}
+
+	band := (bounds.Dy() + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for minY := bounds.Min.Y; minY < bounds.Max.Y; minY += band {
+		maxY := min(minY+band, bounds.Max.Y)
+
+		wg.Add(1)
+		go func(minY, maxY int) {
+			defer wg.Done()
+			fn(minY, maxY)
+		}(minY, maxY)
+	}
+	wg.Wait()
+}
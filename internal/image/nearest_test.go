@@ -0,0 +1,167 @@
+package image
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomPalette returns n colors from a fixed seed, so every run compares the same palette
+func randomPalette(n int, seed int64) []color.Color {
+	rng := rand.New(rand.NewSource(seed))
+	palette := make([]color.Color, n)
+	for i := range palette {
+		palette[i] = color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+	}
+	return palette
+}
+
+// linearNearest is the nearest neighbour search before the k-d tree: one distance per palette color,
+// ties going to the lowest index
+func linearNearest(m *paletteMatcher, r, g, b uint8) int {
+	p := m.toPoint(r, g, b)
+	best, minDist := 0, math.MaxFloat64
+	for i, q := range m.points {
+		if d := m.distance(p, q); d < minDist {
+			best, minDist = i, d
+		}
+	}
+	return best
+}
+
+func TestKDTreeMatchesLinearScan(t *testing.T) {
+	palette := randomPalette(200, 1)
+	// duplicates are exact ties, the first of them has to win like in a linear scan
+	palette = append(palette, palette[3], palette[42], palette[199])
+	palette = append([]color.Color{palette[7]}, palette...)
+
+	for _, metric := range DistanceMetrics() {
+		t.Run(metric, func(t *testing.T) {
+			matcher, err := newPaletteMatcher(palette, metric)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache := newNearestCache(matcher)
+
+			check := func(r, g, b uint8) {
+				t.Helper()
+				want := linearNearest(matcher, r, g, b)
+				if got := matcher.nearest(r, g, b); got != want {
+					t.Fatalf("nearest(%d,%d,%d) = %d, linear scan = %d", r, g, b, got, want)
+				}
+				if got := cache.nearest(r, g, b); got != want {
+					t.Fatalf("cached nearest(%d,%d,%d) = %d, linear scan = %d", r, g, b, got, want)
+				}
+			}
+
+			for r := 0; r < 256; r += 15 {
+				for g := 0; g < 256; g += 15 {
+					for b := 0; b < 256; b += 15 {
+						check(uint8(r), uint8(g), uint8(b))
+					}
+				}
+			}
+			for _, c := range palette {
+				rgba := c.(color.RGBA)
+				check(rgba.R, rgba.G, rgba.B)
+			}
+		})
+	}
+}
+
+func TestKDTreeTiesGoToLowestIndex(t *testing.T) {
+	gray := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	palette := randomPalette(20, 2)
+	palette[5], palette[11], palette[17] = gray, gray, gray
+
+	for _, metric := range []string{MetricWeightedRGB, MetricCIE76, MetricOKLab} {
+		matcher, err := newPaletteMatcher(palette, metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matcher.tree == nil {
+			t.Fatalf("%s: expected a k-d tree for %d colors", metric, len(palette))
+		}
+		if got := matcher.nearest(0x80, 0x80, 0x80); got != 5 {
+			t.Errorf("%s: nearest of a color in the palette 3 times = %d, want 5", metric, got)
+		}
+	}
+}
+
+// benchmarkImage is a 1920x1080 gradient with noise, so the cache sees about as many distinct colors as a photo
+func benchmarkImage() *image.NRGBA {
+	rng := rand.New(rand.NewSource(3))
+	img := image.NewNRGBA(image.Rect(0, 0, 1920, 1080))
+	for y := 0; y < 1080; y++ {
+		for x := 0; x < 1920; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i] = uint8(x*255/1919) ^ uint8(rng.Intn(8))
+			img.Pix[i+1] = uint8(y*255/1079) ^ uint8(rng.Intn(8))
+			img.Pix[i+2] = uint8((x+y)*255/2998) ^ uint8(rng.Intn(8))
+			img.Pix[i+3] = 0xff
+		}
+	}
+	return img
+}
+
+func BenchmarkNearestNeighbour(b *testing.B) {
+	img := benchmarkImage()
+	bounds := img.Bounds()
+	palette := randomPalette(200, 4)
+
+	for _, metric := range []string{MetricWeightedRGB, MetricOKLab} {
+		matcher, err := newPaletteMatcher(palette, metric)
+		if err != nil {
+			b.Fatal(err)
+		}
+		colors := paletteRGBA(matcher)
+
+		// every pixel scanned against the whole palette on one goroutine, the old implementation
+		b.Run(metric+"/linear", func(b *testing.B) {
+			out := image.NewNRGBA(bounds)
+			for n := 0; n < b.N; n++ {
+				for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+					for x := bounds.Min.X; x < bounds.Max.X; x++ {
+						c := img.NRGBAAt(x, y)
+						setNRGBA(out.Pix[out.PixOffset(x, y):], colors[linearNearest(matcher, c.R, c.G, c.B)], c.A)
+					}
+				}
+			}
+		})
+
+		b.Run(metric+"/kdtree", func(b *testing.B) {
+			out := image.NewNRGBA(bounds)
+			for n := 0; n < b.N; n++ {
+				for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+					for x := bounds.Min.X; x < bounds.Max.X; x++ {
+						c := img.NRGBAAt(x, y)
+						setNRGBA(out.Pix[out.PixOffset(x, y):], colors[matcher.nearest(c.R, c.G, c.B)], c.A)
+					}
+				}
+			}
+		})
+
+		b.Run(metric+"/kdtree+cache+parallel", func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				mapNearest(context.Background(), img, matcher)
+			}
+		})
+	}
+}
+
+func TestNearestNeighbourStopsWhenCancelled(t *testing.T) {
+	img := benchmarkImage()
+	palette := randomPalette(16, 5)
+	theme := Theme{Name: "random", Colors: palette}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, dither := range []string{"", DitherFloydSteinberg, DitherBayer4} {
+		if _, err := NearestNeighbour(ctx, img, theme, NNOptions{Dither: dither}); err != context.Canceled {
+			t.Errorf("dither %q: error %v, want context.Canceled", dither, err)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package image
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Achno/gowall/config"
+	haldclut "github.com/Achno/gowall/internal/backends/colorthief/haldClut"
+	"github.com/Achno/gowall/utils"
+)
+
+// PipelineStep is a single processor in a pipeline, with an optional theme that
+// overrides the theme passed to the whole pipeline
+type PipelineStep struct {
+	Name      string
+	Processor ImageProcessor
+	Theme     string
+}
+
+// PipelineProcessor implements ImageProcessor by running an ordered list of processors
+// on the in-memory image, so only one decode and one encode happen for the whole chain.
+//
+//	Example: convert:theme=nord -> draw:thickness=5 -> pixelate:scale=10
+type PipelineProcessor struct {
+	Steps []PipelineStep
+}
+
+func (p *PipelineProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
+
+	if len(p.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline has no steps")
+	}
+
+	current := img
+	for i, step := range p.Steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		stepTheme := theme
+		if step.Theme != "" {
+			stepTheme = step.Theme
+		}
+
+		newImg, err := step.Processor.Process(ctx, current, stepTheme)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline step %d (%s): %w", i+1, step.Name, err)
+		}
+		if newImg == nil {
+			return nil, fmt.Errorf("pipeline step %d (%s) returned no image", i+1, step.Name)
+		}
+		current = newImg
+	}
+
+	return current, nil
+}
+
+// Validate checks the parameters of every step, with the theme each step would use
+func (p *PipelineProcessor) Validate(theme string) error {
+	if len(p.Steps) == 0 {
+		return utils.InvalidParameter("pipeline has no steps")
+	}
+
+	for i, step := range p.Steps {
+		stepTheme := theme
+		if step.Theme != "" {
+			stepTheme = step.Theme
+		}
+		if err := ValidateProcessor(step.Processor, stepTheme); err != nil {
+			return fmt.Errorf("pipeline step %d (%s): %w", i+1, step.Name, err)
+		}
+	}
+	return nil
+}
+
+// Available processors that can be used as a pipeline step, keyed by step name.
+// Every constructor receives the step parameters as key=value pairs.
+var pipelineSteps = map[string]func(params map[string]string) (ImageProcessor, error){
+	"convert": func(params map[string]string) (ImageProcessor, error) {
+		metric := stringParam(params, "metric", "")
+		if err := ValidateDistanceMetric(metric); err != nil {
+			return nil, err
+		}
+		dither := stringParam(params, "dither", "")
+		strength, err := floatParam(params, "strength", 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := ValidateDither(dither, strength); err != nil {
+			return nil, err
+		}
+		level, err := intParam(params, "level", 0)
+		if err != nil {
+			return nil, err
+		}
+		if level != 0 {
+			if err := ValidateCLUTLevel(level); err != nil {
+				return nil, err
+			}
+		}
+		interpolation := stringParam(params, "interp", "")
+		if err := ValidateInterpolation(interpolation); err != nil {
+			return nil, err
+		}
+		sigma, err := floatParam(params, "sigma", 0)
+		if err != nil {
+			return nil, err
+		}
+		power, err := floatParam(params, "power", 0)
+		if err != nil {
+			return nil, err
+		}
+		neighbours, err := intParam(params, "k", 0)
+		if err != nil {
+			return nil, err
+		}
+		preserve, err := floatParam(params, "preserve", 0)
+		if err != nil {
+			return nil, err
+		}
+		mix, err := floatParam(params, "mix", 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := ValidateTone(ToneOptions{PreserveLuminance: preserve, Strength: mix}); err != nil {
+			return nil, err
+		}
+		mapperOptions := haldclut.MapperOptions{Sigma: sigma, Power: power, Neighbours: neighbours}
+		mapper := stringParam(params, "mapper", "")
+		if _, err := haldclut.NewMapper(mapper, mapperOptions); err != nil {
+			return nil, err
+		}
+
+		return &ThemeConverter{
+			Metric:            metric,
+			Dither:            dither,
+			DitherStrength:    strength,
+			Level:             level,
+			Interpolation:     interpolation,
+			Mapper:            mapper,
+			MapperOptions:     mapperOptions,
+			PreserveLuminance: preserve,
+			Strength:          mix,
+		}, nil
+	},
+	"lut": func(params map[string]string) (ImageProcessor, error) {
+		file := params["file"]
+		if file == "" {
+			return nil, utils.InvalidParameter("lut requires a 'file'")
+		}
+		interpolation := stringParam(params, "interp", "")
+		if err := ValidateInterpolation(interpolation); err != nil {
+			return nil, err
+		}
+		return &LUTProcessor{Path: file, Interpolation: interpolation}, nil
+	},
+	"invert": func(params map[string]string) (ImageProcessor, error) {
+		return &Inverter{}, nil
+	},
+	"flip": func(params map[string]string) (ImageProcessor, error) {
+		return &FlipProcessor{}, nil
+	},
+	"mirror": func(params map[string]string) (ImageProcessor, error) {
+		return &MirrorProcessor{}, nil
+	},
+	"grayscale": func(params map[string]string) (ImageProcessor, error) {
+		return &GrayScaleProcessor{}, nil
+	},
+	"br": func(params map[string]string) (ImageProcessor, error) {
+		factor, err := floatParam(params, "factor", 1.1)
+		if err != nil {
+			return nil, err
+		}
+		return &BrightnessProcessor{Factor: factor}, nil
+	},
+	"pixelate": func(params map[string]string) (ImageProcessor, error) {
+		scale, err := floatParam(params, "scale", 15)
+		if err != nil {
+			return nil, err
+		}
+		return &PixelateProcessor{Scale: scale}, nil
+	},
+	"draw": func(params map[string]string) (ImageProcessor, error) {
+		clr, err := HexToRGBA(stringParam(params, "color", "#5D3FD3"))
+		if err != nil {
+			return nil, utils.InvalidParameter("invalid color: %v", err)
+		}
+		thickness, err := intParam(params, "thickness", 5)
+		if err != nil {
+			return nil, err
+		}
+		return &DrawProcessor{Color: clr, BorderThickness: thickness}, nil
+	},
+	"replace": func(params map[string]string) (ImageProcessor, error) {
+		from, to := params["from"], params["to"]
+		if from == "" || to == "" {
+			return nil, utils.InvalidParameter("replace requires both 'from' and 'to' colors")
+		}
+		threshold, err := floatParam(params, "threshold", 8.5)
+		if err != nil {
+			return nil, err
+		}
+		return &ReplaceProcessor{FromColor: from, ToColor: to, Threshold: threshold}, nil
+	},
+	"bg": func(params map[string]string) (ImageProcessor, error) {
+		maxIter, err := intParam(params, "iterations", 100)
+		if err != nil {
+			return nil, err
+		}
+		routines, err := intParam(params, "routines", 4)
+		if err != nil {
+			return nil, err
+		}
+		conv, err := floatParam(params, "conv", 0.001)
+		if err != nil {
+			return nil, err
+		}
+		sampleRate, err := floatParam(params, "sRate", 0.5)
+		if err != nil {
+			return nil, err
+		}
+		processor := &BackgroundProcessor{}
+		processor.SetOptions(
+			WithMaxIter(maxIter),
+			WithNumRoutines(routines),
+			WithConvergence(conv),
+			WithSampleRate(sampleRate),
+		)
+		return processor, nil
+	},
+}
+
+// pipelineStepParams are the parameter keys each pipeline step accepts besides "theme", any other key is rejected
+// so a typo like thicknes=10 doesn't silently fall back to the default
+var pipelineStepParams = map[string][]string{
+	"convert":   {"metric", "dither", "strength", "level", "interp", "sigma", "power", "k", "preserve", "mix", "mapper"},
+	"lut":       {"file", "interp"},
+	"invert":    {},
+	"flip":      {},
+	"mirror":    {},
+	"grayscale": {},
+	"br":        {"factor"},
+	"pixelate":  {"scale"},
+	"draw":      {"color", "thickness"},
+	"replace":   {"from", "to", "threshold"},
+	"bg":        {"iterations", "routines", "conv", "sRate"},
+}
+
+// PipelineStepNames returns the sorted names of all processors usable in a pipeline
+func PipelineStepNames() []string {
+	names := make([]string, 0, len(pipelineSteps))
+	for name := range pipelineSteps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePipeline builds a PipelineProcessor out of step specs, see ParsePipelineStep
+func ParsePipeline(specs []string) (*PipelineProcessor, error) {
+	if len(specs) == 0 {
+		return nil, utils.InvalidParameter("pipeline has no steps")
+	}
+
+	pipeline := &PipelineProcessor{}
+	for _, spec := range specs {
+		step, err := ParsePipelineStep(spec)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Steps = append(pipeline.Steps, step)
+	}
+	return pipeline, nil
+}
+
+// LoadNamedPipeline builds the pipeline with the given name from the "pipelines" section of config.yml
+func LoadNamedPipeline(name string) (*PipelineProcessor, error) {
+	for _, pw := range config.GowallConfig.Pipelines {
+		if strings.EqualFold(pw.Name, name) {
+			pipeline, err := ParsePipeline(pw.Steps)
+			if err != nil {
+				return nil, fmt.Errorf("pipeline %s: %w", pw.Name, err)
+			}
+			return pipeline, nil
+		}
+	}
+	return nil, utils.InvalidParameter("unknown pipeline: %s", name)
+}
+
+// ListPipelines returns the names of all pipelines defined in config.yml
+func ListPipelines() []string {
+	names := make([]string, 0, len(config.GowallConfig.Pipelines))
+	for _, pw := range config.GowallConfig.Pipelines {
+		names = append(names, pw.Name)
+	}
+	return names
+}
+
+// ParsePipelineStep parses a step spec of the form "name" or "name:key=value,key=value".
+// The "theme" key is available on every step and overrides the pipeline's theme.
+//
+//	Example "draw:color=#F38BA8,thickness=10" --> DrawProcessor{...}
+func ParsePipelineStep(spec string) (PipelineStep, error) {
+	spec = strings.TrimSpace(spec)
+	name, rawParams, _ := strings.Cut(spec, ":")
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	constructor, ok := pipelineSteps[name]
+	if !ok {
+		return PipelineStep{}, utils.InvalidParameter("unknown pipeline step: %q (available: %s)", name, strings.Join(PipelineStepNames(), ", "))
+	}
+
+	params := make(map[string]string)
+	if strings.TrimSpace(rawParams) != "" {
+		for _, pair := range strings.Split(rawParams, ",") {
+			key, value, found := strings.Cut(pair, "=")
+			if !found {
+				return PipelineStep{}, utils.InvalidParameter("step %s: parameter %q is not of the form key=value", name, pair)
+			}
+			key = strings.TrimSpace(key)
+			if key != "theme" && !slices.Contains(pipelineStepParams[name], key) {
+				accepted := append([]string{"theme"}, pipelineStepParams[name]...)
+				return PipelineStep{}, utils.InvalidParameter("step %s: unknown parameter %q (accepted: %s)", name, key, strings.Join(accepted, ", "))
+			}
+			params[key] = strings.TrimSpace(value)
+		}
+	}
+
+	processor, err := constructor(params)
+	if err != nil {
+		return PipelineStep{}, fmt.Errorf("step %s: %w", name, err)
+	}
+
+	return PipelineStep{
+		Name:      name,
+		Processor: processor,
+		Theme:     params["theme"],
+	}, nil
+}
+
+func stringParam(params map[string]string, key, fallback string) string {
+	if value, ok := params[key]; ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func floatParam(params map[string]string, key string, fallback float64) (float64, error) {
+	value, ok := params[key]
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, utils.InvalidParameter("parameter %s: %q is not a number", key, value)
+	}
+	return f, nil
+}
+
+func intParam(params map[string]string, key string, fallback int) (int, error) {
+	value, ok := params[key]
+	if !ok || value == "" {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, utils.InvalidParameter("parameter %s: %q is not an integer", key, value)
+	}
+	return i, nil
+}
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Achno/gowall/utils"
+)
+
+func TestParsePipelineStepRejectsUnknownParameters(t *testing.T) {
+	for _, spec := range []string{
+		"draw:thicknes=10",
+		"draw:color=#F38BA8,size=4",
+		"pixelate:factor=2",
+		"convert:metrc=oklab",
+		"invert:scale=3",
+		"lut:file=a.cube,interpolation=trilinear",
+	} {
+		_, err := ParsePipelineStep(spec)
+		if !errors.Is(err, utils.ErrInvalidParameter) {
+			t.Errorf("ParsePipelineStep(%q) error %v, want ErrInvalidParameter", spec, err)
+		}
+	}
+}
+
+func TestParsePipelineStepParameters(t *testing.T) {
+	for _, spec := range []string{
+		"draw:color=#F38BA8,thickness=10",
+		"draw: thickness = 3 ,theme=nord",
+		"pixelate:scale=10",
+		"convert:metric=oklab,dither=atkinson,strength=0.5,k=4,mapper=knn",
+		"lut:file=a.cube,interp=trilinear",
+		"bg:iterations=50,routines=2,conv=0.01,sRate=0.4",
+		"replace:from=#000000,to=#FFFFFF,threshold=10",
+		"br:factor=1.2",
+		"invert:theme=nord",
+	} {
+		if _, err := ParsePipelineStep(spec); err != nil {
+			t.Errorf("ParsePipelineStep(%q): %v", spec, err)
+		}
+	}
+}
+
+// every step has its accepted parameters declared, or all of its parameters would be rejected
+func TestPipelineStepParamsCoverEveryStep(t *testing.T) {
+	for name := range pipelineSteps {
+		if _, ok := pipelineStepParams[name]; !ok {
+			t.Errorf("step %s has no entry in pipelineStepParams", name)
+		}
+	}
+	for name := range pipelineStepParams {
+		if _, ok := pipelineSteps[name]; !ok {
+			t.Errorf("pipelineStepParams has an entry for the unknown step %s", name)
+		}
+	}
+}
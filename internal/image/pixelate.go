@@ -1,20 +1,30 @@
 package image
 
 import (
-	"fmt"
+	"context"
 	"image"
 	"math"
+
+	"github.com/Achno/gowall/utils"
 )
 
 type PixelateProcessor struct {
 	Scale float64
 }
 
-func (p *PixelateProcessor) Process(img image.Image, theme string) (image.Image, error) {
+// Validate checks that the scale is between 1 and 25
+func (p *PixelateProcessor) Validate(theme string) error {
+	if p.Scale < 1 || p.Scale > 25 {
+		return utils.InvalidParameter("scale must be between 1 and 25")
+	}
+	return nil
+}
+
+func (p *PixelateProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	// check if scale is valid
-	if p.Scale < 1 || p.Scale > 25 {
-		return nil, fmt.Errorf("scale must be between 1 and 25")
+	if err := p.Validate(theme); err != nil {
+		return nil, err
 	}
 
 	bounds := img.Bounds()
@@ -38,7 +48,7 @@ func downscale(img image.Image, scale float64) image.Image {
 	width := int(math.Round(float64(bounds.Dx()) * scale))
 	height := int(math.Round(float64(bounds.Dy()) * scale))
 
-	newImage := image.NewRGBA(image.Rect(0, 0, width, height))
+	newImage := image.NewNRGBA(image.Rect(0, 0, width, height))
 
 	// pick the nearest pixel for the new scaled image
 	for y := 0; y < height; y++ {
@@ -54,7 +64,7 @@ func downscale(img image.Image, scale float64) image.Image {
 
 func upscale(img image.Image, originalWidth, originalHeight int) image.Image {
 
-	newImage := image.NewRGBA(image.Rect(0, 0, originalWidth, originalHeight))
+	newImage := image.NewNRGBA(image.Rect(0, 0, originalWidth, originalHeight))
 
 	bounds := img.Bounds()
 	width := bounds.Dx()
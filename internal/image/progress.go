@@ -0,0 +1,187 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Achno/gowall/utils"
+	"golang.org/x/term"
+)
+
+// ProgressEvent is sent to a ProgressReporter every time an image of a batch finishes
+type ProgressEvent struct {
+	Index     int           // index of the image in the batch
+	Input     string        // path of the input image
+	Output    string        // path of the saved image, empty on error
+	Err       error         // error while processing the image, if any
+	Skipped   bool          // the output was up to date or already existed, nothing was processed
+	Completed int           // images finished so far, including failed ones
+	Total     int           // images in the batch
+	Elapsed   time.Duration // time since the batch started
+	Result    ProcessResult // details of the processed image
+}
+
+// ETA estimates the remaining time from the average time per completed image
+func (e ProgressEvent) ETA() time.Duration {
+	if e.Completed == 0 {
+		return 0
+	}
+	perImage := e.Elapsed / time.Duration(e.Completed)
+	return perImage * time.Duration(e.Total-e.Completed)
+}
+
+// ProgressReporter receives the progress of batch processing.
+// Update is never called concurrently
+type ProgressReporter interface {
+	Start(total int)
+	Update(event ProgressEvent)
+	Finish()
+}
+
+// NewProgressReporter returns the reporter for a --progress mode : bar, json or none
+func NewProgressReporter(mode string) (ProgressReporter, error) {
+	switch strings.ToLower(mode) {
+	case "", "bar":
+		return NewTerminalProgress(os.Stderr), nil
+	case "json":
+		return NewJSONProgress(Stdout()), nil
+	case "none":
+		return NoOpProgress{}, nil
+	default:
+		return nil, utils.InvalidParameter("unknown progress mode: %s (available: bar, json, none)", mode)
+	}
+}
+
+// NoOpProgress implements ProgressReporter but reports nothing
+type NoOpProgress struct{}
+
+func (NoOpProgress) Start(total int)            {}
+func (NoOpProgress) Update(event ProgressEvent) {}
+func (NoOpProgress) Finish()                    {}
+
+// TerminalProgress draws a progress bar with an ETA. When the writer is not a terminal
+// it prints one line per image instead
+type TerminalProgress struct {
+	w           io.Writer
+	interactive bool
+	width       int
+}
+
+func NewTerminalProgress(w io.Writer) *TerminalProgress {
+	interactive := false
+	if f, ok := w.(*os.File); ok {
+		interactive = term.IsTerminal(int(f.Fd()))
+	}
+	return &TerminalProgress{w: w, interactive: interactive, width: 30}
+}
+
+func (p *TerminalProgress) Start(total int) {
+	if p.interactive {
+		p.draw(ProgressEvent{Total: total})
+	}
+}
+
+func (p *TerminalProgress) Update(event ProgressEvent) {
+	if !p.interactive {
+		if event.Err != nil {
+			fmt.Fprintf(p.w, " ::: Image %d Failed , %d Images left ::: \n", event.Index, event.Total-event.Completed)
+			return
+		}
+		if event.Skipped {
+			fmt.Fprintf(p.w, " ::: Image %d Skipped (up to date) , %d Images left ::: \n", event.Index, event.Total-event.Completed)
+			return
+		}
+		fmt.Fprintf(p.w, " ::: Image %d Completed , %d Images left ::: \n", event.Index, event.Total-event.Completed)
+		return
+	}
+
+	// print failures above the bar so they don't get overwritten
+	if event.Err != nil {
+		fmt.Fprintf(p.w, "\r\033[K%s %v %s\n", utils.RedColor, event.Err, utils.ResetColor)
+	}
+	p.draw(event)
+}
+
+func (p *TerminalProgress) Finish() {
+	if p.interactive {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *TerminalProgress) draw(event ProgressEvent) {
+	filled := 0
+	if event.Total > 0 {
+		filled = p.width * event.Completed / event.Total
+	}
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", p.width-filled)
+
+	eta := "--"
+	if event.Completed > 0 {
+		eta = event.ETA().Round(time.Second).String()
+	}
+
+	fmt.Fprintf(p.w, "\r\033[K [%s] %d/%d  elapsed %s  eta %s", bar, event.Completed, event.Total,
+		event.Elapsed.Round(time.Second), eta)
+}
+
+// JSONProgress writes one JSON object per line for every image, meant for scripts
+type JSONProgress struct {
+	enc  *json.Encoder
+	last ProgressEvent
+}
+
+func NewJSONProgress(w io.Writer) *JSONProgress {
+	return &JSONProgress{enc: json.NewEncoder(w)}
+}
+
+type jsonProgressLine struct {
+	Event     string  `json:"event"`
+	Index     int     `json:"index"`
+	Input     string  `json:"input,omitempty"`
+	Output    string  `json:"output,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	Completed int     `json:"completed"`
+	Total     int     `json:"total"`
+	Elapsed   float64 `json:"elapsed_seconds"`
+	ETA       float64 `json:"eta_seconds"`
+}
+
+func (p *JSONProgress) Start(total int) {
+	p.last = ProgressEvent{Total: total}
+	p.enc.Encode(jsonProgressLine{Event: "start", Total: total})
+}
+
+func (p *JSONProgress) Update(event ProgressEvent) {
+	p.last = event
+	line := jsonProgressLine{
+		Event:     "done",
+		Index:     event.Index,
+		Input:     event.Input,
+		Output:    event.Output,
+		Completed: event.Completed,
+		Total:     event.Total,
+		Elapsed:   event.Elapsed.Seconds(),
+		ETA:       event.ETA().Seconds(),
+	}
+	if event.Err != nil {
+		line.Event = "error"
+		line.Error = event.Err.Error()
+	}
+	if event.Skipped {
+		line.Event = "skipped"
+	}
+	p.enc.Encode(line)
+}
+
+func (p *JSONProgress) Finish() {
+	p.enc.Encode(jsonProgressLine{
+		Event:     "finish",
+		Completed: p.last.Completed,
+		Total:     p.last.Total,
+		Elapsed:   p.last.Elapsed.Seconds(),
+	})
+}
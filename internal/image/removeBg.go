@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -61,14 +62,14 @@ func (p *BackgroundProcessor) SetOptions(options ...BgOption) {
 	p.options = opts
 }
 
-func (p *BackgroundProcessor) Process(img image.Image, theme string) (image.Image, error) {
+func (p *BackgroundProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	// check if options have not been set
 	if p.options.Convergence == 0 || p.options.MaxIter == 0 || p.options.SampleRate == 0 || p.options.NumRoutines == 0 {
 		p.SetOptions()
 	}
 
-	newImg, err := removeBackground(&p.options, img)
+	newImg, err := removeBackground(ctx, &p.options, img)
 
 	if err != nil {
 		return nil, fmt.Errorf("while removing background: %w", err)
@@ -86,7 +87,7 @@ type Cluster struct {
 	Points   []Point
 }
 
-func removeBackground(config *BgOptions, img image.Image) (image.Image, error) {
+func removeBackground(ctx context.Context, config *BgOptions, img image.Image) (image.Image, error) {
 
 	bounds := img.Bounds()
 	width, height := bounds.Max.X, bounds.Max.Y
@@ -116,6 +117,10 @@ func removeBackground(config *BgOptions, img image.Image) (image.Image, error) {
 	// Run k-means
 	for iter := 0; iter < config.MaxIter; iter++ {
 
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		// Clear previous points
 		for i := range clusters {
 			clusters[i].Points = clusters[i].Points[:0]
@@ -179,6 +184,7 @@ func removeBackground(config *BgOptions, img image.Image) (image.Image, error) {
 	// set the Background clusters pixels to transparent to remove the bg
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
+			original := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
 			r, g, b, _ := img.At(x, y).RGBA()
 			point := Point{
 				R: float64(r) / 65535.0,
@@ -200,12 +206,8 @@ func removeBackground(config *BgOptions, img image.Image) (image.Image, error) {
 			if closestCluster == backgroundCluster {
 				output.Set(x, y, color.NRGBA{0, 0, 0, 0}) // Transparent
 			} else {
-				output.Set(x, y, color.NRGBA{
-					R: uint8(r >> 8),
-					G: uint8(g >> 8),
-					B: uint8(b >> 8),
-					A: 255,
-				})
+				// the foreground keeps its own transparency
+				output.Set(x, y, original)
 			}
 		}
 	}
@@ -1,10 +1,13 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"math"
+
+	"github.com/Achno/gowall/utils"
 )
 
 type ReplaceProcessor struct {
@@ -13,7 +16,18 @@ type ReplaceProcessor struct {
 	Threshold float64
 }
 
-func (r *ReplaceProcessor) Process(img image.Image, theme string) (image.Image, error) {
+// Validate checks that both colors are valid hex colors
+func (r *ReplaceProcessor) Validate(theme string) error {
+	if _, err := HexToRGBA(r.FromColor); err != nil {
+		return fmt.Errorf("%w: %w", utils.ErrInvalidParameter, err)
+	}
+	if _, err := HexToRGBA(r.ToColor); err != nil {
+		return fmt.Errorf("%w: %w", utils.ErrInvalidParameter, err)
+	}
+	return nil
+}
+
+func (r *ReplaceProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
 
 	from, err := HexToRGBA(r.FromColor)
 
@@ -35,10 +49,11 @@ func (r *ReplaceProcessor) Process(img image.Image, theme string) (image.Image,
 	return newimage, nil
 }
 
-// replaces every pixel from the "from" color over to the "to" color in the image
+// replaces every pixel from the "from" color over to the "to" color in the image, the pixels keep their alpha
 func replaceColor(img image.Image, from, to color.Color, threshold float64) (image.Image, error) {
 	bounds := img.Bounds()
-	newImg := image.NewRGBA(bounds)
+	newImg := image.NewNRGBA(bounds)
+	toColor := color.NRGBAModel.Convert(to).(color.NRGBA)
 
 	replacementMade := false
 
@@ -46,7 +61,8 @@ func replaceColor(img image.Image, from, to color.Color, threshold float64) (ima
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
 			originalColor := img.At(x, y)
 			if colorsAreSimilar(originalColor, from, threshold) {
-				newImg.Set(x, y, to)
+				toColor.A = color.NRGBAModel.Convert(originalColor).(color.NRGBA).A
+				newImg.SetNRGBA(x, y, toColor)
 				replacementMade = true
 			} else {
 				newImg.Set(x, y, originalColor)
@@ -62,14 +78,13 @@ func replaceColor(img image.Image, from, to color.Color, threshold float64) (ima
 	return newImg, nil
 }
 
-// Helper function to check if two colors are similar within a threshold
+// Helper function to check if two colors are similar within a threshold, the alpha is ignored
 func colorsAreSimilar(c1, c2 color.Color, threshold float64) bool {
-	r1, g1, b1, _ := c1.RGBA()
-	r2, g2, b2, _ := c2.RGBA()
-
-	// Normalize to 8-bit values
-	r1, g1, b1 = r1>>8, g1>>8, b1>>8
-	r2, g2, b2 = r2>>8, g2>>8, b2>>8
+	// Compare the straight 8-bit values, premultiplied ones would be darker the more transparent the pixel is
+	n1 := color.NRGBAModel.Convert(c1).(color.NRGBA)
+	n2 := color.NRGBAModel.Convert(c2).(color.NRGBA)
+	r1, g1, b1 := n1.R, n1.G, n1.B
+	r2, g2, b2 := n2.R, n2.G, n2.B
 
 	// Euclidean distance
 	distance := math.Sqrt(
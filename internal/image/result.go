@@ -0,0 +1,114 @@
+package image
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Achno/gowall/utils"
+)
+
+// ProcessResult describes what happened to a single input
+type ProcessResult struct {
+	Input     string
+	Inputs    []string // every input, when several inputs make one output like a gif
+	Output    string
+	Processor string        // type name of the processor, e.g. "ThemeConverter"
+	Op        string        // name of the command, if any
+	Theme     string        // theme given to the processor
+	Width     int           // of the output image, or of the input image in a dry run
+	Height    int           // of the output image, or of the input image in a dry run
+	Duration  time.Duration // time spent on the image
+	Skipped   bool          // the output was up to date or already existed, nothing was processed
+	DryRun    bool
+}
+
+// Validator is implemented by processors that can check their parameters without an image,
+// so a dry run can report invalid parameters
+type Validator interface {
+	Validate(theme string) error
+}
+
+// ValidateProcessor checks the parameters of the processor if it implements Validator
+func ValidateProcessor(processor ImageProcessor, theme string) error {
+	if v, ok := processor.(Validator); ok {
+		return v.Validate(theme)
+	}
+	return nil
+}
+
+// ErrorCategory classifies an error so scripts can react to it without parsing the message,
+// see utils.ErrorCategory
+func ErrorCategory(err error) string {
+	return utils.ErrorCategory(err)
+}
+
+// ResultRecord is the JSON form of a ProcessResult, written by --json
+type ResultRecord struct {
+	Input      string       `json:"input,omitempty"`
+	Inputs     []string     `json:"inputs,omitempty"`
+	Output     string       `json:"output,omitempty"`
+	Processor  string       `json:"processor"`
+	Op         string       `json:"op,omitempty"`
+	Theme      string       `json:"theme,omitempty"`
+	DurationMs float64      `json:"duration_ms"`
+	Width      int          `json:"width,omitempty"`
+	Height     int          `json:"height,omitempty"`
+	Skipped    bool         `json:"skipped,omitempty"`
+	DryRun     bool         `json:"dry_run,omitempty"`
+	Error      *RecordError `json:"error,omitempty"`
+}
+
+type RecordError struct {
+	Category string `json:"category"`
+	Message  string `json:"message"`
+}
+
+// NewResultRecord combines the result with the error of processing the image, if any
+func NewResultRecord(result ProcessResult, err error) ResultRecord {
+	record := ResultRecord{
+		Input:      result.Input,
+		Inputs:     result.Inputs,
+		Output:     result.Output,
+		Processor:  result.Processor,
+		Op:         result.Op,
+		Theme:      result.Theme,
+		DurationMs: float64(result.Duration.Microseconds()) / 1000,
+		Width:      result.Width,
+		Height:     result.Height,
+		Skipped:    result.Skipped,
+		DryRun:     result.DryRun,
+	}
+	if err != nil {
+		// nothing was written
+		record.Output = ""
+		record.Error = &RecordError{Category: ErrorCategory(err), Message: err.Error()}
+	}
+	return record
+}
+
+// WriteResult writes the result as a single line of JSON
+func WriteResult(w io.Writer, result ProcessResult, err error) error {
+	return json.NewEncoder(w).Encode(NewResultRecord(result, err))
+}
+
+// JSONResults implements ProgressReporter by writing one ResultRecord per image of a batch
+type JSONResults struct {
+	w io.Writer
+}
+
+func NewJSONResults(w io.Writer) *JSONResults {
+	return &JSONResults{w: w}
+}
+
+func (r *JSONResults) Start(total int) {}
+
+func (r *JSONResults) Update(event ProgressEvent) {
+	result := event.Result
+	if result.Input == "" {
+		result.Input = event.Input
+	}
+	WriteResult(r.w, result, event.Err)
+}
+
+func (r *JSONResults) Finish() {}
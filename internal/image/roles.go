@@ -0,0 +1,208 @@
+package image
+
+import (
+	"fmt"
+	"image/color"
+	"regexp"
+	"strings"
+)
+
+// ThemeRoles are the optional named colors of a theme, for the consumers that need to know what a color is for,
+// like terminal and editor themes. A nil color is not set
+type ThemeRoles struct {
+	Background color.Color
+	Foreground color.Color
+	Cursor     color.Color
+	Selection  color.Color
+	// color0-color15 of a terminal: black, red, green, yellow, blue, magenta, cyan and white, then their bright versions
+	ANSI [16]color.Color
+}
+
+// ThemeRoleData is the part of a theme file with the roles, as hex colors. Every role is optional
+type ThemeRoleData struct {
+	Background string `json:"background,omitempty" yaml:"background,omitempty"`
+	Foreground string `json:"foreground,omitempty" yaml:"foreground,omitempty"`
+	Cursor     string `json:"cursor,omitempty" yaml:"cursor,omitempty"`
+	Selection  string `json:"selection,omitempty" yaml:"selection,omitempty"`
+	Color0     string `json:"color0,omitempty" yaml:"color0,omitempty"`
+	Color1     string `json:"color1,omitempty" yaml:"color1,omitempty"`
+	Color2     string `json:"color2,omitempty" yaml:"color2,omitempty"`
+	Color3     string `json:"color3,omitempty" yaml:"color3,omitempty"`
+	Color4     string `json:"color4,omitempty" yaml:"color4,omitempty"`
+	Color5     string `json:"color5,omitempty" yaml:"color5,omitempty"`
+	Color6     string `json:"color6,omitempty" yaml:"color6,omitempty"`
+	Color7     string `json:"color7,omitempty" yaml:"color7,omitempty"`
+	Color8     string `json:"color8,omitempty" yaml:"color8,omitempty"`
+	Color9     string `json:"color9,omitempty" yaml:"color9,omitempty"`
+	Color10    string `json:"color10,omitempty" yaml:"color10,omitempty"`
+	Color11    string `json:"color11,omitempty" yaml:"color11,omitempty"`
+	Color12    string `json:"color12,omitempty" yaml:"color12,omitempty"`
+	Color13    string `json:"color13,omitempty" yaml:"color13,omitempty"`
+	Color14    string `json:"color14,omitempty" yaml:"color14,omitempty"`
+	Color15    string `json:"color15,omitempty" yaml:"color15,omitempty"`
+}
+
+// RoleNames returns the names of the roles as they are written in theme files
+func RoleNames() []string {
+	names := []string{"background", "foreground", "cursor", "selection"}
+	for i := 0; i < 16; i++ {
+		names = append(names, fmt.Sprintf("color%d", i))
+	}
+	return names
+}
+
+// slots returns the roles in the order of RoleNames
+func (roles *ThemeRoles) slots() []*color.Color {
+	slots := []*color.Color{&roles.Background, &roles.Foreground, &roles.Cursor, &roles.Selection}
+	for i := range roles.ANSI {
+		slots = append(slots, &roles.ANSI[i])
+	}
+	return slots
+}
+
+// slots returns the roles in the order of RoleNames
+func (data *ThemeRoleData) slots() []*string {
+	return []*string{
+		&data.Background, &data.Foreground, &data.Cursor, &data.Selection,
+		&data.Color0, &data.Color1, &data.Color2, &data.Color3, &data.Color4, &data.Color5, &data.Color6, &data.Color7,
+		&data.Color8, &data.Color9, &data.Color10, &data.Color11, &data.Color12, &data.Color13, &data.Color14, &data.Color15,
+	}
+}
+
+// Get returns the color of the role with the name of RoleNames, nil when it is not set or unknown
+func (roles ThemeRoles) Get(name string) color.Color {
+	for i, roleName := range RoleNames() {
+		if roleName == strings.ToLower(name) {
+			return *roles.slots()[i]
+		}
+	}
+	return nil
+}
+
+// Set sets the color of the role with the name of RoleNames
+func (roles *ThemeRoles) Set(name string, c color.Color) error {
+	for i, roleName := range RoleNames() {
+		if roleName == strings.ToLower(name) {
+			*roles.slots()[i] = c
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown theme role: %s (available: %s)", name, strings.Join(RoleNames(), ", "))
+}
+
+// IsEmpty reports whether no role is set
+func (roles ThemeRoles) IsEmpty() bool {
+	for _, slot := range roles.slots() {
+		if *slot != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseRoles converts the hex colors of a theme file to ThemeRoles
+func parseRoles(data ThemeRoleData) (ThemeRoles, error) {
+	var roles ThemeRoles
+	names := RoleNames()
+	slots := roles.slots()
+	for i, hexColor := range data.slots() {
+		if *hexColor == "" {
+			continue
+		}
+		rgba, err := HexToRGBA(*hexColor)
+		if err != nil {
+			return ThemeRoles{}, fmt.Errorf("invalid %s color %s: %w", names[i], *hexColor, err)
+		}
+		*slots[i] = rgba
+	}
+	return roles, nil
+}
+
+// roleData converts ThemeRoles to the hex colors of a theme file
+func roleData(roles ThemeRoles) ThemeRoleData {
+	var data ThemeRoleData
+	hexColors := data.slots()
+	for i, slot := range roles.slots() {
+		if *slot != nil {
+			*hexColors[i] = RGBtoHex(color.RGBAModel.Convert(*slot).(color.RGBA))
+		}
+	}
+	return data
+}
+
+// mergeRoleColors returns the colors followed by the role colors that aren't in them yet,
+// so a theme's palette always has the colors of its roles
+func mergeRoleColors(colors []color.Color, roles ThemeRoles) []color.Color {
+	seen := make(map[color.RGBA]bool, len(colors))
+	for _, c := range colors {
+		seen[color.RGBAModel.Convert(c).(color.RGBA)] = true
+	}
+
+	merged := colors
+	for _, slot := range roles.slots() {
+		if *slot == nil {
+			continue
+		}
+		rgba := color.RGBAModel.Convert(*slot).(color.RGBA)
+		if !seen[rgba] {
+			seen[rgba] = true
+			merged = append(merged, rgba)
+		}
+	}
+	return merged
+}
+
+// roleWeight is how much more the background and foreground pull in a CLUT than the other theme colors.
+// They cover most of the screen, so the dark and light parts of an image should land on them
+const roleWeight = 2
+
+// paletteWeights returns the CLUT weight of each color of the theme, nil when the theme has no background or foreground
+func paletteWeights(theme Theme) []float64 {
+	if theme.Roles.Background == nil && theme.Roles.Foreground == nil {
+		return nil
+	}
+
+	weighted := make(map[color.RGBA]bool)
+	for _, c := range []color.Color{theme.Roles.Background, theme.Roles.Foreground} {
+		if c != nil {
+			weighted[color.RGBAModel.Convert(c).(color.RGBA)] = true
+		}
+	}
+
+	weights := make([]float64, len(theme.Colors))
+	for i, c := range theme.Colors {
+		weights[i] = 1
+		if weighted[color.RGBAModel.Convert(c).(color.RGBA)] {
+			weights[i] = roleWeight
+		}
+	}
+	return weights
+}
+
+// weightKey describes the roles that weigh the CLUT of the theme, empty when it has none
+func weightKey(theme Theme) string {
+	var key strings.Builder
+	if theme.Roles.Background != nil {
+		key.WriteString("background" + RGBtoHex(color.RGBAModel.Convert(theme.Roles.Background).(color.RGBA)))
+	}
+	if theme.Roles.Foreground != nil {
+		key.WriteString("foreground" + RGBtoHex(color.RGBAModel.Convert(theme.Roles.Foreground).(color.RGBA)))
+	}
+	return key.String()
+}
+
+// emacsRolePattern matches the role bindings generateEmacsTheme writes, e.g. (role-background "#1E1E2E")
+var emacsRolePattern = regexp.MustCompile(`\(role-([a-z0-9]+) +"(#[0-9A-Fa-f]{6})"\)`)
+
+// parseEmacsRoles reads the role bindings of an Emacs theme written by gowall
+func parseEmacsRoles(content string) ThemeRoles {
+	var roles ThemeRoles
+	for _, match := range emacsRolePattern.FindAllStringSubmatch(content, -1) {
+		rgba, err := HexToRGBA(match[2])
+		if err != nil {
+			continue
+		}
+		_ = roles.Set(match[1], rgba)
+	}
+	return roles
+}
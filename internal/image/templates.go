@@ -0,0 +1,288 @@
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/Achno/gowall/config"
+	"github.com/Achno/gowall/utils"
+)
+
+// TemplateColor is a color as templates see it. It prints as #RRGGBB and has the accessors and helpers of
+// pywal's templates, e.g. {{.Background.Strip}}, {{.Roles.color1.RGB}} or {{.Foreground.Lighten 0.2}}
+type TemplateColor struct {
+	R, G, B, A uint8
+}
+
+func newTemplateColor(c color.Color) TemplateColor {
+	rgba := color.RGBAModel.Convert(c).(color.RGBA)
+	return TemplateColor{rgba.R, rgba.G, rgba.B, rgba.A}
+}
+
+func (c TemplateColor) rgba() color.RGBA {
+	return color.RGBA{c.R, c.G, c.B, c.A}
+}
+
+// String returns the color as #RRGGBB
+func (c TemplateColor) String() string {
+	return c.Hex()
+}
+
+// Hex returns the color as #RRGGBB
+func (c TemplateColor) Hex() string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// HexAlpha returns the color as #RRGGBBAA
+func (c TemplateColor) HexAlpha() string {
+	return fmt.Sprintf("#%02X%02X%02X%02X", c.R, c.G, c.B, c.A)
+}
+
+// Strip returns the color as RRGGBB, for the formats without the #
+func (c TemplateColor) Strip() string {
+	return strings.TrimPrefix(c.Hex(), "#")
+}
+
+// RGB returns the color as r,g,b
+func (c TemplateColor) RGB() string {
+	return fmt.Sprintf("%d,%d,%d", c.R, c.G, c.B)
+}
+
+// RGBA returns the color as rgba(r,g,b,alpha) with the alpha from 0 to 1
+func (c TemplateColor) RGBA() string {
+	return fmt.Sprintf("rgba(%d,%d,%d,%s)", c.R, c.G, c.B, formatAlpha(c.A))
+}
+
+// HSL returns the color as hsl(h, s%, l%)
+func (c TemplateColor) HSL() string {
+	h, s, l := rgbToHSL(c.rgba())
+	return fmt.Sprintf("hsl(%.0f, %.0f%%, %.0f%%)", h, s*100, l*100)
+}
+
+// Lighten mixes the color with white by amount, from 0 to 1
+func (c TemplateColor) Lighten(amount float64) TemplateColor {
+	return c.Mix(TemplateColor{255, 255, 255, 255}, amount)
+}
+
+// Darken mixes the color with black by amount, from 0 to 1
+func (c TemplateColor) Darken(amount float64) TemplateColor {
+	return c.Mix(TemplateColor{0, 0, 0, 255}, amount)
+}
+
+// Mix blends the color into other by amount in OKLab, 0 is the color and 1 is other. The alpha is kept
+func (c TemplateColor) Mix(other TemplateColor, amount float64) TemplateColor {
+	mixed := newTemplateColor(mixColors(c.rgba(), other.rgba(), math.Max(0, math.Min(1, amount))))
+	mixed.A = c.A
+	return mixed
+}
+
+// Alpha returns the color with the alpha, from 0 to 1
+func (c TemplateColor) Alpha(alpha float64) TemplateColor {
+	c.A = uint8(math.Round(math.Max(0, math.Min(1, alpha)) * 255))
+	return c
+}
+
+// formatAlpha prints an 8 bit alpha from 0 to 1 with at most 2 decimals
+func formatAlpha(a uint8) string {
+	s := fmt.Sprintf("%.2f", float64(a)/255)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "" {
+		return "0"
+	}
+	return s
+}
+
+// rgbToHSL returns the hue in degrees and the saturation and lightness from 0 to 1
+func rgbToHSL(c color.RGBA) (h, s, l float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	maxC, minC := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	l = (maxC + minC) / 2
+	if maxC == minC {
+		return 0, 0, l
+	}
+
+	d := maxC - minC
+	s = d / (1 - math.Abs(2*l-1))
+	switch maxC {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, l
+}
+
+// TemplateData is what a template is rendered with. Every role is set, the ones the theme doesn't
+// have are filled by CompleteRoles. Roles has all of them by the names of RoleNames, e.g. {{.Roles.color4}}
+type TemplateData struct {
+	Name       string
+	Wallpaper  string
+	Colors     []TemplateColor
+	Background TemplateColor
+	Foreground TemplateColor
+	Cursor     TemplateColor
+	Selection  TemplateColor
+	Roles      map[string]TemplateColor
+}
+
+// NewTemplateData returns the data of the theme for templates, wallpaper is the image it belongs to and can be empty
+func NewTemplateData(theme Theme, wallpaper string) TemplateData {
+	roles := CompleteRoles(theme)
+	data := TemplateData{
+		Name:      theme.Name,
+		Wallpaper: wallpaper,
+		Colors:    make([]TemplateColor, 0, len(theme.Colors)),
+		Roles:     make(map[string]TemplateColor),
+	}
+	for _, c := range theme.Colors {
+		data.Colors = append(data.Colors, newTemplateColor(c))
+	}
+	if len(theme.Colors) == 0 {
+		return data
+	}
+
+	for _, name := range RoleNames() {
+		data.Roles[name] = newTemplateColor(roles.Get(name))
+	}
+	data.Background = data.Roles["background"]
+	data.Foreground = data.Roles["foreground"]
+	data.Cursor = data.Roles["cursor"]
+	data.Selection = data.Roles["selection"]
+	return data
+}
+
+// templateFuncs are the helpers for pipelines, e.g. {{.Background | lighten 0.1 | alpha 0.8 | rgba}}
+var templateFuncs = template.FuncMap{
+	"hex":     func(c TemplateColor) string { return c.Hex() },
+	"strip":   func(c TemplateColor) string { return c.Strip() },
+	"rgb":     func(c TemplateColor) string { return c.RGB() },
+	"rgba":    func(c TemplateColor) string { return c.RGBA() },
+	"hsl":     func(c TemplateColor) string { return c.HSL() },
+	"lighten": func(amount float64, c TemplateColor) TemplateColor { return c.Lighten(amount) },
+	"darken":  func(amount float64, c TemplateColor) TemplateColor { return c.Darken(amount) },
+	"alpha":   func(alpha float64, c TemplateColor) TemplateColor { return c.Alpha(alpha) },
+	"mix":     func(other TemplateColor, amount float64, c TemplateColor) TemplateColor { return c.Mix(other, amount) },
+}
+
+// RenderTemplate parses the template text and renders it with the data into w
+func RenderTemplate(w io.Writer, name, text string, data TemplateData) error {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("while parsing template %s: %w", name, err)
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("while rendering template %s: %w", name, err)
+	}
+	return nil
+}
+
+// TemplatesDirectory returns the folder of the user's templates, ~/.config/gowall/templates
+func TemplatesDirectory() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "gowall", "templates"), nil
+}
+
+// RenderTemplates renders every file in the templates folder with the theme into the cache folder,
+// ~/.cache/gowall, under the same name. A broken template doesn't stop the others, their errors are
+// returned together with the paths of the files that were rendered
+func RenderTemplates(theme Theme, wallpaper string) ([]string, error) {
+	templatesDir, err := TemplatesDirectory()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(templatesDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, utils.InvalidParameter("no templates found, add them to %s", templatesDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", templatesDir, err)
+	}
+
+	cacheDir, err := utils.CacheDirectory()
+	if err != nil {
+		return nil, fmt.Errorf("while finding the cache directory: %w", err)
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("while creating %s: %w", cacheDir, err)
+	}
+
+	data := NewTemplateData(theme, wallpaper)
+	var rendered []string
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		text, err := os.ReadFile(filepath.Join(templatesDir, entry.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("while reading template %s: %w", entry.Name(), err))
+			continue
+		}
+
+		// rendered in memory first, so a template that fails halfway doesn't replace the last good file
+		var out strings.Builder
+		if err := RenderTemplate(&out, entry.Name(), string(text), data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		outPath := filepath.Join(cacheDir, entry.Name())
+		err = utils.WriteFileAtomic(outPath, func(w io.Writer) error {
+			_, err := io.WriteString(w, out.String())
+			return err
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rendered = append(rendered, outPath)
+	}
+	return rendered, errors.Join(errs...)
+}
+
+// RunTemplateHooks runs the TemplateHooks commands of config.yml in order, through the shell, once the templates
+// are rendered. They get the theme and wallpaper as $GOWALL_THEME and $GOWALL_WALLPAPER, e.g. to reload a terminal.
+// Cancelling ctx kills the running hook and skips the rest
+func RunTemplateHooks(ctx context.Context, theme Theme, wallpaper string) error {
+	var errs []error
+	for _, hook := range config.GowallConfig.TemplateHooks {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", hook)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", hook)
+		}
+		cmd.Env = append(os.Environ(), "GOWALL_THEME="+theme.Name, "GOWALL_WALLPAPER="+wallpaper)
+		cmd.Stdout = Messages()
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			errs = append(errs, fmt.Errorf("template hook %q: %w", hook, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,121 @@
+package image
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"regexp"
+	"sort"
+
+	"github.com/Achno/gowall/internal/backends/colorthief"
+	"github.com/Achno/gowall/internal/colorspace"
+	"github.com/Achno/gowall/utils"
+)
+
+// neutralChroma is the OKLab chroma below which a color has no meaningful hue, so it is ordered by lightness
+const neutralChroma = 0.03
+
+// themeNamePattern matches the names that are safe as theme file names and as Emacs theme symbols
+var themeNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// ValidateThemeName checks that the name can be saved as a theme file
+func ValidateThemeName(name string) error {
+	if !themeNamePattern.MatchString(name) {
+		return utils.InvalidParameter("theme name %q can only contain letters, digits, - and _", name)
+	}
+	return nil
+}
+
+// ThemeFromImage extracts a palette of up to n colors from the image and orders it with OrderPalette.
+// The first two colors are also the background and foreground roles
+func ThemeFromImage(path, name string, n int) (Theme, error) {
+	if err := ValidateThemeName(name); err != nil {
+		return Theme{}, err
+	}
+	if n < 2 {
+		return Theme{}, utils.InvalidParameter("a theme needs at least 2 colors, got %d", n)
+	}
+
+	palette, err := colorthief.GetPaletteFromFile(path, n)
+	if err != nil {
+		return Theme{}, fmt.Errorf("while extracting the palette of %s: %w", path, err)
+	}
+
+	theme := ThemeFromPalette(name, palette)
+	if len(theme.Colors) < 2 {
+		return Theme{}, fmt.Errorf("only %d distinct colors found in %s", len(theme.Colors), path)
+	}
+	return theme, nil
+}
+
+// ThemeFromPalette orders the palette with OrderPalette into a theme, with the background and foreground
+// roles set when it has at least two colors
+func ThemeFromPalette(name string, palette []color.Color) Theme {
+	colors := OrderPalette(palette)
+	theme := Theme{Name: name, Colors: colors}
+	if len(colors) >= 2 {
+		theme.Roles = ThemeRoles{Background: colors[0], Foreground: colors[1]}
+	}
+	return theme
+}
+
+// OrderPalette removes duplicate colors and sorts the palette into stable slots, so themes extracted from
+// similar images line up: the darkest color is the background, the lightest the foreground, then the accents
+// by hue starting at red and last the remaining neutral colors from dark to light. Colors are returned as color.RGBA
+func OrderPalette(palette []color.Color) []color.Color {
+	type entry struct {
+		rgba color.RGBA
+		lab  [3]float64
+	}
+
+	seen := make(map[color.RGBA]bool, len(palette))
+	entries := make([]entry, 0, len(palette))
+	for _, c := range palette {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		rgba.A = 0xff
+		if seen[rgba] {
+			continue
+		}
+		seen[rgba] = true
+		entries = append(entries, entry{rgba, colorspace.OKLab(rgba.R, rgba.G, rgba.B)})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// ties are broken by the color itself, so the order never depends on the order of the palette
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].lab[0] != entries[j].lab[0] {
+			return entries[i].lab[0] < entries[j].lab[0]
+		}
+		return RGBtoHex(entries[i].rgba) < RGBtoHex(entries[j].rgba)
+	})
+
+	ordered := []color.Color{entries[0].rgba}
+	if len(entries) == 1 {
+		return ordered
+	}
+	ordered = append(ordered, entries[len(entries)-1].rgba)
+
+	var accents, neutrals []entry
+	for _, e := range entries[1 : len(entries)-1] {
+		if math.Hypot(e.lab[1], e.lab[2]) < neutralChroma {
+			neutrals = append(neutrals, e)
+		} else {
+			accents = append(accents, e)
+		}
+	}
+
+	// by OKLab hue, red at about 29 degrees and blue at about 264. The accents are already sorted
+	// by lightness, the stable sort keeps it for equal hues
+	sort.SliceStable(accents, func(i, j int) bool {
+		return hueAngle(accents[i].lab[2], accents[i].lab[1]) < hueAngle(accents[j].lab[2], accents[j].lab[1])
+	})
+	for _, e := range accents {
+		ordered = append(ordered, e.rgba)
+	}
+	for _, e := range neutrals {
+		ordered = append(ordered, e.rgba)
+	}
+	return ordered
+}
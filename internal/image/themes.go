@@ -6,13 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"image/color"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/Achno/gowall/config"
+	"github.com/Achno/gowall/utils"
 	"gopkg.in/yaml.v2"
 )
 
@@ -20,17 +23,39 @@ import (
 type Theme struct {
 	Name   string
 	Colors []color.Color
+	Roles  ThemeRoles // optional, the role colors are also in Colors
 }
 
-// ThemeData represents the structure of an external theme file
+// ThemeData represents the structure of an external theme file.
+// A theme needs colors, roles or both, the role colors are added to its colors
 type ThemeData struct {
-	Name   string   `json:"name" yaml:"name"`
-	Colors []string `json:"colors" yaml:"colors"`
+	Name          string   `json:"name" yaml:"name"`
+	Colors        []string `json:"colors,omitempty" yaml:"colors,omitempty"`
+	ThemeRoleData `yaml:",inline"`
 }
 
-// Map of all available themes
+// Map of all available themes, use loadThemes before reading it. Themes given by path are added
+// while gowall runs, so it is only read and written through lookupTheme and registerTheme
 var themes = make(map[string]Theme)
 
+var themesOnce sync.Once
+var themesMu sync.RWMutex
+
+// lookupTheme returns the theme registered under the lowercase key
+func lookupTheme(key string) (Theme, bool) {
+	themesMu.RLock()
+	defer themesMu.RUnlock()
+	theme, ok := themes[key]
+	return theme, ok
+}
+
+// registerTheme adds the theme to the map under the lowercase key, replacing the one with the same key
+func registerTheme(key string, theme Theme) {
+	themesMu.Lock()
+	defer themesMu.Unlock()
+	themes[key] = theme
+}
+
 // Default theme directories to search
 var themeDirectories = []string{
 	"themes",                  // Local themes directory
@@ -84,14 +109,23 @@ const (
 	HashLength = 16 // Length of the color hash
 )
 
-// init loads all themes from files when the package is initialized
-func init() {
+// loadThemes loads all themes from files the first time a theme is needed,
+// so importing the package has no side effects
+func loadThemes() {
+	themesOnce.Do(loadAllThemes)
+}
+
+func loadAllThemes() {
+	config.Load()
 	loadExternalThemes()
 	loadCustomThemes() // Load from config.yml (for backward compatibility)
 
 	// If no themes were loaded, add a basic default theme as fallback
-	if len(themes) == 0 {
-		themes["default"] = Theme{
+	themesMu.RLock()
+	empty := len(themes) == 0
+	themesMu.RUnlock()
+	if empty {
+		registerTheme("default", Theme{
 			Name: "Default",
 			Colors: []color.Color{
 				color.RGBA{R: 0, G: 0, B: 0, A: 255},       // Black
@@ -100,7 +134,7 @@ func init() {
 				color.RGBA{R: 0, G: 255, B: 0, A: 255},     // Green
 				color.RGBA{R: 0, G: 0, B: 255, A: 255},     // Blue
 			},
-		}
+		})
 		log.Println("No themes found, using minimal default theme")
 	}
 }
@@ -147,14 +181,15 @@ func loadExternalThemes() {
 			}
 
 			filePath := filepath.Join(dirPath, file.Name())
-			ext := strings.ToLower(filepath.Ext(file.Name()))
+			if !hasThemeExtension(filePath) {
+				continue
+			}
 
-			// Process based on file extension
-			switch ext {
-			case ".json", ".yaml", ".yml":
-				loadJSONYAMLTheme(filePath, ext)
-			case ".el":
+			// Process based on file format
+			if strings.ToLower(filepath.Ext(filePath)) == ".el" {
 				loadEmacsTheme(filePath)
+			} else {
+				loadThemeFile(filePath)
 			}
 		}
 	}
@@ -176,13 +211,30 @@ func expandPath(path string) string {
 	return filepath.Join(home, path[1:])
 }
 
-// loadJSONYAMLTheme loads a theme from a JSON or YAML file
-func loadJSONYAMLTheme(filePath, ext string) {
+// loadThemeFile loads the themes of a file in any format of ThemeFormats, files that aren't themes are skipped
+func loadThemeFile(filePath string) {
+	fileThemes, err := ParseThemeFiles(filePath)
+	if errors.Is(err, errNotATheme) {
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	// Add the themes to the map (overwrite existing if same name)
+	for _, theme := range fileThemes {
+		registerTheme(strings.ToLower(theme.Name), theme)
+		log.Printf("loaded theme from %s: %s", filepath.Base(filePath), theme.Name)
+	}
+}
+
+// parseJSONYAMLTheme reads a theme from a JSON or YAML file without registering it
+func parseJSONYAMLTheme(filePath, ext string) (Theme, error) {
 	// Read the file
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Printf("error reading theme file %s: %v", filePath, err)
-		return
+		return Theme{}, fmt.Errorf("error reading theme file %s: %w", filePath, err)
 	}
 
 	// Parse the file
@@ -190,20 +242,24 @@ func loadJSONYAMLTheme(filePath, ext string) {
 	switch ext {
 	case ".json":
 		if err := json.Unmarshal(data, &themeData); err != nil {
-			log.Printf("error parsing JSON theme file %s: %v", filePath, err)
-			return
+			return Theme{}, fmt.Errorf("error parsing JSON theme file %s: %w", filePath, err)
 		}
 	case ".yaml", ".yml":
 		if err := yaml.Unmarshal(data, &themeData); err != nil {
-			log.Printf("error parsing YAML theme file %s: %v", filePath, err)
-			return
+			return Theme{}, fmt.Errorf("error parsing YAML theme file %s: %w", filePath, err)
 		}
+	default:
+		return Theme{}, fmt.Errorf("unsupported theme file extension: %s", ext)
 	}
 
 	// Validate theme
-	if themeData.Name == "" || len(themeData.Colors) == 0 {
-		log.Printf("invalid theme in %s: missing name or colors", filePath)
-		return
+	if themeData.Name == "" || (len(themeData.Colors) == 0 && themeData.ThemeRoleData == ThemeRoleData{}) {
+		return Theme{}, fmt.Errorf("invalid theme in %s: missing name or colors", filePath)
+	}
+
+	roles, err := parseRoles(themeData.ThemeRoleData)
+	if err != nil {
+		return Theme{}, fmt.Errorf("invalid theme %s (%s): %w", themeData.Name, filePath, err)
 	}
 
 	// Convert hex colors to RGBA
@@ -211,29 +267,47 @@ func loadJSONYAMLTheme(filePath, ext string) {
 	for _, hexColor := range themeData.Colors {
 		rgba, err := HexToRGBA(hexColor)
 		if err != nil {
-			log.Printf("invalid color %s in theme %s (%s): %v",
+			return Theme{}, fmt.Errorf("invalid color %s in theme %s (%s): %w",
 				hexColor, themeData.Name, filePath, err)
-			return
 		}
 		rgbaColors = append(rgbaColors, rgba)
 	}
 
-	// Add theme to map (overwrite existing if same name)
-	themeName := strings.ToLower(themeData.Name)
-	themes[themeName] = Theme{
+	return Theme{
 		Name:   themeData.Name,
-		Colors: rgbaColors,
-	}
-	log.Printf("loaded theme from %s: %s", ext, themeData.Name)
+		Colors: mergeRoleColors(rgbaColors, roles),
+		Roles:  roles,
+	}, nil
 }
 
 // loadEmacsTheme loads a theme from an Emacs theme file (.el)
 func loadEmacsTheme(filePath string) {
+	theme, err := parseEmacsTheme(filePath)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	// Add the theme with the normalized name
+	themeKey := strings.ToLower(theme.Name)
+	registerTheme(themeKey, theme)
+	log.Printf("loaded Emacs theme: %s with %d colors", theme.Name, len(theme.Colors))
+
+	// Also register by filepath (case insensitive) to handle direct file references
+	filePathKey := strings.ToLower(filePath)
+	registerTheme(filePathKey, Theme{
+		Name:   theme.Name + " (from " + filepath.Base(filePath) + ")",
+		Colors: theme.Colors,
+		Roles:  theme.Roles,
+	})
+}
+
+// parseEmacsTheme reads a theme from an Emacs theme file (.el) without registering it
+func parseEmacsTheme(filePath string) (Theme, error) {
 	// Open the file
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Printf("error reading Emacs theme file %s: %v", filePath, err)
-		return
+		return Theme{}, fmt.Errorf("error reading Emacs theme file %s: %w", filePath, err)
 	}
 
 	fileContent := string(data)
@@ -249,8 +323,7 @@ func loadEmacsTheme(filePath string) {
 	// Set of patterns to extract colors from Emacs themes
 	hexColors := extractEmacsThemeColors(fileContent)
 	if len(hexColors) == 0 {
-		log.Printf("no valid colors found in Emacs theme %s", filePath)
-		return
+		return Theme{}, fmt.Errorf("no valid colors found in Emacs theme %s", filePath)
 	}
 
 	// Convert hex colors to RGBA
@@ -264,20 +337,29 @@ func loadEmacsTheme(filePath string) {
 		rgbaColors = append(rgbaColors, rgba)
 	}
 
-	// Add the theme with the normalized name
-	themeKey := strings.ToLower(themeName)
-	themes[themeKey] = Theme{
+	return Theme{
 		Name:   themeName,
 		Colors: rgbaColors,
-	}
-	log.Printf("loaded Emacs theme: %s with %d colors", themeName, len(rgbaColors))
+		Roles:  parseEmacsRoles(fileContent),
+	}, nil
+}
 
-	// Also register by filepath (case insensitive) to handle direct file references
-	filePathKey := strings.ToLower(filePath)
-	themes[filePathKey] = Theme{
-		Name:   themeName + " (from " + baseName + ")",
-		Colors: rgbaColors,
+// ParseThemeFile reads a theme file in any format of ThemeFormats and returns the theme
+// without adding it to the available themes. Files with several themes are an error, see ParseThemeFiles
+func ParseThemeFile(filePath string) (Theme, error) {
+	fileThemes, err := ParseThemeFiles(filePath)
+	if err != nil {
+		return Theme{}, err
+	}
+	if len(fileThemes) > 1 {
+		names := make([]string, 0, len(fileThemes))
+		for _, theme := range fileThemes {
+			names = append(names, theme.Name)
+		}
+		return Theme{}, fmt.Errorf("%s has %d themes (%s), put it in a theme directory and use one by name",
+			filePath, len(fileThemes), strings.Join(names, ", "))
 	}
+	return fileThemes[0], nil
 }
 
 // extractEmacsThemeColors extracts unique hex color codes from Emacs theme content
@@ -335,43 +417,47 @@ func loadCustomThemes() {
 
 		if valid {
 			themeName := strings.ToLower(tw.Name)
-			themes[themeName] = theme
+			registerTheme(themeName, theme)
 			log.Printf("loaded custom theme from config.yml: %s", tw.Name)
 		}
 	}
 }
 
-// SaveThemeToFile saves a theme to an external file in the specified format
-func SaveThemeToFile(theme Theme, format string) error {
+// SaveThemeToFile saves a theme to an external file in the specified format and returns the file path
+func SaveThemeToFile(theme Theme, format string) (string, error) {
 	// Get appropriate theme directory based on format
 	themeDir, err := getThemeDirectory(format)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Create theme directory if it doesn't exist
 	if err := os.MkdirAll(themeDir, DirPermissions); err != nil {
-		return fmt.Errorf("creating theme directory %s: %w", themeDir, err)
+		return "", fmt.Errorf("creating theme directory %s: %w", themeDir, err)
 	}
 
 	// Convert colors to hex strings
 	hexColors, err := themeColorsToHex(theme.Colors)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Generate file content based on format
-	filePath, data, err := generateThemeFile(themeDir, theme.Name, hexColors, format)
+	filePath, data, err := generateThemeFile(themeDir, theme.Name, hexColors, theme.Roles, format)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// Write to file
-	if err := os.WriteFile(filePath, data, FilePermissions); err != nil {
-		return fmt.Errorf("writing theme file: %w", err)
+	err = utils.WriteFileAtomic(filePath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("writing theme file: %w", err)
 	}
 
-	return nil
+	return filePath, nil
 }
 
 // getThemeDirectory returns the appropriate directory for the theme based on format
@@ -403,7 +489,7 @@ func themeColorsToHex(colors []color.Color) ([]string, error) {
 }
 
 // generateThemeFile creates the theme file content based on format
-func generateThemeFile(dir, themeName string, hexColors []string, format string) (string, []byte, error) {
+func generateThemeFile(dir, themeName string, hexColors []string, roles ThemeRoles, format string) (string, []byte, error) {
 	var filePath string
 	var data []byte
 	var err error
@@ -414,15 +500,15 @@ func generateThemeFile(dir, themeName string, hexColors []string, format string)
 	switch format {
 	case "json":
 		filePath = filepath.Join(dir, themeNameLower+".json")
-		data, err = generateJSONTheme(themeName, hexColors)
+		data, err = generateJSONTheme(themeName, hexColors, roles)
 
 	case "yaml", "yml":
 		filePath = filepath.Join(dir, themeNameLower+".yaml")
-		data, err = generateYAMLTheme(themeName, hexColors)
+		data, err = generateYAMLTheme(themeName, hexColors, roles)
 
 	case "emacs", "el":
 		filePath = filepath.Join(dir, themeNameLower+"-theme.el")
-		data = generateEmacsTheme(themeName, hexColors)
+		data = generateEmacsTheme(themeName, hexColors, roles)
 
 	default:
 		err = fmt.Errorf("unsupported format: %s", format)
@@ -436,25 +522,27 @@ func generateThemeFile(dir, themeName string, hexColors []string, format string)
 }
 
 // generateJSONTheme generates JSON content for a theme
-func generateJSONTheme(name string, colors []string) ([]byte, error) {
+func generateJSONTheme(name string, colors []string, roles ThemeRoles) ([]byte, error) {
 	themeData := ThemeData{
-		Name:   name,
-		Colors: colors,
+		Name:          name,
+		Colors:        colors,
+		ThemeRoleData: roleData(roles),
 	}
 	return json.MarshalIndent(themeData, "", "  ")
 }
 
 // generateYAMLTheme generates YAML content for a theme
-func generateYAMLTheme(name string, colors []string) ([]byte, error) {
+func generateYAMLTheme(name string, colors []string, roles ThemeRoles) ([]byte, error) {
 	themeData := ThemeData{
-		Name:   name,
-		Colors: colors,
+		Name:          name,
+		Colors:        colors,
+		ThemeRoleData: roleData(roles),
 	}
 	return yaml.Marshal(themeData)
 }
 
 // generateEmacsTheme generates Emacs Lisp content for a theme
-func generateEmacsTheme(themeName string, hexColors []string) []byte {
+func generateEmacsTheme(themeName string, hexColors []string, roles ThemeRoles) []byte {
 	var content strings.Builder
 	themeNameLower := strings.ToLower(themeName)
 
@@ -483,6 +571,15 @@ func generateEmacsTheme(themeName string, hexColors []string) []byte {
 			content.WriteString("\n")
 		}
 	}
+
+	// Bind the roles as well, the faces use them and parseEmacsTheme reads them back
+	roleNames := RoleNames()
+	data := roleData(roles)
+	for i, hexColor := range data.slots() {
+		if *hexColor != "" {
+			fmt.Fprintf(&content, "\n      (role-%s \"%s\")", roleNames[i], *hexColor)
+		}
+	}
 	content.WriteString(")\n\n")
 
 	// Define face customizations
@@ -490,7 +587,7 @@ func generateEmacsTheme(themeName string, hexColors []string) []byte {
 	content.WriteString("   '" + themeNameLower + "\n")
 
 	// Map the colors to appropriate Emacs faces
-	defineEmacsFaces(&content, hexColors)
+	defineEmacsFaces(&content, hexColors, roles)
 
 	// Close custom-theme-set-faces
 	content.WriteString("   )\n")
@@ -506,8 +603,15 @@ func generateEmacsTheme(themeName string, hexColors []string) []byte {
 	return []byte(content.String())
 }
 
-// defineEmacsFaces writes face definitions to the theme content
-func defineEmacsFaces(content *strings.Builder, hexColors []string) {
+// defineEmacsFaces writes face definitions to the theme content.
+// The faces the roles cover use them, the rest guess a color by index
+func defineEmacsFaces(content *strings.Builder, hexColors []string, roles ThemeRoles) {
+	defined := make(map[string]bool)
+	for _, face := range emacsRoleFaces(roles) {
+		fmt.Fprintf(content, "   `(%s ((,class (%s))))\n", face.faceName, face.props)
+		defined[face.faceName] = true
+	}
+
 	numColors := len(hexColors)
 	faceDefinitions := []struct {
 		minColors int
@@ -531,7 +635,7 @@ func defineEmacsFaces(content *strings.Builder, hexColors []string) {
 
 	// Add standard faces
 	for _, face := range faceDefinitions {
-		if numColors >= face.minColors {
+		if numColors >= face.minColors && !defined[face.faceName] {
 			fmt.Fprintf(content, "   `(%s ((,class (:%s ,color-%d))))\n",
 				face.faceName, face.propType, face.colorIdx)
 		}
@@ -539,12 +643,50 @@ func defineEmacsFaces(content *strings.Builder, hexColors []string) {
 
 	// Add fallbacks for themes with fewer colors
 	if numColors < 12 {
-		addFallbackFaces(content, numColors)
+		addFallbackFaces(content, numColors, defined)
+	}
+}
+
+// emacsFace is a face and its properties, e.g. ":foreground ,role-color2"
+type emacsFace struct {
+	faceName string
+	props    string
+}
+
+// emacsRoleFaces returns the faces the roles cover, with the conventional terminal colors
+// for syntax: comments in bright black, keywords in magenta, strings in green...
+func emacsRoleFaces(roles ThemeRoles) []emacsFace {
+	var faces []emacsFace
+	// add defines the face with the property, role pairs whose role is set
+	add := func(faceName string, props ...string) {
+		var set []string
+		for i := 0; i+1 < len(props); i += 2 {
+			if roles.Get(props[i+1]) != nil {
+				set = append(set, ":"+props[i]+" ,role-"+props[i+1])
+			}
+		}
+		if len(set) > 0 {
+			faces = append(faces, emacsFace{faceName, strings.Join(set, " ")})
+		}
 	}
+
+	add("default", "foreground", "foreground", "background", "background")
+	add("cursor", "background", "cursor")
+	add("fringe", "background", "background")
+	add("region", "background", "selection")
+	add("highlight", "background", "color8")
+	add("font-lock-builtin-face", "foreground", "color6")
+	add("font-lock-comment-face", "foreground", "color8")
+	add("font-lock-function-name-face", "foreground", "color4")
+	add("font-lock-keyword-face", "foreground", "color5")
+	add("font-lock-string-face", "foreground", "color2")
+	add("font-lock-type-face", "foreground", "color3")
+	add("font-lock-variable-name-face", "foreground", "color1")
+	return faces
 }
 
-// addFallbackFaces adds fallback face definitions for themes with fewer colors
-func addFallbackFaces(content *strings.Builder, numColors int) {
+// addFallbackFaces adds fallback face definitions for themes with fewer colors, except the faces already defined
+func addFallbackFaces(content *strings.Builder, numColors int, defined map[string]bool) {
 	// Use modulo to reuse available colors
 	fallbacks := []struct {
 		faceName string
@@ -558,6 +700,9 @@ func addFallbackFaces(content *strings.Builder, numColors int) {
 	}
 
 	for _, fb := range fallbacks {
+		if defined[fb.faceName] {
+			continue
+		}
 		colorIdx := (numColors + fb.offset) % numColors
 		fmt.Fprintf(content, "   `(%s ((,class (:foreground ,color-%d))))\n",
 			fb.faceName, colorIdx)
@@ -596,6 +741,9 @@ func RGBtoHex(c color.RGBA) string {
 
 // ListThemes returns a slice of all available theme names
 func ListThemes() []string {
+	loadThemes()
+	themesMu.RLock()
+	defer themesMu.RUnlock()
 	allThemes := make([]string, 0, len(themes))
 	for theme := range themes {
 		allThemes = append(allThemes, theme)
@@ -605,19 +753,20 @@ func ListThemes() []string {
 
 // SelectTheme returns a theme by name or an error if not found
 func SelectTheme(theme string) (Theme, error) {
+	loadThemes()
 	// Check if the theme already exists by name
 	themeLower := strings.ToLower(theme)
-	if selectedTheme, exists := themes[themeLower]; exists {
+	if selectedTheme, exists := lookupTheme(themeLower); exists {
 		return selectedTheme, nil
 	}
 
-	// Check if it's a file path to an Emacs theme
-	if isEmacsThemeFile(theme) {
-		loadEmacsTheme(theme)
+	// Check if it's a file path to a theme
+	if isThemeFile(theme) {
+		loadThemePath(theme)
 
 		// Check if loading was successful
 		themeLower = strings.ToLower(theme)
-		if selectedTheme, exists := themes[themeLower]; exists {
+		if selectedTheme, exists := lookupTheme(themeLower); exists {
 			return selectedTheme, nil
 		}
 	}
@@ -625,37 +774,60 @@ func SelectTheme(theme string) (Theme, error) {
 	// Try expanding tilde if present
 	if strings.HasPrefix(theme, "~") {
 		expandedPath := expandPath(theme)
-		if expandedPath != "" && isEmacsThemeFile(expandedPath) {
-			loadEmacsTheme(expandedPath)
+		if expandedPath != "" && isThemeFile(expandedPath) {
+			loadThemePath(expandedPath)
 
 			// Check if loading was successful
 			expandedPathLower := strings.ToLower(expandedPath)
-			if selectedTheme, exists := themes[expandedPathLower]; exists {
+			if selectedTheme, exists := lookupTheme(expandedPathLower); exists {
 				return selectedTheme, nil
 			}
 		}
 	}
 
 	// Unable to find or load the theme
-	return Theme{}, fmt.Errorf("unknown theme: %s", theme)
+	return Theme{}, fmt.Errorf("%w: %s", utils.ErrUnknownTheme, theme)
 }
 
-// isEmacsThemeFile checks if a path points to an existing .el file
-func isEmacsThemeFile(path string) bool {
+// isThemeFile checks if a path points to an existing file with the extension of a theme format
+func isThemeFile(path string) bool {
 	// Check if file exists
 	info, err := os.Stat(path)
 	if err != nil || info.IsDir() {
 		return false
 	}
 
-	// Check extension
-	return strings.ToLower(filepath.Ext(path)) == ".el"
+	return hasThemeExtension(path)
+}
+
+// hasThemeExtension checks if the file has the extension of a theme format, or none like Ghostty themes
+func hasThemeExtension(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case "", ".json", ".yaml", ".yml", ".el", ".toml", ".conf", ".ghostty", ".itermcolors", ".xresources", ".xdefaults":
+		return true
+	}
+	return false
+}
+
+// loadThemePath registers the theme of a file by its path, Emacs themes also by their name
+func loadThemePath(filePath string) {
+	if strings.ToLower(filepath.Ext(filePath)) == ".el" {
+		loadEmacsTheme(filePath)
+		return
+	}
+
+	theme, err := ParseThemeFile(filePath)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	registerTheme(strings.ToLower(filePath), theme)
 }
 
-// ThemeExists checks if a theme exists by name
+// ThemeExists checks if a theme exists by name or is a theme file
 func ThemeExists(theme string) bool {
-	_, exists := themes[strings.ToLower(theme)]
-	return exists
+	_, err := SelectTheme(theme)
+	return err == nil
 }
 
 // GetThemeColors returns the colors of a theme in hex code format
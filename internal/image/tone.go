@@ -0,0 +1,125 @@
+package image
+
+import (
+	"image"
+	"image/draw"
+	"strings"
+
+	haldclut "github.com/Achno/gowall/internal/backends/colorthief/haldClut"
+	"github.com/Achno/gowall/internal/colorspace"
+	"github.com/Achno/gowall/utils"
+)
+
+// ToneOptions keep part of the original image in a theme conversion, so it doesn't lose its contrast and texture
+// when the theme has few dark or light colors. Both work in OKLab, where lightness is separate from hue and chroma
+type ToneOptions struct {
+	PreserveLuminance float64 // fraction of the original lightness kept in [0,1], 1 maps only hue and chroma to the theme
+	Strength          float64 // mix of the converted image over the original in (0,1], 0 means 1
+}
+
+// ValidateTone checks that both options are in range
+func ValidateTone(tone ToneOptions) error {
+	if tone.PreserveLuminance < 0 || tone.PreserveLuminance > 1 {
+		return utils.InvalidParameter("preserve luminance must be between 0 and 1, got %v", tone.PreserveLuminance)
+	}
+	if tone.Strength < 0 || tone.Strength > 1 {
+		return utils.InvalidParameter("strength must be between 0 and 1, got %v", tone.Strength)
+	}
+	return nil
+}
+
+// active reports whether the options change the converted image at all
+func (tone ToneOptions) active() bool {
+	return tone.PreserveLuminance > 0 || (tone.Strength > 0 && tone.Strength < 1)
+}
+
+// adjust returns the converted color with the original lightness blended in, mixed over the original color
+func (tone ToneOptions) adjust(original, converted [3]uint8) [3]uint8 {
+	strength := tone.Strength
+	if strength == 0 {
+		strength = 1
+	}
+
+	o := colorspace.OKLab(original[0], original[1], original[2])
+	c := colorspace.OKLab(converted[0], converted[1], converted[2])
+	c[0] += (o[0] - c[0]) * tone.PreserveLuminance
+	for i := range c {
+		c[i] = o[i] + (c[i]-o[i])*strength
+	}
+
+	r, g, b := colorspace.OKLabToSRGB(c)
+	return [3]uint8{r, g, b}
+}
+
+// ApplyThemeCLUT maps the image through the CLUT with the interpolation and the tone options.
+// The tone is baked into the CLUT, which is a lookup like any other, unless the image has fewer pixels than the CLUT.
+// Images with straight alpha are looked up as they are, so translucent pixels keep their exact color
+func ApplyThemeCLUT(img image.Image, clut *image.RGBA, level int, interpolation string, tone ToneOptions) image.Image {
+	bounds := img.Bounds()
+	options := haldclut.ApplyOptions{Interpolation: haldclut.Interpolation(strings.ToLower(interpolation))}
+
+	adjustPixels := tone.active() && bounds.Dx()*bounds.Dy() < clut.Bounds().Dx()*clut.Bounds().Dy()
+	if tone.active() && !adjustPixels {
+		clut = toneCLUT(clut, level, tone)
+	}
+
+	var newImg image.Image
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		newImg = haldclut.ApplyCLUTNRGBA(nrgba, clut, level, options)
+	} else {
+		rgba := image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+		newImg = haldclut.ApplyCLUT(rgba, clut, level, options)
+	}
+
+	if adjustPixels {
+		return AdjustTone(img, newImg, tone)
+	}
+	return newImg
+}
+
+// toneCLUT returns a copy of the CLUT with the tone applied to every entry, against the color it maps from
+func toneCLUT(clut *image.RGBA, level int, tone ToneOptions) *image.RGBA {
+	identity, err := haldclut.GenerateIdentityCLUT(level)
+	if err != nil {
+		return clut
+	}
+
+	// both CLUTs are opaque and the same size, their pixels line up
+	bounds := clut.Bounds()
+	newClut := image.NewRGBA(bounds)
+	parallelRows(bounds, func(minY, maxY int) {
+		for i := (minY - bounds.Min.Y) * clut.Stride; i < (maxY-bounds.Min.Y)*clut.Stride; i += 4 {
+			adjusted := tone.adjust([3]uint8(identity.Pix[i:i+3]), [3]uint8(clut.Pix[i:i+3]))
+			setNRGBA(newClut.Pix[i:], [4]uint8{adjusted[0], adjusted[1], adjusted[2]}, 0xff)
+		}
+	})
+	return newClut
+}
+
+// AdjustTone applies the tone options to a converted image, against the original image it was converted from.
+// Only the colors change, the converted image keeps its alpha
+func AdjustTone(original image.Image, converted image.Image, tone ToneOptions) image.Image {
+	if !tone.active() {
+		return converted
+	}
+
+	src := newPixelReader(original)
+	dst := newPixelReader(converted)
+	offset := original.Bounds().Min.Sub(converted.Bounds().Min)
+
+	bounds := converted.Bounds()
+	newImg := image.NewNRGBA(bounds)
+	parallelRows(bounds, func(minY, maxY int) {
+		for y := minY; y < maxY; y++ {
+			row := newImg.Pix[(y-bounds.Min.Y)*newImg.Stride:]
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, _ := src.nrgba(x+offset.X, y+offset.Y)
+				cr, cg, cb, ca := dst.nrgba(x, y)
+				adjusted := tone.adjust([3]uint8{r, g, b}, [3]uint8{cr, cg, cb})
+				setNRGBA(row[(x-bounds.Min.X)*4:], [4]uint8{adjusted[0], adjusted[1], adjusted[2]}, ca)
+			}
+		}
+	})
+	return newImg
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"os"
@@ -19,7 +20,13 @@ type UpscaleProcessor struct {
 	ModelName  string
 }
 
-func (p *UpscaleProcessor) Process(img image.Image, theme string) (image.Image, error) {
+func (p *UpscaleProcessor) Process(ctx context.Context, img image.Image, theme string) (image.Image, error) {
+
+	// validate params first, so a bad scale or model is reported as such even without the upscaler
+	err := p.validateParams()
+	if err != nil {
+		return nil, fmt.Errorf("while validating parameters: %w", err)
+	}
 
 	// get upscaler directory
 	dirFolder, err := utils.CreateDirectory()
@@ -31,22 +38,21 @@ func (p *UpscaleProcessor) Process(img image.Image, theme string) (image.Image,
 	// setup upscaler if it has not been already
 	if _, err := os.Stat(destFolder); os.IsNotExist(err) {
 
-		ok := utils.Confirm(utils.BlueColor + "◈ It seems that the upscaler is not setup yet, would you like for gowall to set it up" + utils.ResetColor)
+		ok, err := utils.Confirm(ctx, Messages(), utils.BlueColor+"◈ It seems that the upscaler is not setup yet, would you like for gowall to set it up"+utils.ResetColor)
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
-			return nil, fmt.Errorf("the upscaler has not been setup")
+			return nil, fmt.Errorf("%w: the upscaler has not been setup", utils.ErrUpscalerMissing)
+		}
+		if err := upscaler.SetupUpscaler(Messages()); err != nil {
+			return nil, fmt.Errorf("%w: while setting up the upscaler: %w", utils.ErrUpscalerMissing, err)
 		}
-		upscaler.SetupUpscaler()
 	}
 
 	binary, err := findRealESRGANBinary(destFolder)
 	if err != nil {
-		return nil, fmt.Errorf("while finding upscaler binary : %w", err)
-	}
-
-	// validate params
-	err = p.validateParams()
-	if err != nil {
-		return nil, fmt.Errorf("while validating parameters: %w", err)
+		return nil, fmt.Errorf("%w: while finding upscaler binary : %w", utils.ErrUpscalerMissing, err)
 	}
 
 	// construct outputFile
@@ -56,11 +62,16 @@ func (p *UpscaleProcessor) Process(img image.Image, theme string) (image.Image,
 
 	// construct model path
 
-	cmd := exec.Command(binary, "-i", p.InputFile, "-o", outputFile, "-s", fmt.Sprintf("%d", p.Scale))
-	cmd.Stdout = os.Stdout
+	cmd := exec.CommandContext(ctx, binary, "-i", p.InputFile, "-o", outputFile, "-s", fmt.Sprintf("%d", p.Scale))
+	cmd.Stdout = Messages()
 	cmd.Stderr = os.Stderr
 
 	err = cmd.Run()
+	if ctx.Err() != nil {
+		// the upscaler got killed, remove its partially written output
+		os.Remove(outputFile)
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		exitError, ok := err.(*exec.ExitError)
 		if ok && exitError.ExitCode() == 255 {
@@ -72,14 +83,19 @@ func (p *UpscaleProcessor) Process(img image.Image, theme string) (image.Image,
 	return nil, nil
 }
 
+// Validate checks the input file, scale and model name
+func (p *UpscaleProcessor) Validate(theme string) error {
+	return p.validateParams()
+}
+
 func (p *UpscaleProcessor) validateParams() error {
 
 	if _, err := os.Stat(p.InputFile); os.IsNotExist(err) {
-		return fmt.Errorf("this path does not exist")
+		return fmt.Errorf("this path does not exist: %w", os.ErrNotExist)
 	}
 
 	if p.Scale < 2 || p.Scale > 4 {
-		return fmt.Errorf("the upscale ratio is invalid")
+		return utils.InvalidParameter("the upscale ratio is invalid")
 	}
 
 	modelNames := map[string]bool{
@@ -91,7 +107,7 @@ func (p *UpscaleProcessor) validateParams() error {
 
 	_, exists := modelNames[p.ModelName]
 	if !exists {
-		return fmt.Errorf("invalid Model name")
+		return utils.InvalidParameter("invalid Model name")
 	}
 
 	return nil
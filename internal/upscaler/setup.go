@@ -12,7 +12,8 @@ import (
 	"github.com/Achno/gowall/utils"
 )
 
-func SetupUpscaler() error {
+// SetupUpscaler downloads Real-ESRGAN into the gowall directory, the progress is printed to w
+func SetupUpscaler(w io.Writer) error {
 
 	// Make sure the gowall directory is created first
 	dirFolder, err := utils.CreateDirectory()
@@ -39,7 +40,7 @@ func SetupUpscaler() error {
 		return fmt.Errorf("Unsupported OS: %s\n Only available for linux,mac,windows", runtime.GOOS)
 	}
 
-	fmt.Println(utils.BlueColor + " ➜ Downloading models sit back and relax,might take a bit" + utils.ResetColor)
+	fmt.Fprintln(w, utils.BlueColor+" ➜ Downloading models sit back and relax,might take a bit"+utils.ResetColor)
 	// download model
 	err = utils.DownloadUrl(url, zipPath)
 	if err != nil {
@@ -51,7 +52,7 @@ func SetupUpscaler() error {
 	if err != nil {
 		return fmt.Errorf("failed to create folder: %v", err)
 	}
-	fmt.Println(utils.BlueColor + " ➜ Folder created" + utils.ResetColor)
+	fmt.Fprintln(w, utils.BlueColor+" ➜ Folder created"+utils.ResetColor)
 
 	// Extract  zip
 	err = extractZip(zipPath, destFolder)
@@ -64,9 +65,9 @@ func SetupUpscaler() error {
 	if err != nil {
 		return fmt.Errorf("while cleaning up : %v", err)
 	}
-	fmt.Println(utils.BlueColor + " ➜ Cleaning up" + utils.ResetColor)
+	fmt.Fprintln(w, utils.BlueColor+" ➜ Cleaning up"+utils.ResetColor)
 
-	fmt.Println(utils.BlueColor + " ➜ Process complete. Upscaler setup" + utils.ResetColor)
+	fmt.Fprintln(w, utils.BlueColor+" ➜ Process complete. Upscaler setup"+utils.ResetColor)
 	return nil
 
 }
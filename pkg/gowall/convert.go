@@ -0,0 +1,190 @@
+package gowall
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+
+	haldclut "github.com/Achno/gowall/internal/backends/colorthief/haldClut"
+	gimage "github.com/Achno/gowall/internal/image"
+	"github.com/Achno/gowall/utils"
+)
+
+// Backend selects the algorithm used to map colors to the theme
+type Backend string
+
+const (
+	// BackendCLUT interpolates between theme colors with a HaldCLUT (gowall's default)
+	BackendCLUT Backend = "clut"
+	// BackendNearestNeighbour replaces every pixel with the closest theme color
+	BackendNearestNeighbour Backend = "nn"
+)
+
+// Metric is the color distance used by BackendNearestNeighbour to find the closest theme color
+type Metric string
+
+const (
+	MetricWeightedRGB Metric = gimage.MetricWeightedRGB // luma weighted RGB (default)
+	MetricCIE76       Metric = gimage.MetricCIE76
+	MetricCIE94       Metric = gimage.MetricCIE94
+	MetricCIEDE2000   Metric = gimage.MetricCIEDE2000
+	MetricOKLab       Metric = gimage.MetricOKLab
+)
+
+// Dither is the dithering applied when mapping to the theme colors
+type Dither string
+
+const (
+	DitherNone           Dither = gimage.DitherNone
+	DitherFloydSteinberg Dither = gimage.DitherFloydSteinberg
+	DitherAtkinson       Dither = gimage.DitherAtkinson
+	DitherSierra         Dither = gimage.DitherSierra
+	DitherBayer2         Dither = gimage.DitherBayer2
+	DitherBayer4         Dither = gimage.DitherBayer4
+	DitherBayer8         Dither = gimage.DitherBayer8
+	DitherBlueNoise      Dither = gimage.DitherBlueNoise
+)
+
+// Interpolation selects how colors between the lattice points of the CLUT are computed
+type Interpolation string
+
+const (
+	InterpolationNearest     Interpolation = Interpolation(haldclut.InterpolationNearest) // default
+	InterpolationTrilinear   Interpolation = Interpolation(haldclut.InterpolationTrilinear)
+	InterpolationTetrahedral Interpolation = Interpolation(haldclut.InterpolationTetrahedral)
+)
+
+// Mapper selects how the CLUT blends the theme colors
+type Mapper string
+
+const (
+	MapperRBF       Mapper = haldclut.MapperRBF // default
+	MapperShepard   Mapper = haldclut.MapperShepard
+	MapperOKLabRBF  Mapper = haldclut.MapperOKLabRBF
+	MapperKNearest  Mapper = haldclut.MapperKNearest
+	MapperLuminance Mapper = haldclut.MapperLuminance
+)
+
+// MapperOptions are the parameters of the mapper, zero fields use the mapper's defaults
+type MapperOptions = haldclut.MapperOptions
+
+// DefaultCLUTLevel is the HaldCLUT level used when ThemeConverter.Level is not set
+const DefaultCLUTLevel = 8
+
+// ThemeConverter converts images to the colors of a theme.
+// The CLUT is generated in memory on first use and reused afterwards, nothing is cached on disk
+type ThemeConverter struct {
+	Theme   Theme
+	Backend Backend // defaults to BackendCLUT
+	Level   int     // HaldCLUT level in [4,16], defaults to DefaultCLUTLevel
+	Metric  Metric  // distance of BackendNearestNeighbour, defaults to MetricWeightedRGB
+	// Interpolation between the lattice points of the CLUT, defaults to InterpolationNearest, InterpolationTetrahedral gives the smoothest gradients
+	Interpolation Interpolation
+	Mapper        Mapper // defaults to MapperRBF
+	MapperOptions MapperOptions
+	// Dither maps to the theme colors with a dithering pattern, any mode but DitherNone implies BackendNearestNeighbour
+	Dither         Dither
+	DitherStrength float64 // in (0,1], 0 means 1
+	// PreserveLuminance keeps this fraction of the original lightness in [0,1], 1 maps only hue and chroma to the theme
+	PreserveLuminance float64
+	Strength          float64 // mix of the converted image over the original in (0,1], 0 means 1
+
+	mu      sync.Mutex
+	clut    *image.RGBA
+	clutKey string // level and mapper the CLUT was generated with
+}
+
+// NewThemeConverter returns a ThemeConverter for the theme using the CLUT backend
+func NewThemeConverter(theme Theme) *ThemeConverter {
+	return &ThemeConverter{Theme: theme, Backend: BackendCLUT, Level: DefaultCLUTLevel}
+}
+
+func (c *ThemeConverter) Process(ctx context.Context, img image.Image) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(c.Theme.Colors) == 0 {
+		return nil, utils.InvalidParameter("theme %q has no colors", c.Theme.Name)
+	}
+
+	tone := gimage.ToneOptions{PreserveLuminance: c.PreserveLuminance, Strength: c.Strength}
+	if err := gimage.ValidateTone(tone); err != nil {
+		return nil, err
+	}
+
+	backend := c.Backend
+	if c.Dither != "" && c.Dither != DitherNone {
+		backend = BackendNearestNeighbour
+	}
+
+	switch backend {
+	case BackendNearestNeighbour:
+		newImg, err := gimage.NearestNeighbour(ctx, img, c.Theme, gimage.NNOptions{
+			Metric:         string(c.Metric),
+			Dither:         string(c.Dither),
+			DitherStrength: c.DitherStrength,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return gimage.AdjustTone(img, newImg, tone), nil
+
+	case BackendCLUT, "":
+		level := c.level()
+		if err := gimage.ValidateCLUTLevel(level); err != nil {
+			return nil, err
+		}
+		interpolation := c.Interpolation
+		if interpolation == "" {
+			interpolation = InterpolationNearest
+		}
+		if err := gimage.ValidateInterpolation(string(interpolation)); err != nil {
+			return nil, err
+		}
+
+		clut, err := c.loadCLUT(ctx, level)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return gimage.ApplyThemeCLUT(img, clut, level, string(interpolation), tone), nil
+
+	default:
+		return nil, utils.InvalidParameter("unknown backend: %s", c.Backend)
+	}
+}
+
+func (c *ThemeConverter) level() int {
+	if c.Level == 0 {
+		return DefaultCLUTLevel
+	}
+	return c.Level
+}
+
+// loadCLUT generates the CLUT on first use and again when the level or the mapper changes,
+// a cancelled generation is retried on the next call
+func (c *ThemeConverter) loadCLUT(ctx context.Context, level int) (*image.RGBA, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := fmt.Sprintf("%d %s %+v", level, c.Mapper, c.MapperOptions)
+	if c.clut != nil && c.clutKey == key {
+		return c.clut, nil
+	}
+
+	mapper, err := haldclut.NewMapper(string(c.Mapper), c.MapperOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	clut, err := gimage.GenerateThemeCLUT(ctx, c.Theme, level, mapper)
+	if err != nil {
+		return nil, err
+	}
+	c.clut, c.clutKey = clut, key
+	return clut, nil
+}
@@ -0,0 +1,61 @@
+package gowall
+
+import (
+	"image/color"
+
+	gimage "github.com/Achno/gowall/internal/image"
+)
+
+// Invert inverts the colors of the image
+func Invert() Processor {
+	return adapt(&gimage.Inverter{})
+}
+
+// Flip flips the image horizontally
+func Flip() Processor {
+	return adapt(&gimage.FlipProcessor{})
+}
+
+// Mirror mirrors the left half of the image onto the right half
+func Mirror() Processor {
+	return adapt(&gimage.MirrorProcessor{})
+}
+
+// Grayscale converts the image to shades of gray
+func Grayscale() Processor {
+	return adapt(&gimage.GrayScaleProcessor{})
+}
+
+// Brightness scales the brightness by factor (0.0,10.0], 1.2 increases it by 20%
+func Brightness(factor float64) Processor {
+	return adapt(&gimage.BrightnessProcessor{Factor: factor})
+}
+
+// Pixelate turns the image to pixel art, scale [1-25] where lower means more pixelation
+func Pixelate(scale float64) Processor {
+	return adapt(&gimage.PixelateProcessor{Scale: scale})
+}
+
+// Border draws a border of the given color and thickness in pixels
+func Border(clr color.Color, thickness int) Processor {
+	return adapt(&gimage.DrawProcessor{
+		Color:           color.RGBAModel.Convert(clr).(color.RGBA),
+		BorderThickness: thickness,
+	})
+}
+
+// ReplaceColor replaces every color within threshold (euclidean RGB distance) of "from" with "to"
+func ReplaceColor(from, to color.Color, threshold float64) Processor {
+	return adapt(&gimage.ReplaceProcessor{
+		FromColor: gimage.RGBtoHex(color.RGBAModel.Convert(from).(color.RGBA)),
+		ToColor:   gimage.RGBtoHex(color.RGBAModel.Convert(to).(color.RGBA)),
+		Threshold: threshold,
+	})
+}
+
+// RemoveBackground makes the background of the image transparent using k-means clustering
+func RemoveBackground() Processor {
+	processor := &gimage.BackgroundProcessor{}
+	processor.SetOptions()
+	return adapt(processor)
+}
@@ -0,0 +1,11 @@
+package gowall
+
+import "github.com/Achno/gowall/utils"
+
+// Classes of errors returned by this package, check them with errors.Is
+var (
+	ErrUnknownTheme      = utils.ErrUnknownTheme
+	ErrUnsupportedFormat = utils.ErrUnsupportedFormat
+	ErrDecode            = utils.ErrDecode
+	ErrInvalidParameter  = utils.ErrInvalidParameter
+)
@@ -0,0 +1,52 @@
+// Package gowall exposes gowall's theme conversion, palette extraction and effects
+// so they can be embedded in other Go programs.
+//
+// Importing the package has no side effects. Themes from the user's gowall directories
+// are only read when ListThemes or SelectTheme is called, and the converters in this
+// package work in memory without writing to the gowall output folder.
+package gowall
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	gimage "github.com/Achno/gowall/internal/image"
+)
+
+// Processor transforms an image, every converter and effect in this package implements it
+type Processor interface {
+	Process(ctx context.Context, img image.Image) (image.Image, error)
+}
+
+// ProcessorFunc allows an ordinary function to be used as a Processor
+type ProcessorFunc func(ctx context.Context, img image.Image) (image.Image, error)
+
+func (f ProcessorFunc) Process(ctx context.Context, img image.Image) (image.Image, error) {
+	return f(ctx, img)
+}
+
+// Chain returns a Processor that runs the processors in order on the same in-memory image
+func Chain(processors ...Processor) Processor {
+	return ProcessorFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		current := img
+		for i, p := range processors {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			newImg, err := p.Process(ctx, current)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			current = newImg
+		}
+		return current, nil
+	})
+}
+
+// adapt wraps one of gowall's internal processors so it satisfies Processor
+func adapt(processor gimage.ImageProcessor) Processor {
+	return ProcessorFunc(func(ctx context.Context, img image.Image) (image.Image, error) {
+		return processor.Process(ctx, img, "")
+	})
+}
@@ -0,0 +1,21 @@
+package gowall
+
+import (
+	"context"
+	"image"
+	"image/color"
+
+	"github.com/Achno/gowall/internal/backends/colorthief"
+	"github.com/Achno/gowall/utils"
+)
+
+// ExtractPalette returns the dominant colors of the image using the median cut algorithm (like pywal)
+func ExtractPalette(ctx context.Context, img image.Image, numColors int) ([]color.Color, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if numColors < 1 {
+		return nil, utils.InvalidParameter("numColors must be at least 1")
+	}
+	return colorthief.GetPalette(img, numColors)
+}
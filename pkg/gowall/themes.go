@@ -0,0 +1,66 @@
+package gowall
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+
+	gimage "github.com/Achno/gowall/internal/image"
+	"github.com/Achno/gowall/utils"
+)
+
+// Theme is a named color palette that images can be converted to
+type Theme = gimage.Theme
+
+// NewTheme creates a theme from hex color codes like "#1E1E2E"
+func NewTheme(name string, hexColors []string) (Theme, error) {
+	if len(hexColors) == 0 {
+		return Theme{}, utils.InvalidParameter("theme %s has no colors", name)
+	}
+
+	colors, err := gimage.HexToRGBASlice(hexColors)
+	if err != nil {
+		return Theme{}, fmt.Errorf("theme %s: %w", name, err)
+	}
+
+	return Theme{Name: name, Colors: colors}, nil
+}
+
+// LoadThemeFile reads a theme file: gowall JSON or YAML, Emacs (.el), base16/base24, Alacritty, Kitty,
+// Xresources, iTerm2, Windows Terminal, Ghostty or VS Code. It is safe for concurrent use
+func LoadThemeFile(path string) (Theme, error) {
+	return gimage.ParseThemeFile(path)
+}
+
+// ListThemes returns the names of the themes available to the gowall CLI.
+// The first call reads the user's theme directories and config.yml. It is safe for concurrent use
+func ListThemes() []string {
+	return gimage.ListThemes()
+}
+
+// SelectTheme returns one of the themes available to the gowall CLI by name, or the theme of a file path.
+// It is safe for concurrent use
+func SelectTheme(name string) (Theme, error) {
+	return gimage.SelectTheme(name)
+}
+
+// HexColors returns the colors of a theme as hex color codes
+func HexColors(theme Theme) []string {
+	hexColors := make([]string, 0, len(theme.Colors))
+	for _, clr := range theme.Colors {
+		rgba := color.RGBAModel.Convert(clr).(color.RGBA)
+		hexColors = append(hexColors, gimage.RGBtoHex(rgba))
+	}
+	return hexColors
+}
+
+// ExportFormats returns the formats ExportTheme can write, like kitty, alacritty or base16
+func ExportFormats() []string {
+	return gimage.ExportFormats()
+}
+
+// ExportTheme writes the theme in the config format of a terminal, editor or desktop program.
+// The roles the theme doesn't have are filled from its colors
+func ExportTheme(w io.Writer, theme Theme, format string) error {
+	return gimage.ExportTheme(w, theme, format)
+}
@@ -0,0 +1,53 @@
+package gowall
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// a service loads and selects themes from its request handlers, run with -race
+func TestThemesConcurrently(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"nord.conf":  "background #2E3440\nforeground #D8DEE9\ncolor1 #BF616A\n",
+		"mocha.json": `{"name": "mocha", "colors": ["#1E1E2E", "#F38BA8", "#A6E3A1"]}`,
+		"dark.yaml":  "name: dark\ncolors: ['#000000', '#FFFFFF']\n",
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, path := range paths {
+				selected, err := SelectTheme(path)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				loaded, err := LoadThemeFile(path)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if len(selected.Colors) != len(loaded.Colors) {
+					t.Errorf("%s: selected %d colors, loaded %d", path, len(selected.Colors), len(loaded.Colors))
+				}
+			}
+			ListThemes()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes a file through write into a temporary file next to it and renames it into place
+// once it is complete, so readers never see a half written file and a failed write leaves no file behind.
+// A file that is replaced keeps its permissions, a new one is created with 0644
+func WriteFileAtomic(filePath string, write func(w io.Writer) error) error {
+	dir, base := filepath.Split(filePath)
+	if dir == "" {
+		dir = "."
+	}
+
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	err = write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// CreateTemp only lets the owner read the file
+		err = os.Chmod(tmpPath, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("while writing %s: %w", filePath, err)
+	}
+	return nil
+}
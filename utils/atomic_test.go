@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeString(s string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
+
+func TestWriteFileAtomicKeepsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "colors.sh")
+	if err := os.WriteFile(path, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(path, writeString("new")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("mode %v, want the 0755 of the replaced file", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("content %q, want %q", data, "new")
+	}
+}
+
+func TestWriteFileAtomicNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "colors.css")
+	if err := WriteFileAtomic(path, writeString("new")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode %v, want 0644", info.Mode().Perm())
+	}
+}
+
+func TestWriteFileAtomicFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "colors.css")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	err := WriteFileAtomic(path, func(w io.Writer) error { return failed })
+	if !errors.Is(err, failed) {
+		t.Fatalf("error %v, want %v", err, failed)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("content %q, the failed write replaced the file", data)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("%d files in the directory, the temporary file was left behind", len(entries))
+	}
+}
@@ -1,18 +1,32 @@
 package utils
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 )
 
-func Confirm(msg string) bool {
+// Confirm prints the yes/no question to w and reads the answer from stdin, only "y" is a yes.
+// Cancelling ctx stops waiting for the answer and returns its error
+func Confirm(ctx context.Context, w io.Writer, msg string) (bool, error) {
 
-	var input string
+	fmt.Fprintf(w, "%s (y/n): ", msg)
 
-	fmt.Printf("%s (y/n): ", msg)
-	fmt.Scanln(&input)
+	answer := make(chan string, 1)
+	go func() {
+		input, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer <- input
+	}()
 
-	input = strings.TrimSpace(strings.ToLower(input))
-
-	return input == "y"
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(w)
+		return false, ctx.Err()
+	case input := <-answer:
+		input = strings.TrimSpace(strings.ToLower(input))
+		return input == "y", nil
+	}
 }
@@ -1,6 +1,6 @@
 package utils
 
 // ANSI escape codes for color
-const redColor = "\033[31m"
+const RedColor = "\033[31m"
 const BlueColor = "\033[34m"
 const ResetColor = "\033[0m"
@@ -1,25 +1,101 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image"
 	"os"
 )
 
-// Prints the error in red and exits
-func HandleError(err error, msg ...string) {
-	if err != nil {
+// Classes of errors callers can react to, check them with errors.Is
+var (
+	ErrUnknownTheme      = errors.New("unknown theme")
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	ErrDecode            = errors.New("could not decode image")
+	ErrUpscalerMissing   = errors.New("the upscaler is not available")
+	ErrNetwork           = errors.New("network failure")
+	ErrInvalidParameter  = errors.New("invalid parameter")
+	ErrOutputExists      = errors.New("output file already exists")
+)
 
-		switch {
+// Exit codes of gowall, one per class of error
+const (
+	ExitOK                = 0
+	ExitError             = 1   // any other error
+	ExitInvalidParameter  = 2   // invalid flag, argument or parameter
+	ExitUnknownTheme      = 3   // the theme doesn't exist
+	ExitUnsupportedFormat = 4   // the output format can't be encoded
+	ExitDecode            = 5   // the input image is corrupt or not an image
+	ExitNotFound          = 6   // the input file doesn't exist
+	ExitUpscalerMissing   = 7   // the upscaler is not set up or its binary is missing
+	ExitNetwork           = 8   // a download failed
+	ExitOutputExists      = 9   // the output exists and the collision policy is "error"
+	ExitCancelled         = 130 // interrupted with Ctrl-C, like shells report SIGINT
+)
 
-		case len(msg) > 0:
-			fmt.Printf("%s %s: %s %s\n", redColor, msg[0], err, ResetColor)
+type errorClass struct {
+	err      error
+	category string
+	code     int
+}
 
-		default:
-			fmt.Printf("%s %s %s\n", redColor, err, ResetColor)
+// ordered from the most to the least specific, the first match wins
+var errorClasses = []errorClass{
+	{context.Canceled, "cancelled", ExitCancelled},
+	{context.DeadlineExceeded, "cancelled", ExitCancelled},
+	{ErrUnknownTheme, "unknown_theme", ExitUnknownTheme},
+	{ErrUnsupportedFormat, "unsupported_format", ExitUnsupportedFormat},
+	{ErrDecode, "decode", ExitDecode},
+	{image.ErrFormat, "decode", ExitDecode},
+	{ErrUpscalerMissing, "upscaler_missing", ExitUpscalerMissing},
+	{ErrNetwork, "network", ExitNetwork},
+	{ErrOutputExists, "output_exists", ExitOutputExists},
+	{ErrInvalidParameter, "invalid_parameter", ExitInvalidParameter},
+	{os.ErrNotExist, "not_found", ExitNotFound},
+}
 
+// ExitCode returns the exit code for the class of the error, ExitOK for nil
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for _, class := range errorClasses {
+		if errors.Is(err, class.err) {
+			return class.code
 		}
+	}
+	return ExitError
+}
+
+// ErrorCategory returns a short name for the class of the error, e.g. "unknown_theme", or "error"
+func ErrorCategory(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, class := range errorClasses {
+		if errors.Is(err, class.err) {
+			return class.category
+		}
+	}
+	return "error"
+}
+
+// InvalidParameter returns an error of the ErrInvalidParameter class
+func InvalidParameter(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidParameter, fmt.Sprintf(format, args...))
+}
+
+// Prints the error in red
+func PrintError(err error, msg ...string) {
+	switch {
+
+	case len(msg) > 0:
+		fmt.Fprintf(os.Stderr, "%s %s: %s %s\n", RedColor, msg[0], err, ResetColor)
+
+	default:
+		fmt.Fprintf(os.Stderr, "%s %s %s\n", RedColor, err, ResetColor)
 
-		os.Exit(1)
 	}
 }
 
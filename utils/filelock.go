@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockRetryInterval is how long LockFile waits before trying again to take a lock that is held
+const lockRetryInterval = 50 * time.Millisecond
+
+// LockFile takes an exclusive lock on the file at path, creating it if needed, and waits until it gets it
+// or ctx is cancelled. The lock is held across processes until unlock is called, or the process exits
+func LockFile(ctx context.Context, path string) (unlock func() error, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("while opening lock file: %w", err)
+	}
+
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("while locking %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, fmt.Errorf("while waiting for the lock on %s: %w", path, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	return func() error {
+		err := unlockFile(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFileWaitsForContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clut.lock")
+	unlock, err := LockFile(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock locks belong to the open file, so a second open in the same process waits like another process would
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := LockFile(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error %v while the lock is held, want context.DeadlineExceeded", err)
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = LockFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("locking after unlock: %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,293 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExpandOptions controls how directories and glob patterns are expanded to image files
+type ExpandOptions struct {
+	Recursive      bool     // Descend into subdirectories of a directory
+	Include        []string // Only keep files whose name or relative path matches one of these patterns
+	Exclude        []string // Drop files whose name or relative path matches one of these patterns
+	FollowSymlinks bool     // Descend into symlinked directories, symlinked files are always kept
+}
+
+// InputFile is an image found while expanding the inputs.
+// Rel is its path relative to the directory or glob root it was found under
+type InputFile struct {
+	Path string
+	Rel  string
+}
+
+// Image extensions gowall can decode
+var supportedExtensions = map[string]bool{
+	".png":  true,
+	".jpeg": true,
+	".jpg":  true,
+	".webp": true,
+}
+
+// IsSupportedImage checks the extension of the file name against the supported image formats
+func IsSupportedImage(name string) bool {
+	return supportedExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// Discards the last character of a string
+func DiscardLastCharacter(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+
+	// Decode the last rune
+	_, size := utf8.DecodeLastRuneInString(s)
+
+	// Exclude the last character
+	return s[:len(s)-size]
+}
+
+// HasGlobMeta reports whether the path contains any of the glob characters * ? [
+func HasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// ExpandInputs expands every input to image files. Directories are listed (recursively with
+// opts.Recursive), glob patterns like "~/Wallpapers/**/*.jpg" are matched and plain files are kept as is.
+// Files found twice are only returned once.
+func ExpandInputs(inputs []string, opts ExpandOptions) ([]InputFile, error) {
+	var files []InputFile
+	seen := make(map[string]bool)
+
+	for _, input := range ExpandHomeDirectory(inputs) {
+		var found []InputFile
+		var err error
+
+		info, statErr := os.Stat(input)
+		switch {
+		case statErr == nil && info.IsDir():
+			found, err = ExpandDirectory(input, opts)
+		case statErr != nil && HasGlobMeta(input):
+			found, err = ExpandGlob(input, opts)
+		case statErr != nil:
+			err = statErr
+		default:
+			found = []InputFile{{Path: input, Rel: filepath.Base(input)}}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("while expanding %s: %w", input, err)
+		}
+
+		for _, f := range found {
+			if !seen[f.Path] {
+				seen[f.Path] = true
+				files = append(files, f)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, InvalidParameter("no image files found")
+	}
+	return files, nil
+}
+
+// ExpandDirectory lists the image files of a directory, recursively when opts.Recursive is set
+//
+//	Example "~/Pictures/" -->[{"/home/user/Pictures/a/img1.png", "a/img1.png"}, ...]
+func ExpandDirectory(dir string, opts ExpandOptions) ([]InputFile, error) {
+	maxDepth := 1
+	if opts.Recursive {
+		maxDepth = -1
+	}
+
+	var files []InputFile
+	err := walkImages(dir, maxDepth, opts.FollowSymlinks, func(p, rel string) {
+		if keepFile(rel, opts) {
+			files = append(files, InputFile{Path: p, Rel: rel})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// ExpandGlob matches a shell style glob pattern where "**" matches any number of directories
+//
+//	Example "~/Wallpapers/**/*.jpg" --> every .jpg under ~/Wallpapers and its subdirectories
+func ExpandGlob(pattern string, opts ExpandOptions) ([]InputFile, error) {
+	root, rest := splitGlobRoot(pattern)
+	if len(rest) == 0 {
+		return nil, fmt.Errorf("not a glob pattern: %s", pattern)
+	}
+
+	// without "**" the pattern can't match deeper than its number of segments
+	maxDepth := len(rest)
+	for _, seg := range rest {
+		if seg == "**" {
+			maxDepth = -1
+			break
+		}
+	}
+
+	var files []InputFile
+	err := walkImages(root, maxDepth, opts.FollowSymlinks, func(p, rel string) {
+		if matchSegments(rest, strings.Split(rel, "/")) && keepFile(rel, opts) {
+			files = append(files, InputFile{Path: p, Rel: rel})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// splitGlobRoot splits a pattern into the directory before the first glob segment and the remaining segments
+func splitGlobRoot(pattern string) (string, []string) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+
+	for i, seg := range segments {
+		if HasGlobMeta(seg) {
+			root := strings.Join(segments[:i], "/")
+			switch {
+			case root == "" && i > 0:
+				root = "/"
+			case root == "":
+				root = "."
+			}
+			return filepath.FromSlash(root), segments[i:]
+		}
+	}
+	return pattern, nil
+}
+
+// matchSegments matches path segments against pattern segments, "**" matches zero or more segments
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// keepFile applies the include and exclude filters. Patterns with a "/" are matched against
+// the relative path, others against the file name
+func keepFile(rel string, opts ExpandOptions) bool {
+	matches := func(pattern string) bool {
+		pattern = filepath.ToSlash(pattern)
+		if strings.Contains(pattern, "/") {
+			return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+		}
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+
+	for _, pattern := range opts.Exclude {
+		if matches(pattern) {
+			return false
+		}
+	}
+
+	if len(opts.Include) == 0 {
+		return true
+	}
+	for _, pattern := range opts.Include {
+		if matches(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkImages calls visit for every supported image under root up to maxDepth levels deep (-1 = unlimited).
+// rel is the slash separated path relative to root. Symlinked directories are only entered when
+// follow is set and every directory is visited once, so symlink loops can't recurse forever.
+// A subdirectory that can't be read is skipped with a warning, only an unreadable root is an error
+func walkImages(root string, maxDepth int, follow bool, visit func(p, rel string)) error {
+	visited := make(map[string]bool)
+
+	var walk func(dir, relDir string, depth int) error
+	walk = func(dir, relDir string, depth int) error {
+		skip := func(err error) error {
+			if depth == 0 {
+				return err
+			}
+			log.Printf("skipping directory %s: %v", dir, err)
+			return fs.SkipDir
+		}
+
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return skip(err)
+		}
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return skip(err)
+		}
+
+		for _, entry := range entries {
+			p := filepath.Join(dir, entry.Name())
+			rel := path.Join(relDir, entry.Name())
+
+			isDir := entry.IsDir()
+			if entry.Type()&os.ModeSymlink != 0 {
+				info, err := os.Stat(p)
+				if err != nil {
+					continue // broken symlink
+				}
+				if info.IsDir() && !follow {
+					continue
+				}
+				isDir = info.IsDir()
+			}
+
+			if isDir {
+				if maxDepth < 0 || depth+1 < maxDepth {
+					if err := walk(p, rel, depth+1); err != nil && err != fs.SkipDir {
+						return err
+					}
+				}
+				continue
+			}
+
+			if IsSupportedImage(entry.Name()) {
+				visit(p, rel)
+			}
+		}
+		return nil
+	}
+
+	return walk(root, "", 0)
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandDirectorySkipsUnreadableSubdirectories(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions don't keep this user out of a directory")
+	}
+
+	dir := t.TempDir()
+	touch(t, filepath.Join(dir, "a.png"))
+	touch(t, filepath.Join(dir, "ok", "b.jpg"))
+	touch(t, filepath.Join(dir, "locked", "c.png"))
+	locked := filepath.Join(dir, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	files, err := ExpandInputs([]string{dir}, ExpandOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rels []string
+	for _, f := range files {
+		rels = append(rels, f.Rel)
+	}
+	if len(rels) != 2 || rels[0] != "a.png" || rels[1] != "ok/b.jpg" {
+		t.Errorf("found %v, want [a.png ok/b.jpg]", rels)
+	}
+
+	// the directory given as input has to be readable
+	if _, err := ExpandInputs([]string{locked}, ExpandOptions{}); err == nil {
+		t.Error("expanding an unreadable directory succeeded")
+	}
+}
+
+func TestExpandInputsNothingFound(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, filepath.Join(dir, "notes.txt"))
+
+	for _, input := range []string{dir, filepath.Join(dir, "*.png")} {
+		_, err := ExpandInputs([]string{input}, ExpandOptions{})
+		if !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("%s: error %v, want ErrInvalidParameter", input, err)
+		}
+	}
+}
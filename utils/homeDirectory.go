@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/Achno/gowall/config"
 )
 
-func CreateDirectory() (dirPath string, err error) {
+// OutputDirectory returns the output folder without creating it
+func OutputDirectory() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -18,22 +20,34 @@ func CreateDirectory() (dirPath string, err error) {
 	if config.GowallConfig.OutputFolder != "" {
 		folderName = config.GowallConfig.OutputFolder
 	}
-	dirPath = filepath.Join(homeDir, folderName)
+	dirPath := filepath.Join(homeDir, folderName)
 
 	// take XDG_PICTURES_DIR into account for non english file structures
 	env := os.Getenv("XDG_PICTURES_DIR")
 	if env != "" && config.GowallConfig.OutputFolder == "" {
 		dirPath = filepath.Join(env, "gowall")
 	}
+	return dirPath, nil
+}
 
-	err = os.MkdirAll(dirPath, 0777)
+// CacheDirectory returns the gowall cache folder, $XDG_CACHE_HOME/gowall or ~/.cache/gowall, without creating it
+func CacheDirectory() (string, error) {
+	cacheDir, err := os.UserCacheDir()
 	if err != nil {
-		return "", fmt.Errorf("while creating ~/OutputFolder: %w", err)
+		return "", err
 	}
+	return filepath.Join(cacheDir, "gowall"), nil
+}
 
-	err = os.MkdirAll(filepath.Join(dirPath, "cluts"), 0755)
+func CreateDirectory() (dirPath string, err error) {
+	dirPath, err = OutputDirectory()
 	if err != nil {
-		return "", fmt.Errorf("while creating ~/OutputFolder/cluts: %w", err)
+		return "", err
+	}
+
+	err = os.MkdirAll(dirPath, 0777)
+	if err != nil {
+		return "", fmt.Errorf("while creating ~/OutputFolder: %w", err)
 	}
 
 	err = os.MkdirAll(filepath.Join(dirPath, "gifs"), 0755)
@@ -43,3 +57,18 @@ func CreateDirectory() (dirPath string, err error) {
 
 	return dirPath, err
 }
+
+// Function to expand the tilde (~) to the full home directory path
+// @Example ~/Pictures/flowers.png --> /home/username/Pictures/flowers.png
+func ExpandHomeDirectory(paths []string) []string {
+	var expandedPaths []string
+	homeDir, _ := os.UserHomeDir()
+
+	for _, path := range paths {
+		if strings.HasPrefix(path, "~") {
+			path = filepath.Join(homeDir, path[1:])
+		}
+		expandedPaths = append(expandedPaths, path)
+	}
+	return expandedPaths
+}
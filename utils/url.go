@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 // opens a URL in your default browser of your operating system
@@ -44,16 +47,34 @@ func DownloadUrl(url, dest string) error {
 		return fmt.Errorf("failed to download file: status code %d", res.StatusCode)
 	}
 
-	out, err := os.Create(dest)
+	err = WriteFileAtomic(dest, func(w io.Writer) error {
+		_, err := io.Copy(w, res.Body)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
+		return fmt.Errorf("failed to write file: %v", err)
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, res.Body)
+	return nil
+}
+
+func GetFileExtensionFromURL(rawurl string) (string, error) {
+	parsedURL, err := url.Parse(rawurl)
 	if err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
+		return "", fmt.Errorf("could not parse URL: %w", err)
 	}
 
-	return nil
+	filePath := parsedURL.Path
+
+	// Extract filename
+	fileName := path.Base(filePath)
+
+	// Remove query parameters, if any
+	if idx := strings.Index(fileName, "?"); idx != -1 {
+		fileName = fileName[:idx]
+	}
+
+	// Get the file extension
+	extension := filepath.Ext(fileName)
+	return extension, nil
 }